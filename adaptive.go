@@ -0,0 +1,83 @@
+package main
+
+import "math"
+
+// adaptiveMinimum is the fewest samples a pixel takes before its noise is
+// judged, and adaptiveThreshold the standard error of its mean luminance
+// below which it stops sampling, about a quarter of an 8-bit level.
+const (
+	adaptiveMinimum   = 4
+	adaptiveThreshold = 0.001
+)
+
+// pixelEstimate accumulates the samples of one pixel, with the running
+// moments of their luminance to estimate how noisy the mean still is, and
+// the number of them that hit the scene.
+type pixelEstimate struct {
+	sum  Color
+	lum  float64
+	lum2 float64
+	n    int
+	hits int
+}
+
+func (p *pixelEstimate) add(c Color) {
+	p.sum.r, p.sum.g, p.sum.b = p.sum.r+c.r, p.sum.g+c.g, p.sum.b+c.b
+	y := luminance(c)
+	p.lum += y
+	p.lum2 += y * y
+	p.n++
+}
+
+func (p *pixelEstimate) mean() Color {
+	return WeightColor(p.sum, 1/float64(p.n))
+}
+
+// alpha returns the pixel's coverage if the scene leaves out the
+// background, and otherwise 1.
+func (p *pixelEstimate) alpha(scene *Scene) float64 {
+	if !scene.alpha {
+		return 1
+	}
+	return float64(p.hits) / float64(p.n)
+}
+
+// noisy reports whether the standard error of the pixel's mean luminance is
+// above adaptiveThreshold.
+func (p *pixelEstimate) noisy() bool {
+	n := float64(p.n)
+	if p.n < 2 {
+		return true
+	}
+	mean := p.lum / n
+	variance := math.Max(0, (p.lum2-n*mean*mean)/(n-1))
+	return variance/n > adaptiveThreshold*adaptiveThreshold
+}
+
+// refine keeps adding jittered samples to pixel (x, y) while its estimate
+// is noisy, up to scene.max_samples in all. Edges and glossy or soft
+// shadowed regions take more rays, while flat regions stop early.
+func (p *pixelEstimate) refine(scene *Scene, camera *Camera, x int, y int, max_recursion_depth int) {
+	rng := scene.rng
+	for p.n < scene.max_samples && p.noisy() {
+		dx, dy := rng.Float64()-0.5, rng.Float64()-0.5
+		var c Color
+		if O, D, ok := camera.Ray(rng, float64(x)+dx, float64(y)+dy); ok {
+			began := scene.timings.start(primaryRays)
+			object, t := ClosestIntersection(scene, O, D, 1, math.Inf(1))
+			scene.timings.stop(primaryRays, began)
+			if object != nil {
+				p.hits++
+			}
+			if object != nil || !scene.alpha {
+				c = ShadeHit(scene, O, D, object, t, max_recursion_depth)
+			}
+		}
+		p.add(scene.ClampSample(c))
+	}
+}
+
+// luminance is the Rec. 709 brightness of a color.
+func luminance(c Color) float64 {
+	return 0.2126*c.r + 0.7152*c.g + 0.0722*c.b
+}
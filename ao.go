@@ -0,0 +1,50 @@
+package main
+
+import "math"
+
+// aoSamples is the number of hemisphere rays per hit in the ambient
+// occlusion integrator.
+const aoSamples = 16
+
+// ShadeOcclusion is ShadeHit for the "ao" integrator: surfaces are white,
+// darkened by how much of the hemisphere above them is blocked by nearby
+// geometry, and rays that escape see a white sky.
+func ShadeOcclusion(scene *Scene, origin Vector, direction Vector, best_object Primitive, best_t float64) Color {
+	if best_object == nil {
+		return MakeColor(1, 1, 1)
+	}
+	point := add(origin, scale(direction, best_t))
+	normal := best_object.NormalAt(point)
+	if dot(normal, direction) > 0 {
+		normal = neg(normal)
+	}
+	normal = best_object.Material().ShadingNormal(best_object, point, normal)
+	k := AmbientOcclusion(scene, point, normal)
+	return MakeColor(k, k, k)
+}
+
+// AmbientOcclusion returns the fraction of cosine-weighted rays from a point
+// that travel scene.ao_distance, or forever if that is 0, without hitting
+// anything. Volumes, with no normal, are tested over the whole sphere.
+func AmbientOcclusion(scene *Scene, point Vector, normal Vector) float64 {
+	t_max := scene.ao_distance
+	if t_max <= 0 {
+		t_max = math.Inf(1)
+	}
+	open := 0
+	for i := 0; i < aoSamples; i++ {
+		var direction Vector
+		if normal == (Vector{}) {
+			direction = SphereSample(scene.rng)
+		} else {
+			direction = CosineSample(scene.rng, normal)
+		}
+		began := scene.timings.start(shadowRays)
+		object, _ := ClosestIntersection(scene, point, direction, scene.Bias(point), t_max)
+		scene.timings.stop(shadowRays, began)
+		if object == nil {
+			open++
+		}
+	}
+	return float64(open) / aoSamples
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// Features are the arbitrary output variables of a render: for the first
+// surface seen through each pixel center, row by row from the top, its
+// distance along the camera ray, world-space shading normal and unlit
+// color, and the IDs of the scene object and material it belongs to.
+// Pixels that see the environment take infinite depth, a zero normal, the
+// environment's color as albedo and IDs of 0.
+//
+// Object IDs number scene.objects from 1, and material IDs number
+// materials from 1 in the order the pixels first show them.
+type Features struct {
+	depth       []float64
+	normal      []Color
+	albedo      []Color
+	object_id   []int
+	material_id []int
+	objects     []Object
+	materials   []*Material
+}
+
+// RenderFeatures traces a ray through every pixel center for its features.
+func RenderFeatures(scene *Scene, camera *Camera) Features {
+	scene.CacheOrigin(camera.position)
+	f := Features{depth: make([]float64, Cw*Ch), normal: make([]Color, Cw*Ch), albedo: make([]Color, Cw*Ch)}
+	f.object_id = make([]int, Cw*Ch)
+	f.material_id = make([]int, Cw*Ch)
+	f.objects = scene.objects
+	material_ids := map[*Material]int{}
+	for i := range f.depth {
+		f.depth[i] = math.Inf(1)
+	}
+	for x := -Cw / 2; x < Cw/2; x++ {
+		for y := -Ch / 2; y < Ch/2; y++ {
+			i, j := ChangeCoord2D(x, y)
+			if j < 0 || j >= Ch {
+				continue
+			}
+			scene.seedPixel(x, y, featureStream)
+			O, D, ok := camera.Ray(scene.rng, float64(x), float64(y))
+			if !ok {
+				continue
+			}
+			object, t := ClosestIntersection(scene, O, D, 1, math.Inf(1))
+			if object == nil {
+				if scene.environment != nil {
+					f.albedo[j*Cw+i] = ClampColor(scene.environment.Sample(D))
+				}
+				continue
+			}
+			point := add(O, scale(D, t))
+			material := object.Material()
+			n := object.NormalAt(point)
+			if dot(n, D) > 0 {
+				n = neg(n)
+			}
+			n = material.ShadingNormal(object, point, n)
+			f.depth[j*Cw+i] = t * norm(D)
+			f.albedo[j*Cw+i] = material.ColorAt(object, point)
+			f.normal[j*Cw+i] = Color{n.x, n.y, n.z}
+			f.object_id[j*Cw+i] = objectID(scene, O, D, t)
+			if _, ok := material_ids[material]; !ok {
+				f.materials = append(f.materials, material)
+				material_ids[material] = len(f.materials)
+			}
+			f.material_id[j*Cw+i] = material_ids[material]
+		}
+	}
+	return f
+}
+
+// objectID returns the ID of the scene object a ray hits first at t,
+// found again among the objects themselves, as the accelerator only
+// returns the primitive struck within them.
+func objectID(scene *Scene, origin Vector, direction Vector, t float64) int {
+	for i, object := range scene.objects {
+		if t2, ok := object.Intersect(origin, direction, 1, math.Inf(1)); ok && math.Abs(t2-t) <= 1e-9*math.Max(1, t) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// idColor returns a distinct color for each ID, and black for 0, so
+// neighboring objects in an ID pass stand apart. Hues step around the
+// color wheel by the golden ratio, which keeps consecutive IDs far apart.
+func idColor(id int) Color {
+	if id == 0 {
+		return Color{}
+	}
+	hue := math.Mod(float64(id)*0.618034, 1) * 6
+	x := 1 - math.Abs(math.Mod(hue, 2)-1)
+	var c Color
+	switch int(hue) {
+	case 0:
+		c = Color{1, x, 0}
+	case 1:
+		c = Color{x, 1, 0}
+	case 2:
+		c = Color{0, 1, x}
+	case 3:
+		c = Color{0, x, 1}
+	case 4:
+		c = Color{x, 0, 1}
+	default:
+		c = Color{1, 0, x}
+	}
+	return Color{0.15 + 0.8*c.r, 0.15 + 0.8*c.g, 0.15 + 0.8*c.b}
+}
+
+// WriteIDs writes a text file listing what each object and material ID
+// stands for, with the color the ID passes show it in.
+func (f *Features) WriteIDs(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(file)
+	hex := func(c Color) string {
+		return fmt.Sprintf("#%02x%02x%02x", int(c.r*255), int(c.g*255), int(c.b*255))
+	}
+	for i, object := range f.objects {
+		name := strings.TrimPrefix(fmt.Sprintf("%T", object), "*main.")
+		fmt.Fprintf(out, "object %d: %s, shown %s\n", i+1, name, hex(idColor(i+1)))
+	}
+	for i, m := range f.materials {
+		model := m.model
+		if model == "" {
+			model = "phong"
+		}
+		fmt.Fprintf(out, "material %d: %s, color %.3g %.3g %.3g, shown %s\n", i+1, model, m.color.r, m.color.g, m.color.b, hex(idColor(i+1)))
+	}
+	if err := out.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Canvases returns a canvas for each feature, by name, holding the raw
+// values for float formats and shown for 8-bit ones with the nearest
+// surface white and the background black, normals mapped from [-1, 1] and
+// albedo as it is.
+func (f *Features) Canvases() map[string]*Canvas {
+	nearest := math.Inf(1)
+	for _, d := range f.depth {
+		nearest = math.Min(nearest, d)
+	}
+	depth := make([]Color, len(f.depth))
+	for i, d := range f.depth {
+		depth[i] = Color{d, d, d}
+	}
+	ids := func(values []int) []Color {
+		pixels := make([]Color, len(values))
+		for i, id := range values {
+			v := float64(id)
+			pixels[i] = Color{v, v, v}
+		}
+		return pixels
+	}
+	show_id := func(c Color) Color { return idColor(int(c.r)) }
+	return map[string]*Canvas{
+		"object_id":   featureCanvas(ids(f.object_id), show_id),
+		"material_id": featureCanvas(ids(f.material_id), show_id),
+		"depth": featureCanvas(depth, func(c Color) Color {
+			v := nearest / c.r // inverse depth, as far surfaces crowd together
+			return Color{v, v, v}
+		}),
+		"normal": featureCanvas(f.normal, func(c Color) Color {
+			if c == (Color{}) {
+				return c
+			}
+			return Color{(c.r + 1) / 2, (c.g + 1) / 2, (c.b + 1) / 2}
+		}),
+		"albedo": featureCanvas(f.albedo, func(c Color) Color { return c }),
+	}
+}
+
+// featureCanvas returns a Cw x Ch canvas of raw pixels, drawn through
+// display.
+func featureCanvas(pixels []Color, display func(Color) Color) *Canvas {
+	c := &Canvas{width: Cw, height: Ch, radiance: pixels, alpha: make([]float64, Cw*Ch), encode: display}
+	for i := range c.alpha {
+		c.alpha[i] = 1
+	}
+	return c
+}
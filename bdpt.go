@@ -0,0 +1,303 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// maxSubpathVertices is the number of diffuse vertices kept on each of a
+// bidirectional path's eye and light subpaths.
+const maxSubpathVertices = 4
+
+// subpathVertex is a diffuse hit on an eye or light subpath. throughput is
+// the product of the path's weights up to and including arriving there,
+// and edges counts the connectable edges before it, between consecutive
+// diffuse vertices (or the light and the first vertex) with no mirror or
+// glass bounce between them.
+type subpathVertex struct {
+	point      Vector
+	normal     Vector
+	diffuse    Color
+	throughput Color
+	edges      int
+}
+
+// bidirectionalTracer is the integrator chosen by scene.integrator "bdpt".
+// Each sample traces a subpath from the camera and another from a light,
+// and joins every vertex of one to every vertex of the other with a shadow
+// ray, as well as sampling the lights directly from the eye vertices. Light
+// that a small light sends onto a wall and from there into the view, hard
+// to find by bouncing from the eye alone, is then found from both ends.
+//
+// A path made of V connectable edges could have been joined at any of them,
+// so each connection is weighted by 1/V, which keeps the estimate unbiased
+// for paths within the subpath length limits.
+// As in Lighting, light leaves point and area lights without falling off
+// with distance; light between surfaces does.
+type bidirectionalTracer struct {
+	pathTracer
+	lights []*Light // point, spot and area lights
+	total  float64  // of their luminances
+}
+
+func makeBidirectionalTracer(scene *Scene) bidirectionalTracer {
+	b := bidirectionalTracer{pathTracer: makePathTracer(scene)}
+	for _, light := range b.scene.lights {
+		switch light.kind {
+		case PointLight, SpotLight, RectLight, DiskLight:
+			b.lights = append(b.lights, light)
+			b.total += luminance(light.intensity)
+		}
+	}
+	return b
+}
+
+// lightSubpath traces a path from a light chosen by luminance with u in
+// [0, 1), returning its diffuse vertices.
+func (b *bidirectionalTracer) lightSubpath(u float64) []subpathVertex {
+	if b.total <= 0 {
+		return nil
+	}
+	pick := u * b.total
+	light := b.lights[len(b.lights)-1]
+	for _, l := range b.lights {
+		if pick -= luminance(l.intensity); pick < 0 {
+			light = l
+			break
+		}
+	}
+	origin := light.position
+	if light.kind == RectLight || light.kind == DiskLight {
+		origin = light.AreaSample(b.scene.rng.Float64(), b.scene.rng.Float64())
+	}
+	direction := SphereSample(b.scene.rng)
+	// π converts intensity to the radiometric units of a π-less BRDF.
+	throughput := WeightColor(light.intensity, 4*math.Pi*math.Pi*b.total/luminance(light.intensity))
+	if light.kind == SpotLight {
+		throughput = WeightColor(throughput, light.SpotFactor(direction))
+	}
+
+	var vertices []subpathVertex
+	edges, connectable := 0, true
+	for bounce := 0; bounce < maxPathBounces && len(vertices) < maxSubpathVertices; bounce++ {
+		object, t := ClosestIntersection(b.scene, origin, direction, b.scene.Bias(origin), math.Inf(1))
+		if object == nil {
+			break
+		}
+		point := add(origin, scale(direction, t))
+		if bounce == 0 {
+			throughput = WeightColor(throughput, t*t) // no falloff from the light
+		}
+		e, ok := scatter(b.scene.rng, object, point, direction)
+		if !ok {
+			break // volumes only scatter on eye subpaths
+		}
+		origin, direction = point, e.direction
+		if e.specular {
+			throughput = MultiplyColors(throughput, e.weight)
+			connectable = false
+			continue
+		}
+		if connectable {
+			edges++
+		}
+		vertices = append(vertices, subpathVertex{point, e.normal, WeightColor(e.diffuse, 1/e.pick), throughput, edges})
+		throughput = MultiplyColors(throughput, e.weight)
+		connectable = true
+	}
+	return vertices
+}
+
+// Trace returns the light arriving along a camera ray, as pathTracer.Trace
+// does, but following at most maxSubpathVertices diffuse bounces. u picks
+// the light the light subpath starts from.
+func (b *bidirectionalTracer) Trace(origin Vector, direction Vector, t_min float64, u float64) Color {
+	scene := b.scene
+	light_path := b.lightSubpath(u)
+	throughput := Color{1, 1, 1}
+	var radiance Color
+	gather := func(c Color, weight float64) {
+		radiance.r += throughput.r * c.r * weight
+		radiance.g += throughput.g * c.g * weight
+		radiance.b += throughput.b * c.b * weight
+	}
+
+	edges, connectable, diffuse_vertices := 0, false, 0
+	for bounce := 0; bounce < maxPathBounces; bounce++ {
+		var began time.Time
+		if bounce == 0 {
+			began = scene.timings.start(primaryRays)
+		}
+		object, t := ClosestIntersection(scene, origin, direction, t_min, math.Inf(1))
+		scene.timings.stop(primaryRays, began)
+		if object == nil {
+			if scene.environment != nil {
+				gather(scene.environment.Sample(direction), 1)
+			} else {
+				gather(b.ambient, 1)
+			}
+			break
+		}
+		material := object.Material()
+		point := add(origin, scale(direction, t))
+		t_min = scene.Bias(point)
+		gather(material.emission, 1)
+		e, ok := scatter(b.scene.rng, object, point, direction)
+		if !ok {
+			// A volume: light it directly, and as light subpaths stop at
+			// volumes, no earlier edge can be connected across it.
+			intensity, _ := Lighting(scene, point, Vector{}, neg(direction), -1)
+			gather(MultiplyColors(material.ColorAt(object, point), intensity), 1)
+			throughput = MultiplyColors(throughput, material.ColorAt(object, point))
+			origin, direction = point, SphereSample(scene.rng)
+			edges, connectable = 0, false
+			continue
+		}
+		if e.specular {
+			throughput = MultiplyColors(throughput, e.weight)
+			origin, direction = point, e.direction
+			connectable = false
+			continue
+		}
+		if connectable {
+			edges++
+		}
+
+		// Direct light, joined to the light itself
+		view := neg(direction)
+		var direct Color
+		if material.model == "pbr" {
+			direct = LightingPBR(scene, object, point, e.normal, view, material, e.albedo)
+		} else {
+			intensity, highlight := Lighting(scene, point, e.normal, view, material.specular)
+			specular := e.albedo
+			if material.tinted {
+				specular = material.tint
+			}
+			direct = Color{e.albedo.r*intensity.r + specular.r*highlight.r, e.albedo.g*intensity.g + specular.g*highlight.g, e.albedo.b*intensity.b + specular.b*highlight.b}
+		}
+		gather(direct, 1/(e.pick*float64(edges+1)))
+
+		// Joins to every light subpath vertex
+		for _, v := range light_path {
+			offset := sub(v.point, point)
+			d2 := dot(offset, offset)
+			if d2 < 1e-8 {
+				continue
+			}
+			L := scale(offset, 1/math.Sqrt(d2))
+			cos_e, cos_l := dot(e.normal, L), -dot(v.normal, L)
+			if cos_e <= 0 || cos_l <= 0 {
+				continue
+			}
+			began := scene.timings.start(shadowRays)
+			blocker, _ := ClosestIntersection(scene, point, offset, scene.Bias(point)/math.Sqrt(d2), 1-scene.Bias(v.point)/math.Sqrt(d2))
+			scene.timings.stop(shadowRays, began)
+			if blocker != nil {
+				continue
+			}
+			c := MultiplyColors(WeightColor(e.diffuse, 1/(math.Pi*e.pick)), WeightColor(v.diffuse, 1/math.Pi))
+			c = WeightColor(MultiplyColors(c, v.throughput), cos_e*cos_l/d2)
+			gather(c, 1/float64(edges+v.edges+1))
+		}
+
+		diffuse_vertices++
+		if diffuse_vertices >= maxSubpathVertices {
+			break
+		}
+		throughput = MultiplyColors(throughput, e.weight)
+		origin, direction = point, e.direction
+		connectable = true
+	}
+	return radiance
+}
+
+// scatterEvent is the outcome of a ray meeting a surface: a mirror or glass
+// bounce, or a diffuse hit going on in a cosine-sampled direction. weight
+// is the factor the path's throughput takes for the new direction. On PBR
+// surfaces diffuse hits do not replace the mirror reflection but share the
+// path with it, so light reflected at a diffuse hit is scaled by the
+// inverse of its probability, pick.
+type scatterEvent struct {
+	specular  bool
+	direction Vector
+	weight    Color
+	normal    Vector
+	albedo    Color
+	diffuse   Color
+	pick      float64
+}
+
+// scatter picks what happens to a ray hitting the object at point, with
+// the probabilities pathTracer uses. It returns false for volumes, which
+// have no surface to scatter from.
+func scatter(rng *rand.Rand, object Primitive, point Vector, direction Vector) (scatterEvent, bool) {
+	var e scatterEvent
+	material := object.Material()
+	geometric := object.NormalAt(point)
+	if geometric == (Vector{}) {
+		return e, false
+	}
+	normal := geometric
+	if dot(normal, direction) > 0 {
+		normal = neg(normal)
+	}
+	normal = material.ShadingNormal(object, point, normal)
+	albedo := material.ColorAt(object, point)
+	view := neg(direction)
+	cos_i := -dot(normal, normalize(direction))
+	e.normal = normal
+	e.albedo = albedo
+	e.weight = Color{1, 1, 1}
+	e.pick = 1
+
+	if material.transparency > 0 && rng.Float64() < material.transparency {
+		n1, n2 := 1.0, material.ior
+		if dot(geometric, direction) > 0 {
+			n1, n2 = material.ior, 1.0
+		}
+		e.specular = true
+		e.direction = ReflectRay(view, normal)
+		if T, ok := RefractRay(direction, normal, n1/n2); ok {
+			cos := cos_i
+			if n1 > n2 {
+				cos = -dot(normal, T)
+			}
+			if rng.Float64() >= Schlick(math.Pow((n1-n2)/(n1+n2), 2), cos) {
+				e.direction = T
+			}
+		}
+		return e, true
+	}
+	if material.model != "pbr" && material.reflective > 0 && rng.Float64() < Schlick(material.reflective, cos_i) {
+		e.specular = true
+		if material.tinted {
+			e.weight = material.tint
+		}
+		e.direction = glossyDirection(rng, ReflectRay(view, normal), normal, material.roughness)
+		return e, true
+	}
+
+	e.diffuse = albedo
+	if material.model == "pbr" {
+		e.diffuse = WeightColor(albedo, 1-material.metallic)
+	}
+	mirror := 0.0
+	var F Color
+	if material.Mirrors() {
+		gloss := (1 - material.roughness) * (1 - material.roughness)
+		F = WeightColor(SchlickColor(material.F0(albedo), cos_i), gloss)
+		mirror = (F.r + F.g + F.b) / 3
+	}
+	if mirror > 0 && rng.Float64() < mirror {
+		e.specular = true
+		e.weight = WeightColor(F, 1/mirror)
+		e.direction = glossyDirection(rng, ReflectRay(view, normal), normal, material.roughness)
+		return e, true
+	}
+	e.pick = 1 - mirror
+	e.weight = WeightColor(e.diffuse, 1/e.pick)
+	e.direction = CosineSample(rng, normal)
+	return e, true
+}
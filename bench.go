@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// A benchScene is one of the fixed scenes -bench and the benchmarks render,
+// at the canvas's fixed size, the default seed and flag settings, to
+// measure the tracer's speed in rays per second from one change to the
+// next.
+type benchScene struct {
+	name  string
+	build func() (*Scene, *Camera)
+}
+
+var benchScenes = []benchScene{
+	{"spheres", spheresBenchScene},
+	{"mesh", meshBenchScene},
+	{"lights", lightsBenchScene},
+}
+
+// makeBenchScene returns a scene of the objects and lights with main's
+// defaults, ready to render, and main's default camera.
+func makeBenchScene(objects []Object, lights []*Light) (*Scene, *Camera) {
+	camera := MakeCamera(MakeVector(0, 0, -3), MakeVector(0, 0, 0), MakeVector(0, 1, 0))
+	scene := &Scene{objects: objects, lights: lights, accelerator: "bvh", samples: 1}
+	scene.cameras = map[string]*Camera{"default": &camera}
+	scene.seed, scene.rng, scene.rays = 1, newRNG(), new(int64)
+	scene.epsilon, scene.relative_epsilon, scene.ao_distance = 0.001, 1e-7, 1
+	scene.integrator, scene.tonemap, scene.encoding, scene.gamma = "whitted", "none", "linear", 2.2
+	scene.tile_size = defaultTileSize
+	scene.BuildAccelerator()
+	return scene, &camera
+}
+
+// spheresObjects returns the spheres and ground main draws without flags.
+func spheresObjects() []Object {
+	red := MakeMaterial(MakeColor(1.0, 0, 0), 500, 0.2)
+	blue := MakeMaterial(MakeColor(0., 0., 1.0), 500, 0.3)
+	green := MakeMaterial(MakeColor(0., 1.0, 0.), 10, 0.4)
+	yellow := MakeMaterial(MakeColor(1.0, 1.0, 0), 1000, 0.5)
+	s1 := MakeSphere(MakeVector(0, -1, 3), 1, &red)
+	s2 := MakeSphere(MakeVector(2, 0, 4), 1, &blue)
+	s3 := MakeSphere(MakeVector(-2, 0, 4), 1, &green)
+	p1 := MakePlane(MakeVector(0, -1, 0), MakeVector(0, 1, 0), &yellow)
+	return []Object{&s1, &s2, &s3, &p1}
+}
+
+// spheresLights returns the lights main draws without flags.
+func spheresLights() []*Light {
+	l1 := MakeAmbientLight(MakeColor(0.2, 0.2, 0.2))
+	l2 := MakePointLight(MakeColor(0.6, 0.6, 0.6), MakeVector(2, 1, 0))
+	l3 := MakeDirectionalLight(MakeColor(0.2, 0.2, 0.2), MakeVector(1, 4, 4))
+	return []*Light{&l1, &l2, &l3}
+}
+
+// spheresBenchScene is main's scene without flags.
+func spheresBenchScene() (*Scene, *Camera) {
+	return makeBenchScene(spheresObjects(), spheresLights())
+}
+
+// meshBenchScene is main's scene over rolling terrain of about 80,000
+// triangles, for the accelerator's speed on meshes.
+func meshBenchScene() (*Scene, *Camera) {
+	const n = 200
+	heights := make([][]float64, n)
+	for j := range heights {
+		heights[j] = make([]float64, n)
+		for i := range heights[j] {
+			x, z := float64(i)/n, float64(j)/n
+			heights[j][i] = 0.5 + 0.25*math.Sin(9*x)*math.Cos(7*z) + 0.25*math.Sin(23*x+17*z)
+		}
+	}
+	grass := MakeMaterial(MakeColor(0.4, 0.7, 0.3), -1, 0)
+	terrain := MakeTerrain(heights, MakeVector(-6, -1, 0), MakeVector(12, 1.5, 12), &grass)
+	return makeBenchScene(append(spheresObjects(), terrain.Faces()...), spheresLights())
+}
+
+// lightsBenchScene is main's scene lit by a ring of 16 dim point lights
+// as well, for the cost of shadow rays.
+func lightsBenchScene() (*Scene, *Camera) {
+	lights := spheresLights()
+	for i := 0; i < 16; i++ {
+		angle := 2 * math.Pi * float64(i) / 16
+		light := MakePointLight(MakeColor(0.04, 0.04, 0.04), MakeVector(4*math.Cos(angle), 2, 3+4*math.Sin(angle)))
+		lights = append(lights, &light)
+	}
+	return makeBenchScene(spheresObjects(), lights)
+}
+
+// renderBench renders the scene, returning the rays traced.
+func renderBench(scene *Scene, camera *Camera) int64 {
+	var rays int64
+	scene.progress = func(_ float64, traced int64, _ time.Duration) { rays = traced }
+	Render(scene, camera, 3)
+	return rays
+}
+
+// Bench renders each of the benchScenes in turn, writing the time each
+// takes and its rays per second to w.
+func Bench(w io.Writer) {
+	for _, b := range benchScenes {
+		scene, camera := b.build()
+		began := time.Now()
+		rays := renderBench(scene, camera)
+		elapsed := time.Since(began)
+		fmt.Fprintf(w, "%-8s %10v %8.2f Mrays/s\n", b.name, elapsed.Round(time.Millisecond), float64(rays)/elapsed.Seconds()/1e6)
+	}
+}
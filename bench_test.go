@@ -0,0 +1,21 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func benchmarkScene(b *testing.B, build func() (*Scene, *Camera)) {
+	scene, camera := build()
+	b.ResetTimer()
+	began := time.Now()
+	var rays int64
+	for i := 0; i < b.N; i++ {
+		rays += renderBench(scene, camera)
+	}
+	b.ReportMetric(float64(rays)/time.Since(began).Seconds()/1e6, "Mrays/s")
+}
+
+func BenchmarkSpheres(b *testing.B) { benchmarkScene(b, spheresBenchScene) }
+func BenchmarkMesh(b *testing.B)    { benchmarkScene(b, meshBenchScene) }
+func BenchmarkLights(b *testing.B)  { benchmarkScene(b, lightsBenchScene) }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// BezierPatch is a bicubic Bézier surface given by a 4x4 grid of control
+// points in row-major order.
+type BezierPatch [16]Vector
+
+// patchDivisions is how finely LoadModel tessellates each patch.
+const patchDivisions = 8
+
+// Eval returns the point on the patch at parameters u, v in [0, 1].
+func (p *BezierPatch) Eval(u float64, v float64) Vector {
+	bu, bv := bernstein3(u), bernstein3(v)
+	var point Vector
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			point = add(point, scale(p[i*4+j], bu[i]*bv[j]))
+		}
+	}
+	return point
+}
+
+func bernstein3(t float64) [4]float64 {
+	s := 1 - t
+	return [4]float64{s * s * s, 3 * t * s * s, 3 * t * t * s, t * t * t}
+}
+
+// TessellatePatches subdivides every patch into a divisions x divisions grid
+// of quads, two triangles each, and joins them into one mesh. Faces that
+// collapse to zero area, as at the poles of the teapot lid, are dropped.
+func TessellatePatches(patches []BezierPatch, divisions int, material *Material) Mesh {
+	var vertices []Vector
+	var faces [][3]int
+	n := divisions + 1
+	for p := range patches {
+		base := len(vertices)
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				u, v := float64(i)/float64(divisions), float64(j)/float64(divisions)
+				vertices = append(vertices, patches[p].Eval(u, v))
+			}
+		}
+		for i := 0; i < divisions; i++ {
+			for j := 0; j < divisions; j++ {
+				a := base + i*n + j
+				b, c, d := a+1, a+n, a+n+1
+				for _, face := range [2][3]int{{a, c, b}, {b, c, d}} {
+					v0, v1, v2 := vertices[face[0]], vertices[face[1]], vertices[face[2]]
+					if norm(cross(sub(v1, v0), sub(v2, v0))) > 1e-12 {
+						faces = append(faces, face)
+					}
+				}
+			}
+		}
+	}
+	return MakeMesh(vertices, faces, material)
+}
+
+// LoadBezierPatches reads patches in the format of Newell's teapot data:
+// a patch count, one line of 16 one-based control point indices per patch,
+// a vertex count, then one "x, y, z" line per vertex.
+func LoadBezierPatches(path string) ([]BezierPatch, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines [][]string
+	var line_nos []int
+	scanner := bufio.NewScanner(f)
+	for line_no := 1; scanner.Scan(); line_no++ {
+		fields := strings.FieldsFunc(scanner.Text(), func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+		if len(fields) > 0 {
+			lines = append(lines, fields)
+			line_nos = append(line_nos, line_no)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	next := 0
+	count := func(what string) (int, error) {
+		if next >= len(lines) || len(lines[next]) != 1 {
+			return 0, fmt.Errorf("%s: expected %s count", path, what)
+		}
+		n, err := strconv.Atoi(lines[next][0])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%s:%d: bad %s count %q", path, line_nos[next], what, lines[next][0])
+		}
+		next++
+		return n, nil
+	}
+
+	num_patches, err := count("patch")
+	if err != nil {
+		return nil, err
+	}
+	indices := make([][16]int, num_patches)
+	for p := range indices {
+		if next >= len(lines) || len(lines[next]) != 16 {
+			return nil, fmt.Errorf("%s: patch %d needs 16 indices", path, p+1)
+		}
+		for i, field := range lines[next] {
+			if indices[p][i], err = strconv.Atoi(field); err != nil {
+				return nil, fmt.Errorf("%s:%d: bad index %q", path, line_nos[next], field)
+			}
+		}
+		next++
+	}
+
+	num_vertices, err := count("vertex")
+	if err != nil {
+		return nil, err
+	}
+	vertices := make([]Vector, num_vertices)
+	for v := range vertices {
+		if next >= len(lines) || len(lines[next]) != 3 {
+			return nil, fmt.Errorf("%s: vertex %d needs 3 coordinates", path, v+1)
+		}
+		var xyz [3]float64
+		for i, field := range lines[next] {
+			if xyz[i], err = strconv.ParseFloat(field, 64); err != nil {
+				return nil, fmt.Errorf("%s:%d: bad coordinate %q", path, line_nos[next], field)
+			}
+		}
+		vertices[v] = MakeVector(xyz[0], xyz[1], xyz[2])
+		next++
+	}
+
+	patches := make([]BezierPatch, num_patches)
+	for p := range patches {
+		for i, index := range indices[p] {
+			if index < 1 || index > num_vertices {
+				return nil, fmt.Errorf("%s: patch %d references vertex %d of %d", path, p+1, index, num_vertices)
+			}
+			patches[p][i] = vertices[index-1]
+		}
+	}
+	return patches, nil
+}
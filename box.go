@@ -0,0 +1,86 @@
+package main
+
+import "math"
+
+// Box is an axis-aligned box spanning min to max.
+type Box struct {
+	min      Vector
+	max      Vector
+	material *Material
+}
+
+func MakeBox(min Vector, max Vector, material *Material) Box {
+	var b Box
+	b.min = MakeVector(math.Min(min.x, max.x), math.Min(min.y, max.y), math.Min(min.z, max.z))
+	b.max = MakeVector(math.Max(min.x, max.x), math.Max(min.y, max.y), math.Max(min.z, max.z))
+	b.material = material
+	return b
+}
+
+// IntersectRayBox returns the entry and exit t of the ray using the slab
+// method, or +Inf for both when the ray misses.
+func IntersectRayBox(origin Vector, direction Vector, box Box) (float64, float64) {
+	t_near, t_far := math.Inf(-1), math.Inf(1)
+	for _, axis := range [3][4]float64{
+		{origin.x, direction.x, box.min.x, box.max.x},
+		{origin.y, direction.y, box.min.y, box.max.y},
+		{origin.z, direction.z, box.min.z, box.max.z},
+	} {
+		o, d, lo, hi := axis[0], axis[1], axis[2], axis[3]
+		if d == 0 {
+			if o < lo || o > hi {
+				return math.Inf(1), math.Inf(1)
+			}
+			continue
+		}
+		t1, t2 := (lo-o)/d, (hi-o)/d
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		t_near = math.Max(t_near, t1)
+		t_far = math.Min(t_far, t2)
+	}
+	if t_near > t_far {
+		return math.Inf(1), math.Inf(1)
+	}
+	return t_near, t_far
+}
+
+func (b *Box) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	t1, t2 := IntersectRayBox(origin, direction, *b)
+	return nearestIn(t_min, t_max, t1, t2)
+}
+
+func (b *Box) NormalAt(point Vector) Vector {
+	// The face is the axis along which the point is furthest out, relative
+	// to the box's half-size.
+	center := scale(add(b.min, b.max), 0.5)
+	half := scale(sub(b.max, b.min), 0.5)
+	p := sub(point, center)
+	px, py, pz := p.x/half.x, p.y/half.y, p.z/half.z
+	ax, ay, az := math.Abs(px), math.Abs(py), math.Abs(pz)
+	switch {
+	case ax >= ay && ax >= az:
+		return MakeVector(math.Copysign(1, px), 0, 0)
+	case ay >= az:
+		return MakeVector(0, math.Copysign(1, py), 0)
+	default:
+		return MakeVector(0, 0, math.Copysign(1, pz))
+	}
+}
+
+func (b *Box) Material() *Material {
+	return b.material
+}
+
+func (b *Box) Bounds() AABB {
+	return AABB{b.min, b.max}
+}
+
+func (b *Box) Spans(origin Vector, direction Vector) []Span {
+	t1, t2 := IntersectRayBox(origin, direction, *b)
+	if math.IsInf(t1, 1) {
+		return nil
+	}
+	return spansFromHits(b, []float64{t1, t2})
+}
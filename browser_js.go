@@ -0,0 +1,43 @@
+package main
+
+import (
+	"image"
+	"syscall/js"
+)
+
+// Built with GOOS=js GOARCH=wasm, the tracer runs in a web page, such as
+// web/index.html, taking its flags from the argv the page's wasm_exec.js
+// passes in. If the page has a <canvas id="raytracer">, previews and the
+// finished render are drawn there instead of written to files; without
+// one, as under Node.js, it writes files as usual.
+func init() {
+	canvas := js.Global().Get("document")
+	if canvas.IsUndefined() {
+		return
+	}
+	canvas = canvas.Call("getElementById", "raytracer")
+	if canvas.IsNull() {
+		return
+	}
+	browserDisplay = func(img *image.NRGBA) {
+		w, h := img.Rect.Dx(), img.Rect.Dy()
+		canvas.Set("width", w)
+		canvas.Set("height", h)
+		context := canvas.Call("getContext", "2d")
+		data := context.Call("createImageData", w, h)
+		js.CopyBytesToJS(data.Get("data"), img.Pix)
+		context.Call("putImageData", data, 0, 0)
+
+		// Wait for the next frame, handing the page back its event loop
+		// to show this one. The render goes on when it comes, as the
+		// other workers are blocked too with GOMAXPROCS at 1.
+		drawn := make(chan struct{})
+		callback := js.FuncOf(func(js.Value, []js.Value) interface{} {
+			close(drawn)
+			return nil
+		})
+		defer callback.Release()
+		js.Global().Call("requestAnimationFrame", callback)
+		<-drawn
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import "math"
+
+// bumpEpsilon is the step for the finite differences that recover surface
+// slopes from textures.
+const bumpEpsilon = 1e-4
+
+// ShadingNormal perturbs the geometric normal at a point on the object by
+// the material's normal map and bump texture, if it has them. Normal maps
+// store a tangent-space normal as color, with x along increasing u, y along
+// increasing v and z along the geometric normal; bump textures store a
+// height as brightness, scaled by bump_height.
+func (m *Material) ShadingNormal(object Primitive, point Vector, normal Vector) Vector {
+	if (m.normal_map == nil && m.bump == nil) || norm(normal) == 0 {
+		return normal
+	}
+	tangent, bitangent := uvFrame(object, point, normal)
+	if m.normal_map != nil {
+		u, v := uvAt(object, point)
+		c := m.normal_map.ColorAt(u, v, point)
+		normal = normalize(add(add(scale(tangent, 2*c.r-1), scale(bitangent, 2*c.g-1)), scale(normal, 2*c.b-1)))
+		tangent = normalize(sub(tangent, scale(normal, dot(tangent, normal))))
+		bitangent = cross(normal, tangent)
+	}
+	if m.bump != nil {
+		dt := m.heightAt(object, add(point, scale(tangent, bumpEpsilon))) - m.heightAt(object, sub(point, scale(tangent, bumpEpsilon)))
+		db := m.heightAt(object, add(point, scale(bitangent, bumpEpsilon))) - m.heightAt(object, sub(point, scale(bitangent, bumpEpsilon)))
+		k := m.bump_height / (2 * bumpEpsilon)
+		normal = normalize(sub(normal, add(scale(tangent, k*dt), scale(bitangent, k*db))))
+	}
+	return normal
+}
+
+func (m *Material) heightAt(object Primitive, point Vector) float64 {
+	u, v := uvAt(object, point)
+	c := m.bump.ColorAt(u, v, point)
+	return (c.r + c.g + c.b) / 3
+}
+
+// uvFrame returns unit vectors perpendicular to the normal that point along
+// increasing u and v, found by differencing the texture coordinates around
+// the point. Surfaces without texture coordinates get an arbitrary frame.
+func uvFrame(object Primitive, point Vector, normal Vector) (Vector, Vector) {
+	a, b := perpendicularBasis(normal)
+	if _, ok := object.(UVMapped); !ok {
+		return a, b
+	}
+	u0, v0 := uvAt(object, sub(point, scale(a, bumpEpsilon)))
+	u1, v1 := uvAt(object, add(point, scale(a, bumpEpsilon)))
+	u2, v2 := uvAt(object, sub(point, scale(b, bumpEpsilon)))
+	u3, v3 := uvAt(object, add(point, scale(b, bumpEpsilon)))
+	// Jacobian of (u, v) with respect to steps along a and b.
+	ua, ub, va, vb := unwrap(u1-u0), unwrap(u3-u2), unwrap(v1-v0), unwrap(v3-v2)
+	det := ua*vb - ub*va
+	if math.Abs(det) < 1e-12 {
+		return a, b
+	}
+	tangent := normalize(add(scale(a, vb), scale(b, -va)))
+	bitangent := normalize(add(scale(a, -ub), scale(b, ua)))
+	if det < 0 {
+		tangent, bitangent = neg(tangent), neg(bitangent)
+	}
+	return tangent, bitangent
+}
+
+// unwrap corrects a difference of texture coordinates taken across the
+// seam of a periodic mapping, such as a sphere's longitude.
+func unwrap(d float64) float64 {
+	if d > 0.5 {
+		return d - 1
+	}
+	if d < -0.5 {
+		return d + 1
+	}
+	return d
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"math"
+	"runtime"
+	"sync"
+)
+
+// AABB is an axis-aligned bounding box.
+type AABB struct {
+	min Vector
+	max Vector
+}
+
+// Bounded objects can report a box that encloses them. Unbounded objects
+// such as planes return an infinite box.
+type Bounded interface {
+	Bounds() AABB
+}
+
+func EmptyAABB() AABB {
+	inf := math.Inf(1)
+	return AABB{MakeVector(inf, inf, inf), MakeVector(-inf, -inf, -inf)}
+}
+
+func InfiniteAABB() AABB {
+	inf := math.Inf(1)
+	return AABB{MakeVector(-inf, -inf, -inf), MakeVector(inf, inf, inf)}
+}
+
+func (b AABB) Union(o AABB) AABB {
+	return AABB{
+		MakeVector(math.Min(b.min.x, o.min.x), math.Min(b.min.y, o.min.y), math.Min(b.min.z, o.min.z)),
+		MakeVector(math.Max(b.max.x, o.max.x), math.Max(b.max.y, o.max.y), math.Max(b.max.z, o.max.z)),
+	}
+}
+
+func (b AABB) Extend(p Vector) AABB {
+	return b.Union(AABB{p, p})
+}
+
+func (b AABB) Intersection(o AABB) AABB {
+	return AABB{
+		MakeVector(math.Max(b.min.x, o.min.x), math.Max(b.min.y, o.min.y), math.Max(b.min.z, o.min.z)),
+		MakeVector(math.Min(b.max.x, o.max.x), math.Min(b.max.y, o.max.y), math.Min(b.max.z, o.max.z)),
+	}
+}
+
+// Finite reports whether the box is non-empty and bounded.
+func (b AABB) Finite() bool {
+	for _, v := range [6]float64{b.min.x, b.min.y, b.min.z, b.max.x, b.max.y, b.max.z} {
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return false
+		}
+	}
+	return b.min.x <= b.max.x && b.min.y <= b.max.y && b.min.z <= b.max.z
+}
+
+func (b AABB) Centroid() Vector {
+	return scale(add(b.min, b.max), 0.5)
+}
+
+// Hit tests the ray against the box using the slab method, given the
+// per-axis reciprocal of the ray direction.
+func (b AABB) Hit(origin Vector, inv_dir Vector, t_min float64, t_max float64) bool {
+	_, _, ok := b.Clip(origin, inv_dir, t_min, t_max)
+	return ok
+}
+
+// Clip narrows [t_min, t_max] to the part of the ray inside the box.
+func (b AABB) Clip(origin Vector, inv_dir Vector, t_min float64, t_max float64) (float64, float64, bool) {
+	for _, axis := range [3][4]float64{
+		{origin.x, inv_dir.x, b.min.x, b.max.x},
+		{origin.y, inv_dir.y, b.min.y, b.max.y},
+		{origin.z, inv_dir.z, b.min.z, b.max.z},
+	} {
+		t1 := (axis[2] - axis[0]) * axis[1]
+		t2 := (axis[3] - axis[0]) * axis[1]
+		if t1 > t2 {
+			t1, t2 = t2, t1
+		}
+		// NaN comparisons (origin on a slab with a zero direction) keep
+		// t_min and t_max unchanged, which errs on the side of a hit.
+		if t1 > t_min {
+			t_min = t1
+		}
+		if t2 < t_max {
+			t_max = t2
+		}
+		if t_min > t_max {
+			return t_min, t_max, false
+		}
+	}
+	return t_min, t_max, true
+}
+
+// SurfaceArea is used by the split heuristics.
+func (b AABB) SurfaceArea() float64 {
+	e := sub(b.max, b.min)
+	return 2 * (e.x*e.y + e.y*e.z + e.z*e.x)
+}
+
+// discExtent returns the half-size of the box around a disc of the given
+// radius facing along the unit vector normal.
+func discExtent(normal Vector, radius float64) Vector {
+	return MakeVector(
+		radius*math.Sqrt(math.Max(0, 1-normal.x*normal.x)),
+		radius*math.Sqrt(math.Max(0, 1-normal.y*normal.y)),
+		radius*math.Sqrt(math.Max(0, 1-normal.z*normal.z)),
+	)
+}
+
+// component returns the x, y or z coordinate of v.
+func component(v Vector, axis int) float64 {
+	switch axis {
+	case 0:
+		return v.x
+	case 1:
+		return v.y
+	}
+	return v.z
+}
+
+// boundsOf returns an object's box, or an infinite one if it has none.
+func boundsOf(object Object) AABB {
+	if b, ok := object.(Bounded); ok {
+		return b.Bounds()
+	}
+	return InfiniteAABB()
+}
+
+// bvhLeafSize is the most objects a BVH leaf holds when splitting would
+// not pay off, and bvhBins is the number of buckets the SAH build sorts
+// centroids into along each axis. Spatial splits are only tried when the
+// children of the best object split overlap by more than bvhSpatialAlpha
+// of the root's surface area, and may at most double the references.
+const (
+	bvhLeafSize     = 4
+	bvhBins         = 16
+	bvhSpatialAlpha = 1e-5
+)
+
+// Costs for the surface area heuristic, relative to one box test.
+const (
+	bvhTraversalCost = 1
+	bvhIntersectCost = 2
+)
+
+type bvhNode struct {
+	bounds AABB
+	// Interior nodes store their right child index in offset (the left
+	// child follows the node directly) and the split axis; leaves store the
+	// range [offset, offset+count) of objects.
+	offset int
+	count  int
+	axis   int
+}
+
+// BVH is a bounding volume hierarchy over a set of objects. Objects with no
+// finite bounds, such as planes, are kept aside and tested on every ray.
+type BVH struct {
+	nodes     []bvhNode
+	objects   []Object
+	unbounded []Object
+}
+
+// bvhRef is one reference to an object during construction. A spatial
+// split references an object from both children, each with its bounds
+// clipped to that side of the split.
+type bvhRef struct {
+	object Object
+	bounds AABB
+}
+
+func MakeBVH(objects []Object) BVH {
+	var b BVH
+	var refs []bvhRef
+	for _, object := range objects {
+		box := boundsOf(object)
+		if box.Finite() {
+			refs = append(refs, bvhRef{object, box})
+		} else {
+			b.unbounded = append(b.unbounded, object)
+		}
+	}
+	if len(refs) > 0 {
+		min_overlap := bvhSpatialAlpha * summarize(refs).box.SurfaceArea()
+		b.build(refs, min_overlap, len(refs), 0)
+	}
+	return b
+}
+
+type bvhBin struct {
+	bounds AABB
+	count  int
+}
+
+// bvhParallelSize is the smallest subtree whose construction is split
+// across goroutines, and bvhMaxDepth keeps trees within the traversal
+// stack.
+const (
+	bvhParallelSize = 4096
+	bvhMaxDepth     = 60
+)
+
+// bvhSummary holds what one pass over a range of references gathers for a
+// split: the bounds of the references and of their centroids.
+type bvhSummary struct {
+	box       AABB
+	centroids AABB
+}
+
+func summarize(refs []bvhRef) bvhSummary {
+	s := bvhSummary{EmptyAABB(), EmptyAABB()}
+	for _, ref := range refs {
+		s.box = s.box.Union(ref.bounds)
+		s.centroids = s.centroids.Extend(ref.bounds.Centroid())
+	}
+	return s
+}
+
+// binAll sorts the references into bins along all three axes at once.
+func binAll(refs []bvhRef, centroids AABB) [3][bvhBins]bvhBin {
+	var bins [3][bvhBins]bvhBin
+	for axis := range bins {
+		for k := range bins[axis] {
+			bins[axis][k].bounds = EmptyAABB()
+		}
+	}
+	for _, ref := range refs {
+		c := ref.bounds.Centroid()
+		for axis := range bins {
+			lo, hi := component(centroids.min, axis), component(centroids.max, axis)
+			if hi <= lo {
+				continue
+			}
+			k := bvhBinOf(component(c, axis), lo, hi)
+			bins[axis][k].bounds = bins[axis][k].bounds.Union(ref.bounds)
+			bins[axis][k].count++
+		}
+	}
+	return bins
+}
+
+// inParallel splits [0, n) into one chunk per CPU and runs work on each.
+func inParallel(n int, work func(chunk int, start int, end int)) int {
+	chunks := runtime.GOMAXPROCS(0)
+	var wg sync.WaitGroup
+	for c := 0; c < chunks; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			work(c, n*c/chunks, n*(c+1)/chunks)
+		}(c)
+	}
+	wg.Wait()
+	return chunks
+}
+
+// gather summarizes and bins the references, spreading the passes over all
+// CPUs for large nodes.
+func gather(refs []bvhRef) (bvhSummary, [3][bvhBins]bvhBin) {
+	n := len(refs)
+	if n < bvhParallelSize {
+		s := summarize(refs)
+		return s, binAll(refs, s.centroids)
+	}
+
+	summaries := make([]bvhSummary, runtime.GOMAXPROCS(0))
+	chunks := inParallel(n, func(c int, i int, j int) {
+		summaries[c] = summarize(refs[i:j])
+	})
+	s := bvhSummary{EmptyAABB(), EmptyAABB()}
+	for _, part := range summaries[:chunks] {
+		s.box = s.box.Union(part.box)
+		s.centroids = s.centroids.Union(part.centroids)
+	}
+
+	partial := make([][3][bvhBins]bvhBin, chunks)
+	inParallel(n, func(c int, i int, j int) {
+		partial[c] = binAll(refs[i:j], s.centroids)
+	})
+	bins := partial[0]
+	for _, part := range partial[1:] {
+		for axis := range bins {
+			for k := range bins[axis] {
+				bins[axis][k].bounds = bins[axis][k].bounds.Union(part[axis][k].bounds)
+				bins[axis][k].count += part[axis][k].count
+			}
+		}
+	}
+	return s, bins
+}
+
+// build appends the subtree for refs and returns its index. budget is how
+// many extra references spatial splits may still add to the subtree. Large
+// subtrees build their right half on another goroutine into a separate BVH
+// that is grafted on afterwards.
+func (b *BVH) build(refs []bvhRef, min_overlap float64, budget int, depth int) int {
+	index := len(b.nodes)
+	b.nodes = append(b.nodes, bvhNode{})
+	n := len(refs)
+	summary, bins := gather(refs)
+	box, centroids := summary.box, summary.centroids
+	if n == 1 || depth == bvhMaxDepth {
+		b.leaf(index, box, refs)
+		return index
+	}
+
+	// Sweep the bin boundaries along each axis for the object split with
+	// the lowest surface area heuristic cost.
+	best_cost, best_axis, best_bin := math.Inf(1), -1, 0
+	var best_left, best_right AABB
+	for axis := 0; axis < 3; axis++ {
+		if component(centroids.max, axis) <= component(centroids.min, axis) {
+			continue
+		}
+		// right_box[k] and right_count[k] cover bins k and above.
+		var right_box [bvhBins]AABB
+		var right_count [bvhBins]int
+		right := EmptyAABB()
+		count := 0
+		for k := bvhBins - 1; k > 0; k-- {
+			right = right.Union(bins[axis][k].bounds)
+			count += bins[axis][k].count
+			right_box[k], right_count[k] = right, count
+		}
+		left := EmptyAABB()
+		count = 0
+		for k := 1; k < bvhBins; k++ {
+			left = left.Union(bins[axis][k-1].bounds)
+			count += bins[axis][k-1].count
+			if count == 0 || right_count[k] == 0 {
+				continue
+			}
+			cost := left.SurfaceArea()*float64(count) + right_box[k].SurfaceArea()*float64(right_count[k])
+			if cost < best_cost {
+				best_cost, best_axis, best_bin = cost, axis, k
+				best_left, best_right = left, right_box[k]
+			}
+		}
+	}
+
+	// Object splits whose children overlap heavily, typically from long
+	// thin triangles, are worth comparing against a spatial split.
+	var left_refs, right_refs []bvhRef
+	spatial := false
+	overlap := best_left.Intersection(best_right)
+	if budget > 0 && (best_axis == -1 || (overlap.Finite() && overlap.SurfaceArea() > min_overlap)) {
+		if cost, axis, split := spatialSplit(refs, box); cost < best_cost {
+			left_refs, right_refs = partitionSpatial(refs, axis, split)
+			extra := len(left_refs) + len(right_refs) - n
+			if len(left_refs) > 0 && len(right_refs) > 0 && extra <= budget {
+				best_cost, best_axis, spatial = cost, axis, true
+				budget -= extra
+			}
+		}
+	}
+
+	leaf_cost := bvhIntersectCost * float64(n)
+	if best_axis != -1 {
+		best_cost = bvhTraversalCost + bvhIntersectCost*best_cost/box.SurfaceArea()
+	}
+	if best_axis == -1 || (n <= bvhLeafSize && best_cost >= leaf_cost) {
+		b.leaf(index, box, refs)
+		return index
+	}
+
+	if !spatial {
+		// Partition the references around the chosen bin boundary.
+		lo, hi := component(centroids.min, best_axis), component(centroids.max, best_axis)
+		mid := 0
+		for i := range refs {
+			if bvhBinOf(component(refs[i].bounds.Centroid(), best_axis), lo, hi) < best_bin {
+				refs[i], refs[mid] = refs[mid], refs[i]
+				mid++
+			}
+		}
+		left_refs, right_refs = refs[:mid], refs[mid:]
+	}
+
+	// Share the remaining budget in proportion to the children's sizes.
+	left_budget := budget * len(left_refs) / (len(left_refs) + len(right_refs))
+	right_budget := budget - left_budget
+
+	var right int
+	if n >= bvhParallelSize {
+		var half BVH
+		done := make(chan struct{})
+		go func() {
+			half.build(right_refs, min_overlap, right_budget, depth+1)
+			close(done)
+		}()
+		b.build(left_refs, min_overlap, left_budget, depth+1)
+		<-done
+		right = b.graft(&half)
+	} else {
+		b.build(left_refs, min_overlap, left_budget, depth+1)
+		right = b.build(right_refs, min_overlap, right_budget, depth+1)
+	}
+	b.nodes[index] = bvhNode{bounds: box, offset: right, axis: best_axis}
+	return index
+}
+
+func (b *BVH) leaf(index int, box AABB, refs []bvhRef) {
+	b.nodes[index] = bvhNode{bounds: box, offset: len(b.objects), count: len(refs)}
+	for _, ref := range refs {
+		b.objects = append(b.objects, ref.object)
+	}
+}
+
+// graft appends a separately built subtree and returns the index of its
+// root, shifting its child indices and leaf object ranges.
+func (b *BVH) graft(half *BVH) int {
+	base := len(b.nodes)
+	objects := len(b.objects)
+	for _, node := range half.nodes {
+		if node.count == 0 {
+			node.offset += base
+		} else {
+			node.offset += objects
+		}
+		b.nodes = append(b.nodes, node)
+	}
+	b.objects = append(b.objects, half.objects...)
+	return base
+}
+
+func bvhBinOf(c float64, lo float64, hi float64) int {
+	k := int(bvhBins * (c - lo) / (hi - lo))
+	if k >= bvhBins {
+		k = bvhBins - 1
+	}
+	return k
+}
+
+// traverse calls visit for every object whose leaf box the ray enters,
+// nearest subtree first. visit returns the new t_max.
+func (b *BVH) traverse(origin Vector, direction Vector, t_min float64, t_max float64, visit func(Object, float64) float64) float64 {
+	for _, object := range b.unbounded {
+		t_max = visit(object, t_max)
+	}
+	if len(b.nodes) == 0 {
+		return t_max
+	}
+	inv_dir := MakeVector(1/direction.x, 1/direction.y, 1/direction.z)
+	negative := [3]bool{direction.x < 0, direction.y < 0, direction.z < 0}
+	var stack [64]int
+	top := 0
+	stack[top] = 0
+	top++
+	for top > 0 {
+		top--
+		index := stack[top]
+		node := &b.nodes[index]
+		if !node.bounds.Hit(origin, inv_dir, t_min, t_max) {
+			continue
+		}
+		if node.count > 0 {
+			for _, object := range b.objects[node.offset : node.offset+node.count] {
+				t_max = visit(object, t_max)
+			}
+			continue
+		}
+		// Push the far child first so the near one is visited next.
+		near, far := index+1, node.offset
+		if negative[node.axis] {
+			near, far = far, near
+		}
+		stack[top] = far
+		stack[top+1] = near
+		top += 2
+	}
+	return t_max
+}
+
+func (b *BVH) Hit(origin Vector, direction Vector, t_min float64, t_max float64) (Primitive, float64) {
+	return hitWith(b.traverse, origin, direction, t_min, t_max)
+}
+
+func (b *BVH) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	return intersectWith(b.traverse, origin, direction, t_min, t_max)
+}
+
+func (b *BVH) CacheOrigin(origin Vector) {
+	for _, object := range b.objects {
+		cacheOrigin(object, origin)
+	}
+	for _, object := range b.unbounded {
+		cacheOrigin(object, origin)
+	}
+}
+
+func (b *BVH) Bounds() AABB {
+	box := EmptyAABB()
+	if len(b.nodes) > 0 {
+		box = b.nodes[0].bounds
+	}
+	if len(b.unbounded) > 0 {
+		return InfiniteAABB()
+	}
+	return box
+}
+
+// traversal is the visiting loop of an acceleration structure: it calls
+// visit for candidate objects and returns the final t_max.
+type traversal func(origin Vector, direction Vector, t_min float64, t_max float64, visit func(Object, float64) float64) float64
+
+// hitWith finds the closest primitive among the objects a traversal visits.
+func hitWith(traverse traversal, origin Vector, direction Vector, t_min float64, t_max float64) (Primitive, float64) {
+	var best_object Primitive
+	best_t := traverse(origin, direction, t_min, t_max, func(object Object, t_max float64) float64 {
+		if compound, ok := object.(Compound); ok {
+			if p, t := compound.Hit(origin, direction, t_min, t_max); p != nil {
+				best_object = p
+				return t
+			}
+		} else if t, ok := object.Intersect(origin, direction, t_min, t_max); ok {
+			best_object = object.(Primitive)
+			return t
+		}
+		return t_max
+	})
+	return best_object, best_t
+}
+
+func intersectWith(traverse traversal, origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	found := false
+	best_t := traverse(origin, direction, t_min, t_max, func(object Object, t_max float64) float64 {
+		if t, ok := object.Intersect(origin, direction, t_min, t_max); ok {
+			found = true
+			return t
+		}
+		return t_max
+	})
+	return best_t, found
+}
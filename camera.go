@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Camera places the eye in the scene. Rays through the viewport are turned
+// from camera space, looking down +Z with +Y up, into the camera's
+// orientation basis.
+type Camera struct {
+	position Vector
+	right    Vector
+	up       Vector
+	forward  Vector
+	// projection is "perspective" (the default), "orthographic", which
+	// casts parallel rays from a viewport ortho_size wide, "fisheye", an
+	// equidistant projection of fov radians across the canvas's inscribed
+	// circle, or "panorama", which maps the canvas to longitude and latitude
+	// over the whole sphere.
+	projection string
+	ortho_size float64
+	fov        float64
+	// A thin lens of radius aperture, focused focal_distance ahead, blurs
+	// what is out of focus. The default aperture of 0 is a pinhole.
+	aperture       float64
+	focal_distance float64
+	// The shutter stays open for shutter time units, during which the
+	// camera moves at velocity. Each sample is taken at a random time in
+	// the interval, so fast moves streak once samples are averaged.
+	shutter  float64
+	velocity Vector
+	// Lens shift slides the viewport across the image plane, as a fraction
+	// of its width and height, without turning the camera, so verticals
+	// stay parallel in architectural shots.
+	shift_x float64
+	shift_y float64
+}
+
+// MakeCamera points a camera at target. up need only be roughly upward; it
+// is made perpendicular to the view direction.
+func MakeCamera(position Vector, target Vector, up Vector) Camera {
+	var c Camera
+	c.position = position
+	c.forward = normalize(sub(target, position))
+	c.right = normalize(cross(up, c.forward))
+	c.up = cross(c.forward, c.right)
+	c.projection = "perspective"
+	// Orthographic views frame what the perspective view sees at the target.
+	c.ortho_size = norm(sub(target, position)) * Vw / d
+	c.focal_distance = norm(sub(target, position))
+	c.fov = math.Pi
+	return c
+}
+
+// StereoPair returns left and right eye cameras, parallel to this one and
+// interaxial apart.
+func (c *Camera) StereoPair(interaxial float64) (Camera, Camera) {
+	left, right := *c, *c
+	left.position = add(c.position, scale(c.right, -interaxial/2))
+	right.position = add(c.position, scale(c.right, interaxial/2))
+	return left, right
+}
+
+// Ray returns the world-space origin and direction of the ray through
+// canvas point (x, y), in pixels with pixel centers at integers, or false
+// for points outside the projection, such as the corners of a fisheye
+// image. With an aperture each call samples a different point on the lens,
+// so averaging several samples per pixel gives depth of field.
+func (c *Camera) Ray(rng *rand.Rand, x float64, y float64) (Vector, Vector, bool) {
+	return c.SampledRay(x, y, rng.Float64(), rng.Float64(), rng.Float64())
+}
+
+// SampledRay is Ray for the lens point (lens_u, lens_v) in [0, 1)², and the
+// time the fraction time_u of the way through the shutter interval, as a
+// Sampler chooses them.
+func (c *Camera) SampledRay(x float64, y float64, lens_u float64, lens_v float64, time_u float64) (Vector, Vector, bool) {
+	origin, direction, ok := c.pinholeRay(x, y)
+	if ok && c.aperture > 0 {
+		origin, direction = c.lensRay(origin, direction, lens_u, lens_v)
+	}
+	if c.shutter > 0 {
+		origin = add(origin, scale(c.velocity, time_u*c.shutter))
+	}
+	return origin, direction, ok
+}
+
+func (c *Camera) pinholeRay(x float64, y float64) (Vector, Vector, bool) {
+	v := CanvasToViewPort(x, y)
+	if c.projection != "fisheye" && c.projection != "panorama" {
+		v.x += c.shift_x * Vw
+		v.y += c.shift_y * Vh
+	}
+	switch c.projection {
+	case "orthographic":
+		offset := add(scale(c.right, v.x*c.ortho_size/Vw), scale(c.up, v.y*c.ortho_size/Vw))
+		return add(c.position, offset), c.forward, true
+	case "fisheye":
+		// The angle from the view axis grows linearly with the distance
+		// from the center of the canvas.
+		u, w := 2*v.x/Vw, 2*v.y/Vh
+		r := math.Sqrt(u*u + w*w)
+		if r > 1 {
+			return c.position, c.forward, false
+		}
+		theta, phi := r*c.fov/2, math.Atan2(w, u)
+		side := add(scale(c.right, math.Cos(phi)), scale(c.up, math.Sin(phi)))
+		return c.position, add(scale(c.forward, math.Cos(theta)), scale(side, math.Sin(theta))), true
+	case "panorama":
+		// Equirectangular: x spans longitude -π to π with the view direction
+		// in the middle, y spans latitude -π/2 to π/2.
+		longitude, latitude := math.Pi*2*v.x/Vw, math.Pi*v.y/Vh
+		level := add(scale(c.forward, math.Cos(longitude)), scale(c.right, math.Sin(longitude)))
+		return c.position, add(scale(level, math.Cos(latitude)), scale(c.up, math.Sin(latitude))), true
+	default:
+		return c.position, add(add(scale(c.right, v.x), scale(c.up, v.y)), scale(c.forward, v.z)), true
+	}
+}
+
+// lensRay moves a pinhole ray's origin to the point (u, v) in [0, 1)² maps
+// to on the lens, keeping it aimed at the same point on the focal plane.
+// The direction keeps its component along the view axis, so distances
+// along the ray are unchanged.
+func (c *Camera) lensRay(origin Vector, direction Vector, u float64, v float64) (Vector, Vector) {
+	along := dot(direction, c.forward)
+	if along <= 0 {
+		return origin, direction
+	}
+	focus := add(origin, scale(direction, c.focal_distance/along))
+	r, theta := c.aperture*math.Sqrt(u), 2*math.Pi*v
+	lens := add(origin, add(scale(c.right, r*math.Cos(theta)), scale(c.up, r*math.Sin(theta))))
+	return lens, scale(sub(focus, lens), along/c.focal_distance)
+}
+
+// parseVector reads a vector written as "x,y,z".
+func parseVector(s string) (Vector, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != 3 {
+		return Vector{}, fmt.Errorf("%q: want x,y,z", s)
+	}
+	var v [3]float64
+	for i, field := range fields {
+		f, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return Vector{}, fmt.Errorf("%q: %v", s, err)
+		}
+		v[i] = f
+	}
+	return MakeVector(v[0], v[1], v[2]), nil
+}
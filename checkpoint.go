@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/gob"
+	"fmt"
+	"os"
+)
+
+// checkpointFile is what a checkpoint holds: the key of the render it
+// belongs to, which of its tiles are done and the canvas's radiance and
+// alpha, four values to a pixel. Tiles are rendered whole with their
+// pixels' own seeds, so a resumed render comes out as if never stopped.
+type checkpointFile struct {
+	Key    string
+	Done   []bool
+	Pixels []float64
+}
+
+// checkpointKey describes the settings a render's pixels depend on, so a
+// checkpoint is only resumed by the render that wrote it.
+func checkpointKey(scene *Scene, camera *Camera) string {
+	return fmt.Sprintf("%s %d %d %d %d %d %d %v %v %v %d %d %d %dx%d %v %+v %+v",
+		scene.integrator, scene.samples, scene.max_samples, scene.path_samples, scene.gi_samples,
+		scene.mean_groups, scene.seed, scene.max_radiance, scene.spectral, scene.alpha,
+		scene.tile_size, len(scene.objects), len(scene.lights), Cw, Ch, d, scene.background, *camera)
+}
+
+// saveCheckpoint writes the canvas and the tiles done to path, holding the
+// canvas's lock so no tile is caught half painted. It writes a temporary
+// file first, so a render killed meanwhile leaves the last checkpoint.
+func saveCheckpoint(path string, key string, canvas *Canvas, done []bool) error {
+	file := checkpointFile{Key: key, Done: done, Pixels: make([]float64, 4*len(canvas.radiance))}
+	canvas.lock.Lock()
+	for i, c := range canvas.radiance {
+		copy(file.Pixels[4*i:], []float64{c.r, c.g, c.b, canvas.alpha[i]})
+	}
+	canvas.lock.Unlock()
+
+	partial := path + ".part"
+	f, err := os.Create(partial)
+	if err != nil {
+		return err
+	}
+	if err := gob.NewEncoder(f).Encode(&file); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %v", partial, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(partial, path)
+}
+
+// loadCheckpoint reads a checkpoint into the canvas and done, if it was
+// written by the render key describes.
+func loadCheckpoint(path string, key string, canvas *Canvas, done []bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var file checkpointFile
+	if err := gob.NewDecoder(f).Decode(&file); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if file.Key != key || len(file.Done) != len(done) || len(file.Pixels) != 4*len(canvas.radiance) {
+		return fmt.Errorf("%s is from a render with other settings", path)
+	}
+	copy(done, file.Done)
+	for i := range canvas.radiance {
+		p := file.Pixels[4*i : 4*i+4]
+		canvas.radiance[i], canvas.alpha[i] = Color{p[0], p[1], p[2]}, p[3]
+	}
+	return nil
+}
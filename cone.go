@@ -0,0 +1,106 @@
+package main
+
+import "math"
+
+// Cone is a solid cone with its tip at apex, opening along axis with the
+// given half-angle (radians), and closed by a flat cap height units away.
+type Cone struct {
+	apex     Vector
+	axis     Vector
+	angle    float64
+	height   float64
+	material *Material
+}
+
+func MakeCone(apex Vector, axis Vector, angle float64, height float64, material *Material) Cone {
+	var c Cone
+	c.apex = apex
+	c.axis = normalize(axis)
+	c.angle = angle
+	c.height = height
+	c.material = material
+	return c
+}
+
+// IntersectRayCone returns the nearest hit beyond t_min against the cone's
+// side and base cap.
+func IntersectRayCone(origin Vector, direction Vector, cone Cone, t_min float64) float64 {
+	hits, n := coneHits(origin, direction, cone)
+	best_t, _ := nearestIn(t_min, math.Inf(1), hits[:n]...)
+	return best_t
+}
+
+// coneHits returns every t at which the ray crosses the cone's side or cap.
+func coneHits(origin Vector, direction Vector, cone Cone) ([3]float64, int) {
+	var hits [3]float64
+	n := 0
+	a := cone.axis
+	k := math.Cos(cone.angle) * math.Cos(cone.angle)
+	CO := sub(origin, cone.apex)
+	d_a := dot(direction, a)
+	co_a := dot(CO, a)
+
+	// Side: points p with ((p-apex)·a)^2 = cos^2(angle) |p-apex|^2.
+	qa := d_a*d_a - k*dot(direction, direction)
+	qb := 2 * (d_a*co_a - k*dot(direction, CO))
+	qc := co_a*co_a - k*dot(CO, CO)
+	var roots []float64
+	if math.Abs(qa) < 1e-12 {
+		if math.Abs(qb) > 1e-12 {
+			roots = append(roots, -qc/qb) // ray parallel to the surface
+		}
+	} else if discrim := qb*qb - 4*qa*qc; discrim >= 0 {
+		sq := math.Sqrt(discrim)
+		roots = append(roots, (-qb-sq)/(2*qa), (-qb+sq)/(2*qa))
+	}
+	for _, t := range roots {
+		h := co_a + t*d_a // rejects the mirrored nappe behind the apex too
+		if h >= 0 && h <= cone.height {
+			hits[n] = t
+			n++
+		}
+	}
+
+	// Base cap.
+	if math.Abs(d_a) > 1e-12 {
+		t := (cone.height - co_a) / d_a
+		r := sub(add(CO, scale(direction, t)), scale(a, cone.height))
+		cap_radius := cone.height * math.Tan(cone.angle)
+		if dot(r, r) <= cap_radius*cap_radius {
+			hits[n] = t
+			n++
+		}
+	}
+	return hits, n
+}
+
+func (c *Cone) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	return nearestIn(t_min, t_max, IntersectRayCone(origin, direction, *c, t_min))
+}
+
+func (c *Cone) NormalAt(point Vector) Vector {
+	cp := sub(point, c.apex)
+	h := dot(cp, c.axis)
+	radial := norm(sub(cp, scale(c.axis, h)))
+	if math.Abs(h-c.height) < math.Abs(radial-h*math.Tan(c.angle)) {
+		return c.axis // base cap
+	}
+	// Gradient of (cp·axis)^2 - cos^2(angle) |cp|^2, flipped outward.
+	k := math.Cos(c.angle) * math.Cos(c.angle)
+	return normalize(sub(scale(cp, k), scale(c.axis, h)))
+}
+
+func (c *Cone) Material() *Material {
+	return c.material
+}
+
+func (c *Cone) Bounds() AABB {
+	e := discExtent(c.axis, c.height*math.Tan(c.angle))
+	base := add(c.apex, scale(c.axis, c.height))
+	return AABB{sub(base, e), add(base, e)}.Extend(c.apex)
+}
+
+func (c *Cone) Spans(origin Vector, direction Vector) []Span {
+	hits, n := coneHits(origin, direction, *c)
+	return spansFromHits(c, hits[:n])
+}
@@ -0,0 +1,193 @@
+package main
+
+import "sort"
+
+// Boundary is the point where a ray crosses the surface of a solid.
+// flip marks surfaces whose normal must be reversed, such as the inner
+// walls left behind by a difference.
+type Boundary struct {
+	t       float64
+	surface Primitive
+	flip    bool
+}
+
+// Span is an interval along a ray that lies inside a solid.
+type Span struct {
+	in  Boundary
+	out Boundary
+}
+
+// Solid is a closed object that can report every span of a ray inside it,
+// which is what CSG needs to combine shapes. Spans are sorted and disjoint.
+type Solid interface {
+	Object
+	Spans(origin Vector, direction Vector) []Span
+}
+
+type CSGOp int
+
+const (
+	Union CSGOp = iota
+	Intersection
+	Difference
+)
+
+// CSG combines two solids with a boolean operation. The result is itself a
+// solid, so trees of operations can be built.
+type CSG struct {
+	op    CSGOp
+	left  Solid
+	right Solid
+}
+
+func MakeCSG(op CSGOp, left Solid, right Solid) CSG {
+	var c CSG
+	c.op = op
+	c.left = left
+	c.right = right
+	return c
+}
+
+func (c *CSG) Spans(origin Vector, direction Vector) []Span {
+	a := c.left.Spans(origin, direction)
+	b := c.right.Spans(origin, direction)
+	switch c.op {
+	case Union:
+		return unionSpans(a, b)
+	case Intersection:
+		return intersectSpans(a, b)
+	default:
+		return subtractSpans(a, b)
+	}
+}
+
+func (c *CSG) CacheOrigin(origin Vector) {
+	cacheOrigin(c.left, origin)
+	cacheOrigin(c.right, origin)
+}
+
+func (c *CSG) Bounds() AABB {
+	left, right := boundsOf(c.left), boundsOf(c.right)
+	switch c.op {
+	case Union:
+		return left.Union(right)
+	case Intersection:
+		return left.Intersection(right)
+	default:
+		return left
+	}
+}
+
+func (c *CSG) Hit(origin Vector, direction Vector, t_min float64, t_max float64) (Primitive, float64) {
+	for _, span := range c.Spans(origin, direction) {
+		for _, b := range [2]Boundary{span.in, span.out} {
+			if t_min <= b.t && b.t <= t_max {
+				if b.flip {
+					return &flipped{b.surface}, b.t
+				}
+				return b.surface, b.t
+			}
+		}
+	}
+	return nil, 0
+}
+
+func (c *CSG) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	p, t := c.Hit(origin, direction, t_min, t_max)
+	return t, p != nil
+}
+
+// flipped shades a surface from its inside.
+type flipped struct {
+	Primitive
+}
+
+func (f *flipped) NormalAt(point Vector) Vector {
+	return neg(f.Primitive.NormalAt(point))
+}
+
+func (f *flipped) UVAt(point Vector) (float64, float64) {
+	return uvAt(f.Primitive, point)
+}
+
+func unionSpans(a []Span, b []Span) []Span {
+	all := append(append([]Span{}, a...), b...)
+	sort.Slice(all, func(i, j int) bool { return all[i].in.t < all[j].in.t })
+	var out []Span
+	for _, s := range all {
+		if n := len(out); n > 0 && s.in.t <= out[n-1].out.t {
+			if s.out.t > out[n-1].out.t {
+				out[n-1].out = s.out
+			}
+			continue
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func intersectSpans(a []Span, b []Span) []Span {
+	var out []Span
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		lo, hi := a[i].in, a[i].out
+		if b[j].in.t > lo.t {
+			lo = b[j].in
+		}
+		if b[j].out.t < hi.t {
+			hi = b[j].out
+		}
+		if lo.t < hi.t {
+			out = append(out, Span{lo, hi})
+		}
+		if a[i].out.t < b[j].out.t {
+			i++
+		} else {
+			j++
+		}
+	}
+	return out
+}
+
+func subtractSpans(a []Span, b []Span) []Span {
+	var out []Span
+	for _, s := range a {
+		cur := s
+		empty := false
+		for _, cut := range b {
+			if cut.out.t <= cur.in.t {
+				continue
+			}
+			if cut.in.t >= cur.out.t {
+				break
+			}
+			if cut.in.t > cur.in.t {
+				out = append(out, Span{cur.in, flip(cut.in)})
+			}
+			if cut.out.t >= cur.out.t {
+				empty = true
+				break
+			}
+			cur.in = flip(cut.out)
+		}
+		if !empty {
+			out = append(out, cur)
+		}
+	}
+	return out
+}
+
+func flip(b Boundary) Boundary {
+	b.flip = !b.flip
+	return b
+}
+
+// spansFromHits pairs up the sorted surface crossings of a closed primitive
+// into inside spans. A stray odd crossing from a grazing ray is dropped.
+func spansFromHits(p Primitive, ts []float64) []Span {
+	sort.Float64s(ts)
+	spans := make([]Span, 0, len(ts)/2)
+	for i := 0; i+1 < len(ts); i += 2 {
+		spans = append(spans, Span{Boundary{t: ts[i], surface: p}, Boundary{t: ts[i+1], surface: p}})
+	}
+	return spans
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// spanList makes spans from pairs of entry and exit distances.
+func spanList(ts ...float64) []Span {
+	var spans []Span
+	for i := 0; i+1 < len(ts); i += 2 {
+		spans = append(spans, Span{Boundary{t: ts[i]}, Boundary{t: ts[i+1]}})
+	}
+	return spans
+}
+
+// spanEnds returns the entry and exit distances of spans, in pairs.
+func spanEnds(spans []Span) []float64 {
+	ts := []float64{}
+	for _, s := range spans {
+		ts = append(ts, s.in.t, s.out.t)
+	}
+	return ts
+}
+
+func TestSpanOperations(t *testing.T) {
+	tests := []struct {
+		name                   string
+		a, b                   []Span
+		union, inter, subtract []float64
+	}{
+		{"overlapping", spanList(1, 4), spanList(2, 6), []float64{1, 6}, []float64{2, 4}, []float64{1, 2}},
+		{"overlapping from before", spanList(3, 6), spanList(1, 4), []float64{1, 6}, []float64{3, 4}, []float64{4, 6}},
+		{"nested", spanList(1, 8), spanList(3, 5), []float64{1, 8}, []float64{3, 5}, []float64{1, 3, 5, 8}},
+		{"nesting", spanList(3, 5), spanList(1, 8), []float64{1, 8}, []float64{3, 5}, []float64{}},
+		{"disjoint", spanList(1, 2), spanList(3, 4), []float64{1, 2, 3, 4}, []float64{}, []float64{1, 2}},
+		{"touching", spanList(1, 2), spanList(2, 3), []float64{1, 3}, []float64{}, []float64{1, 2}},
+		{"equal", spanList(1, 2), spanList(1, 2), []float64{1, 2}, []float64{1, 2}, []float64{}},
+		{"several", spanList(1, 3, 5, 7, 9, 11), spanList(2, 6, 10, 12), []float64{1, 7, 9, 12}, []float64{2, 3, 5, 6, 10, 11}, []float64{1, 2, 6, 7, 9, 10}},
+		{"one cut through two", spanList(1, 3, 4, 6), spanList(2, 5), []float64{1, 6}, []float64{2, 3, 4, 5}, []float64{1, 2, 5, 6}},
+		{"empty", spanList(1, 2), nil, []float64{1, 2}, []float64{}, []float64{1, 2}},
+	}
+	for _, test := range tests {
+		if got := spanEnds(unionSpans(test.a, test.b)); !reflect.DeepEqual(got, test.union) {
+			t.Errorf("%s: union = %v, want %v", test.name, got, test.union)
+		}
+		if got := spanEnds(intersectSpans(test.a, test.b)); !reflect.DeepEqual(got, test.inter) {
+			t.Errorf("%s: intersection = %v, want %v", test.name, got, test.inter)
+		}
+		if got := spanEnds(subtractSpans(test.a, test.b)); !reflect.DeepEqual(got, test.subtract) {
+			t.Errorf("%s: difference = %v, want %v", test.name, got, test.subtract)
+		}
+	}
+}
+
+func TestSubtractSpansFlipsCutWalls(t *testing.T) {
+	// The walls a cut leaves inside the solid are the cutter's, seen from
+	// inside it; the solid's own surfaces are left as they are.
+	got := subtractSpans(spanList(1, 8), spanList(3, 5))
+	want := []bool{false, true, true, false}
+	for i, b := range []Boundary{got[0].in, got[0].out, got[1].in, got[1].out} {
+		if b.flip != want[i] {
+			t.Errorf("boundary at %v flipped = %v, want %v", b.t, b.flip, want[i])
+		}
+	}
+}
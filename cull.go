@@ -0,0 +1,69 @@
+package main
+
+// Culled caches an object's bounding box and rejects rays that miss it
+// before running the object's own intersection test.
+type Culled struct {
+	object Object
+	bounds AABB
+}
+
+func MakeCulled(object Object) Culled {
+	var c Culled
+	c.object = object
+	c.bounds = boundsOf(object)
+	return c
+}
+
+// hitsBounds reports whether the ray meets box within [t_min, t_max].
+// Infinite boxes always pass.
+func hitsBounds(box AABB, origin Vector, direction Vector, t_min float64, t_max float64) bool {
+	if !box.Finite() {
+		return true
+	}
+	inv_dir := MakeVector(1/direction.x, 1/direction.y, 1/direction.z)
+	return box.Hit(origin, inv_dir, t_min, t_max)
+}
+
+func (c *Culled) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	if !hitsBounds(c.bounds, origin, direction, t_min, t_max) {
+		return 0, false
+	}
+	return c.object.Intersect(origin, direction, t_min, t_max)
+}
+
+func (c *Culled) Hit(origin Vector, direction Vector, t_min float64, t_max float64) (Primitive, float64) {
+	if !hitsBounds(c.bounds, origin, direction, t_min, t_max) {
+		return nil, 0
+	}
+	if compound, ok := c.object.(Compound); ok {
+		return compound.Hit(origin, direction, t_min, t_max)
+	}
+	if t, ok := c.object.Intersect(origin, direction, t_min, t_max); ok {
+		return c.object.(Primitive), t
+	}
+	return nil, 0
+}
+
+func (c *Culled) Bounds() AABB {
+	return c.bounds
+}
+
+// cullObjects wraps every bounded object whose intersection test costs more
+// than a box test. Spheres, triangles, boxes and discs are left as they are.
+func cullObjects(objects []Object) []Object {
+	culled := make([]Object, len(objects))
+	for i, object := range objects {
+		switch object.(type) {
+		case *Sphere, *Triangle, *MeshFace, *Box, *Disk, *Group:
+			culled[i] = object
+			continue
+		}
+		if !boundsOf(object).Finite() {
+			culled[i] = object
+			continue
+		}
+		c := MakeCulled(object)
+		culled[i] = &c
+	}
+	return culled
+}
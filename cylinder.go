@@ -0,0 +1,108 @@
+package main
+
+import "math"
+
+// Cylinder is a solid, capped cylinder standing on base and extending
+// height units along axis.
+type Cylinder struct {
+	base     Vector
+	axis     Vector
+	radius   float64
+	height   float64
+	material *Material
+}
+
+func MakeCylinder(base Vector, axis Vector, radius float64, height float64, material *Material) Cylinder {
+	var c Cylinder
+	c.base = base
+	c.axis = normalize(axis)
+	c.radius = radius
+	c.height = height
+	c.material = material
+	return c
+}
+
+// IntersectRayCylinder returns the nearest hit beyond t_min against the
+// cylinder's side and caps.
+func IntersectRayCylinder(origin Vector, direction Vector, cylinder Cylinder, t_min float64) float64 {
+	hits, n := cylinderHits(origin, direction, cylinder)
+	best_t, _ := nearestIn(t_min, math.Inf(1), hits[:n]...)
+	return best_t
+}
+
+// cylinderHits returns every t at which the ray crosses the cylinder's
+// side or caps.
+func cylinderHits(origin Vector, direction Vector, cylinder Cylinder) ([4]float64, int) {
+	var hits [4]float64
+	n := 0
+	a := cylinder.axis
+	CO := sub(origin, cylinder.base)
+	d_a := dot(direction, a)
+	co_a := dot(CO, a)
+
+	// Side: solve the quadratic for the components perpendicular to the axis.
+	d_perp := sub(direction, scale(a, d_a))
+	co_perp := sub(CO, scale(a, co_a))
+	qa := dot(d_perp, d_perp)
+	qb := 2 * dot(d_perp, co_perp)
+	qc := dot(co_perp, co_perp) - cylinder.radius*cylinder.radius
+	discrim := qb*qb - 4*qa*qc
+	if qa > 1e-12 && discrim >= 0 {
+		sq := math.Sqrt(discrim)
+		for _, t := range [2]float64{(-qb - sq) / (2 * qa), (-qb + sq) / (2 * qa)} {
+			h := co_a + t*d_a
+			if h >= 0 && h <= cylinder.height {
+				hits[n] = t
+				n++
+			}
+		}
+	}
+
+	// Caps: intersect the two end planes and keep hits inside the radius.
+	if math.Abs(d_a) > 1e-12 {
+		for _, h := range [2]float64{0, cylinder.height} {
+			t := (h - co_a) / d_a
+			p := add(CO, scale(direction, t))
+			r := sub(p, scale(a, h))
+			if dot(r, r) <= cylinder.radius*cylinder.radius {
+				hits[n] = t
+				n++
+			}
+		}
+	}
+	return hits, n
+}
+
+func (c *Cylinder) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	return nearestIn(t_min, t_max, IntersectRayCylinder(origin, direction, *c, t_min))
+}
+
+func (c *Cylinder) NormalAt(point Vector) Vector {
+	// Shade with whichever of the side or caps the point lies closest to.
+	cp := sub(point, c.base)
+	h := dot(cp, c.axis)
+	radial := sub(cp, scale(c.axis, h))
+	side_err := math.Abs(norm(radial) - c.radius)
+	if math.Abs(h) < side_err && math.Abs(h) <= math.Abs(h-c.height) {
+		return neg(c.axis)
+	}
+	if math.Abs(h-c.height) < side_err {
+		return c.axis
+	}
+	return normalize(radial)
+}
+
+func (c *Cylinder) Material() *Material {
+	return c.material
+}
+
+func (c *Cylinder) Bounds() AABB {
+	e := discExtent(c.axis, c.radius)
+	top := add(c.base, scale(c.axis, c.height))
+	return AABB{sub(c.base, e), add(c.base, e)}.Union(AABB{sub(top, e), add(top, e)})
+}
+
+func (c *Cylinder) Spans(origin Vector, direction Vector) []Span {
+	hits, n := cylinderHits(origin, direction, *c)
+	return spansFromHits(c, hits[:n])
+}
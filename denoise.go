@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// Denoise filters a rendered canvas with Intel Open Image Denoise, running
+// its oidnDenoise example program (given as path, looked up on PATH if it
+// has no directory) on PFM files of the canvas's linear colors and of the
+// albedo and normal of what each pixel's center sees first. These guide
+// the filter to keep texture and geometric edges that the noise would
+// otherwise hide.
+func Denoise(canvas *Canvas, scene *Scene, camera *Camera, path string) error {
+	oidn, err := exec.LookPath(path)
+	if err != nil {
+		return fmt.Errorf("denoise: %v", err)
+	}
+	dir, err := os.MkdirTemp("", "denoise")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	features := RenderFeatures(scene, camera)
+	files := map[string][]Color{"color.pfm": canvas.radiance, "albedo.pfm": features.albedo, "normal.pfm": features.normal}
+	for name, pixels := range files {
+		if err := writePFM(filepath.Join(dir, name), pixels, Cw, Ch); err != nil {
+			return err
+		}
+	}
+
+	output := filepath.Join(dir, "output.pfm")
+	cmd := exec.Command(oidn,
+		"--hdr", filepath.Join(dir, "color.pfm"),
+		"--alb", filepath.Join(dir, "albedo.pfm"),
+		"--nrm", filepath.Join(dir, "normal.pfm"),
+		"-o", output)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("denoise: %v: %s", err, out)
+	}
+	denoised, err := readPFM(output, Cw, Ch)
+	if err != nil {
+		return fmt.Errorf("denoise: %v", err)
+	}
+	copy(canvas.radiance, denoised)
+	return nil
+}
+
+// writePFM writes a w x h color image, given row by row from the top, as a
+// little-endian Portable Float Map, whose rows run from the bottom.
+func writePFM(path string, pixels []Color, w int, h int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(f)
+	fmt.Fprintf(out, "PF\n%d %d\n-1.0\n", w, h)
+	row := make([]float32, 3*w)
+	for j := h - 1; j >= 0; j-- {
+		for i := 0; i < w; i++ {
+			c := pixels[j*w+i]
+			row[3*i], row[3*i+1], row[3*i+2] = float32(c.r), float32(c.g), float32(c.b)
+		}
+		if err := binary.Write(out, binary.LittleEndian, row); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := out.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readPFM reads a color Portable Float Map of the expected size.
+func readPFM(path string, w int, h int) ([]Color, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	in := bufio.NewReader(f)
+	var magic string
+	var fw, fh int
+	var endian float64
+	if _, err := fmt.Fscan(in, &magic, &fw, &fh, &endian); err != nil {
+		return nil, err
+	}
+	if magic != "PF" || fw != w || fh != h {
+		return nil, fmt.Errorf("%s: want a %dx%d color PFM", path, w, h)
+	}
+	if _, err := in.ReadByte(); err != nil { // the newline ending the header
+		return nil, err
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if endian > 0 {
+		order = binary.BigEndian
+	}
+	pixels := make([]Color, w*h)
+	row := make([]float32, 3*w)
+	for j := h - 1; j >= 0; j-- {
+		if err := binary.Read(in, order, row); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		for i := 0; i < w; i++ {
+			pixels[j*w+i] = Color{float64(row[3*i]), float64(row[3*i+1]), float64(row[3*i+2])}
+		}
+	}
+	return pixels, nil
+}
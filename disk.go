@@ -0,0 +1,66 @@
+package main
+
+import "math"
+
+// Disk is a flat ring around center facing normal, covering radii from
+// inner_radius to outer_radius. An inner radius of zero gives a solid disk.
+type Disk struct {
+	center       Vector
+	normal       Vector
+	inner_radius float64
+	outer_radius float64
+	material     *Material
+	tangent      Vector // tangent, bitangent and normal form an orthonormal basis
+	bitangent    Vector
+}
+
+func MakeDisk(center Vector, normal Vector, inner_radius float64, outer_radius float64, material *Material) Disk {
+	var d Disk
+	d.center = center
+	d.normal = normalize(normal)
+	d.inner_radius = inner_radius
+	d.outer_radius = outer_radius
+	d.material = material
+	d.tangent, d.bitangent = perpendicularBasis(d.normal)
+	return d
+}
+
+func (d *Disk) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	denom := dot(d.normal, direction)
+	if math.Abs(denom) < 1e-9 {
+		return math.Inf(1), false // ray is parallel to the disk
+	}
+	t := dot(sub(d.center, origin), d.normal) / denom
+	if t < t_min || t > t_max {
+		return math.Inf(1), false
+	}
+	offset := sub(add(origin, scale(direction, t)), d.center)
+	r2 := dot(offset, offset)
+	if r2 > d.outer_radius*d.outer_radius || r2 < d.inner_radius*d.inner_radius {
+		return math.Inf(1), false
+	}
+	return t, true
+}
+
+func (d *Disk) NormalAt(point Vector) Vector {
+	return d.normal
+}
+
+func (d *Disk) Material() *Material {
+	return d.material
+}
+
+func (d *Disk) Bounds() AABB {
+	e := discExtent(d.normal, d.outer_radius)
+	return AABB{sub(d.center, e), add(d.center, e)}
+}
+
+// UVAt maps the angle around the disk to u and the distance from the inner
+// to the outer edge to v, both in [0, 1].
+func (d *Disk) UVAt(point Vector) (float64, float64) {
+	offset := sub(point, d.center)
+	angle := math.Atan2(dot(offset, d.bitangent), dot(offset, d.tangent))
+	u := angle/(2*math.Pi) + 0.5
+	v := (norm(offset) - d.inner_radius) / (d.outer_radius - d.inner_radius)
+	return u, v
+}
@@ -0,0 +1,30 @@
+package main
+
+import "math"
+
+// Encode converts a pixel's linear color to the value written to the
+// image. Lighting adds and scales light linearly, but 8-bit images are
+// viewed through a display's transfer curve, so linear values look too
+// dark. scene.encoding "srgb" applies the sRGB curve, "gamma" raises each
+// channel to 1/scene.gamma, and "linear" (the default) writes the values
+// as they are. Colors are tone mapped and clamped to [0, 1] first.
+func (s *Scene) Encode(c Color) Color {
+	c = s.ToneMap(c)
+	c = ClampColor(c)
+	switch s.encoding {
+	case "srgb":
+		return Color{srgbEncode(c.r), srgbEncode(c.g), srgbEncode(c.b)}
+	case "gamma":
+		k := 1 / s.gamma
+		return Color{math.Pow(c.r, k), math.Pow(c.g, k), math.Pow(c.b, k)}
+	}
+	return c
+}
+
+// srgbEncode is the sRGB transfer function for a linear value in [0, 1].
+func srgbEncode(v float64) float64 {
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
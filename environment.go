@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// irradianceWidth and irradianceHeight size the prefiltered map used for
+// diffuse light from the environment, and irradianceSource caps the width
+// of the copy of the environment it is filtered from.
+const (
+	irradianceWidth  = 32
+	irradianceHeight = 16
+	irradianceSource = 64
+)
+
+// Environment is a latitude-longitude image of the light arriving from
+// every direction, seen where rays escape the scene and lighting diffuse
+// surfaces as ambient light. Its values are not clamped to [0, 1].
+type Environment struct {
+	width      int
+	height     int
+	pixels     []Color // row-major, with row 0 looking straight up
+	irradiance []Color // irradianceWidth x irradianceHeight, laid out the same way
+}
+
+// LoadEnvironment reads an environment from a Radiance .hdr file or from
+// any image format the image package decodes.
+func LoadEnvironment(path string) (*Environment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var e *Environment
+	if strings.ToLower(filepath.Ext(path)) == ".hdr" {
+		e, err = readRGBE(bufio.NewReader(f))
+	} else {
+		e, err = decodeEnvironment(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	e.prefilter()
+	return e, nil
+}
+
+func decodeEnvironment(r io.Reader) (*Environment, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	e := &Environment{width: bounds.Dx(), height: bounds.Dy()}
+	e.pixels = make([]Color, e.width*e.height)
+	for y := 0; y < e.height; y++ {
+		for x := 0; x < e.width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			e.pixels[y*e.width+x] = Color{float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff}
+		}
+	}
+	return e, nil
+}
+
+// readRGBE decodes a Radiance picture with the standard -Y H +X W
+// orientation, in flat or run-length encoded scanlines.
+func readRGBE(r *bufio.Reader) (*Environment, error) {
+	line, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "#?") {
+		return nil, fmt.Errorf("not a Radiance HDR file")
+	}
+	for {
+		line, err = r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("unsupported %s", line)
+		}
+	}
+	line, err = r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	e := &Environment{}
+	if _, err := fmt.Sscanf(line, "-Y %d +X %d", &e.height, &e.width); err != nil {
+		return nil, fmt.Errorf("unsupported resolution line %q", strings.TrimSpace(line))
+	}
+	e.pixels = make([]Color, e.width*e.height)
+	scanline := make([][4]byte, e.width)
+	for y := 0; y < e.height; y++ {
+		if err := readRGBEScanline(r, scanline); err != nil {
+			return nil, err
+		}
+		for x, p := range scanline {
+			if p[3] == 0 {
+				continue
+			}
+			f := math.Ldexp(1, int(p[3])-(128+8))
+			e.pixels[y*e.width+x] = Color{float64(p[0]) * f, float64(p[1]) * f, float64(p[2]) * f}
+		}
+	}
+	return e, nil
+}
+
+func readRGBEScanline(r *bufio.Reader, scanline [][4]byte) error {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return err
+	}
+	width := len(scanline)
+	if width < 8 || width > 0x7fff || head[0] != 2 || head[1] != 2 || head[2]&0x80 != 0 {
+		// Flat pixels.
+		scanline[0] = head
+		for x := 1; x < width; x++ {
+			if _, err := io.ReadFull(r, scanline[x][:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if int(head[2])<<8|int(head[3]) != width {
+		return fmt.Errorf("scanline width mismatch")
+	}
+	// Each of the four channels is run-length encoded in turn.
+	for c := 0; c < 4; c++ {
+		for x := 0; x < width; {
+			count, err := r.ReadByte()
+			if err != nil {
+				return err
+			}
+			if count > 128 {
+				n := int(count) - 128
+				value, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				if x+n > width {
+					return fmt.Errorf("run overflows scanline")
+				}
+				for ; n > 0; n-- {
+					scanline[x][c] = value
+					x++
+				}
+			} else {
+				n := int(count)
+				if n == 0 || x+n > width {
+					return fmt.Errorf("bad scanline run")
+				}
+				for ; n > 0; n-- {
+					value, err := r.ReadByte()
+					if err != nil {
+						return err
+					}
+					scanline[x][c] = value
+					x++
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// writeRGBE writes a w x h image, row by row from the top, as a Radiance
+// picture with flat scanlines, which every reader accepts.
+func writeRGBE(path string, pixels []Color, w int, h int) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(f)
+	fmt.Fprintf(out, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", h, w)
+	for _, c := range pixels[:w*h] {
+		out.Write(rgbe(c))
+	}
+	if err := out.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rgbe encodes a color as three mantissas sharing the exponent of the
+// brightest channel. Negative channels are written as zero.
+func rgbe(c Color) []byte {
+	peak := math.Max(c.r, math.Max(c.g, c.b))
+	if peak < 1e-32 {
+		return []byte{0, 0, 0, 0}
+	}
+	frac, exp := math.Frexp(peak)
+	k := frac * 256 / peak
+	channel := func(v float64) byte {
+		return byte(math.Max(0, v*k))
+	}
+	return []byte{channel(c.r), channel(c.g), channel(c.b), byte(exp + 128)}
+}
+
+// Sample returns the light arriving from a direction, filtered bilinearly.
+func (e *Environment) Sample(direction Vector) Color {
+	return sampleLatLong(e.pixels, e.width, e.height, normalize(direction))
+}
+
+// Irradiance returns the cosine-weighted average of the environment over
+// the hemisphere around a unit normal: the ambient light a matte surface
+// facing that way receives.
+func (e *Environment) Irradiance(normal Vector) Color {
+	return sampleLatLong(e.irradiance, irradianceWidth, irradianceHeight, normal)
+}
+
+// latLongDirection returns the direction through the center of pixel (x, y)
+// of a w x h latitude-longitude map, and the solid angle it covers.
+func latLongDirection(x int, y int, w int, h int) (Vector, float64) {
+	theta := (float64(y) + 0.5) / float64(h) * math.Pi
+	phi := ((float64(x)+0.5)/float64(w) - 0.5) * 2 * math.Pi
+	sin_theta := math.Sin(theta)
+	solid_angle := (2 * math.Pi / float64(w)) * (math.Pi / float64(h)) * sin_theta
+	return MakeVector(sin_theta*math.Sin(phi), math.Cos(theta), sin_theta*math.Cos(phi)), solid_angle
+}
+
+func sampleLatLong(pixels []Color, w int, h int, d Vector) Color {
+	u := 0.5 + math.Atan2(d.x, d.z)/(2*math.Pi)
+	v := math.Acos(math.Max(-1, math.Min(1, d.y))) / math.Pi
+	x, y := u*float64(w)-0.5, v*float64(h)-0.5
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	texel := func(i int, j int) Color {
+		i = ((i % w) + w) % w
+		if j < 0 {
+			j = 0
+		} else if j >= h {
+			j = h - 1
+		}
+		return pixels[j*w+i]
+	}
+	i, j := int(x0), int(y0)
+	c00, c10, c01, c11 := texel(i, j), texel(i+1, j), texel(i, j+1), texel(i+1, j+1)
+	mix := func(a float64, b float64, c float64, d float64) float64 {
+		return (a*(1-fx)+b*fx)*(1-fy) + (c*(1-fx)+d*fx)*fy
+	}
+	return Color{mix(c00.r, c10.r, c01.r, c11.r), mix(c00.g, c10.g, c01.g, c11.g), mix(c00.b, c10.b, c01.b, c11.b)}
+}
+
+// prefilter convolves a downsampled copy of the environment with a cosine
+// lobe for every direction of the irradiance map.
+func (e *Environment) prefilter() {
+	sw := e.width
+	if sw > irradianceSource {
+		sw = irradianceSource
+	}
+	sh := (sw + 1) / 2
+	directions := make([]Vector, sw*sh)
+	radiance := make([]Color, sw*sh)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			d, solid_angle := latLongDirection(x, y, sw, sh)
+			c := e.Sample(d)
+			directions[y*sw+x] = d
+			radiance[y*sw+x] = Color{c.r * solid_angle, c.g * solid_angle, c.b * solid_angle}
+		}
+	}
+	e.irradiance = make([]Color, irradianceWidth*irradianceHeight)
+	for y := 0; y < irradianceHeight; y++ {
+		for x := 0; x < irradianceWidth; x++ {
+			n, _ := latLongDirection(x, y, irradianceWidth, irradianceHeight)
+			var sum Color
+			for i, d := range directions {
+				if cos := dot(n, d); cos > 0 {
+					sum.r += radiance[i].r * cos
+					sum.g += radiance[i].g * cos
+					sum.b += radiance[i].b * cos
+				}
+			}
+			e.irradiance[y*irradianceWidth+x] = Color{sum.r / math.Pi, sum.g / math.Pi, sum.b / math.Pi}
+		}
+	}
+}
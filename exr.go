@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sort"
+)
+
+// exrLayer is a color image stored in an OpenEXR file as the channels R,
+// G and B, prefixed by name and a dot unless name is empty, as in
+// "albedo.R".
+type exrLayer struct {
+	name   string
+	pixels []Color // row by row from the top
+}
+
+// writeEXR writes w x h layers to an uncompressed scanline OpenEXR file
+// with 32-bit float channels, keeping the full range of the colors, along
+// with single channels by name, such as A for alpha or Z for depth.
+func writeEXR(path string, w int, h int, layers []exrLayer, scalars map[string][]float64) error {
+	type channel struct {
+		name  string
+		value func(i int) float64
+	}
+	var channels []channel
+	for _, layer := range layers {
+		prefix := ""
+		if layer.name != "" {
+			prefix = layer.name + "."
+		}
+		pixels := layer.pixels
+		channels = append(channels,
+			channel{prefix + "R", func(i int) float64 { return pixels[i].r }},
+			channel{prefix + "G", func(i int) float64 { return pixels[i].g }},
+			channel{prefix + "B", func(i int) float64 { return pixels[i].b }})
+	}
+	for name, values := range scalars {
+		values := values
+		channels = append(channels, channel{name, func(i int) float64 { return values[i] }})
+	}
+	// Readers expect channels sorted by name.
+	sort.Slice(channels, func(i, j int) bool { return channels[i].name < channels[j].name })
+
+	var header bytes.Buffer
+	le := binary.LittleEndian
+	put := func(data interface{}) { binary.Write(&header, le, data) }
+	attribute := func(name string, kind string, size int) {
+		header.WriteString(name + "\x00" + kind + "\x00")
+		put(int32(size))
+	}
+	put([]byte{0x76, 0x2f, 0x31, 0x01}) // magic number
+	put(int32(2))                       // version 2, single-part scanline
+	size := 1
+	for _, c := range channels {
+		size += len(c.name) + 1 + 16
+	}
+	attribute("channels", "chlist", size)
+	for _, c := range channels {
+		header.WriteString(c.name + "\x00")
+		put(int32(2))       // FLOAT
+		put([4]byte{})      // pLinear and reserved
+		put([2]int32{1, 1}) // x and y sampling
+	}
+	header.WriteByte(0)
+	attribute("compression", "compression", 1)
+	header.WriteByte(0) // NO_COMPRESSION
+	window := [4]int32{0, 0, int32(w - 1), int32(h - 1)}
+	attribute("dataWindow", "box2i", 16)
+	put(window)
+	attribute("displayWindow", "box2i", 16)
+	put(window)
+	attribute("lineOrder", "lineOrder", 1)
+	header.WriteByte(0) // INCREASING_Y
+	attribute("pixelAspectRatio", "float", 4)
+	put(float32(1))
+	attribute("screenWindowCenter", "v2f", 8)
+	put([2]float32{0, 0})
+	attribute("screenWindowWidth", "float", 4)
+	put(float32(1))
+	header.WriteByte(0) // end of header
+
+	// The offset table follows the header, then one scanline per chunk.
+	bytes_per_line := 4 * w * len(channels)
+	start := uint64(header.Len() + 8*h)
+	for y := 0; y < h; y++ {
+		put(start + uint64(y*(8+bytes_per_line)))
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(f)
+	header.WriteTo(out)
+	row := make([]float32, w)
+	for y := 0; y < h; y++ {
+		binary.Write(out, le, [2]int32{int32(y), int32(bytes_per_line)})
+		for _, c := range channels {
+			for x := 0; x < w; x++ {
+				row[x] = float32(c.value(y*w + x))
+			}
+			binary.Write(out, le, row)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/rpc"
+	"runtime"
+	"sync"
+)
+
+// A render farm shares the tiles of each render among machines over
+// net/rpc. Every worker is started with -serve and the same flags as the
+// coordinator, so it builds the same scene, with the same model, texture
+// and environment files at the same paths; requests then carry only what
+// changes from one render to the next, the camera and integrator, and the
+// checkpointKey they give, which a worker with other settings refuses.
+// Pixels are seeded by position, so the frame comes out as it would on one
+// machine.
+
+// Farm is the coordinator's connection to the workers of a render farm.
+type Farm struct {
+	addrs   []string
+	clients []*rpc.Client
+	slots   []int // tiles each worker renders at once, one to a CPU
+}
+
+// FarmTileRequest asks a worker for one tile of a render.
+type FarmTileRequest struct {
+	Key               string
+	Camera            FarmCamera
+	Integrator        string
+	MaxRecursionDepth int
+	X0, Y0, X1, Y1    int
+}
+
+// FarmTileReply holds a tile's radiance and alpha, four values to a pixel,
+// column by column from (X0, Y0), and the rays traced for it.
+type FarmTileReply struct {
+	Pixels []float64
+	Rays   int64
+}
+
+// FarmCamera is a Camera with exported fields, which gob can encode.
+type FarmCamera struct {
+	Position, Right, Up, Forward, Velocity  [3]float64
+	Projection                              string
+	OrthoSize, FOV, Aperture, FocalDistance float64
+	Shutter, ShiftX, ShiftY                 float64
+}
+
+func makeFarmCamera(c *Camera) FarmCamera {
+	v := func(u Vector) [3]float64 { return [3]float64{u.x, u.y, u.z} }
+	return FarmCamera{v(c.position), v(c.right), v(c.up), v(c.forward), v(c.velocity),
+		c.projection, c.ortho_size, c.fov, c.aperture, c.focal_distance,
+		c.shutter, c.shift_x, c.shift_y}
+}
+
+func (f FarmCamera) camera() Camera {
+	v := func(u [3]float64) Vector { return Vector{u[0], u[1], u[2]} }
+	return Camera{position: v(f.Position), right: v(f.Right), up: v(f.Up), forward: v(f.Forward), velocity: v(f.Velocity),
+		projection: f.Projection, ortho_size: f.OrthoSize, fov: f.FOV, aperture: f.Aperture, focal_distance: f.FocalDistance,
+		shutter: f.Shutter, shift_x: f.ShiftX, shift_y: f.ShiftY}
+}
+
+// DialFarm connects to the workers listening at addrs.
+func DialFarm(addrs []string) (*Farm, error) {
+	f := &Farm{addrs: addrs}
+	for _, addr := range addrs {
+		client, err := rpc.Dial("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("farm: %v", err)
+		}
+		var slots int
+		if err := client.Call("Farm.Hello", struct{}{}, &slots); err != nil {
+			return nil, fmt.Errorf("farm: %s: %v", addr, err)
+		}
+		f.clients = append(f.clients, client)
+		f.slots = append(f.slots, slots)
+	}
+	return f, nil
+}
+
+// farmTileAttempts is how many times Farm.Render tries a tile before it
+// gives up on the farm.
+const farmTileAttempts = 3
+
+// farmResult is what a worker slot reports of a tile it was given.
+type farmResult struct {
+	tile int
+	addr string
+	err  error
+	lost bool // the connection failed, so the slot takes no more tiles
+}
+
+// Render has the workers render the tiles numbered in todo and puts them
+// on the canvas, calling finish for each. A tile whose call fails goes back
+// to be tried on another slot; a slot whose connection is lost takes no
+// more tiles, while one whose worker returned an error carries on. Render
+// fails when a tile has failed farmTileAttempts times or no slots are
+// left, once the calls under way have returned, with the tiles it did
+// finish on the canvas.
+func (f *Farm) Render(scene *Scene, camera *Camera, canvas *Canvas, max_recursion_depth int, tiles []Tile, todo []int, finish func(tile int, rays int64)) error {
+	jobs := make(chan int, len(todo))
+	for _, tile := range todo {
+		jobs <- tile
+	}
+	results := make(chan farmResult)
+	quit := make(chan struct{})
+	var slots sync.WaitGroup
+	alive := 0
+	base := FarmTileRequest{Key: checkpointKey(scene, camera), Camera: makeFarmCamera(camera), Integrator: scene.integrator, MaxRecursionDepth: max_recursion_depth}
+	for w, client := range f.clients {
+		for s := 0; s < f.slots[w]; s++ {
+			alive++
+			slots.Add(1)
+			go func(addr string, client *rpc.Client) {
+				defer slots.Done()
+				for tile := range jobs {
+					t := tiles[tile]
+					request := base
+					request.X0, request.Y0, request.X1, request.Y1 = t.x0, t.y0, t.x1, t.y1
+					var reply FarmTileReply
+					err := client.Call("Farm.Tile", request, &reply)
+					select {
+					case <-quit:
+						return // Render has given up
+					default:
+					}
+					if err == nil {
+						canvas.Paint(func() {
+							k := 0
+							for x := t.x0; x < t.x1; x++ {
+								for y := t.y0; y < t.y1; y++ {
+									p := reply.Pixels[4*k : 4*k+4]
+									canvas.PutPixel(x, y, Color{p[0], p[1], p[2]}, p[3])
+									k++
+								}
+							}
+						})
+						finish(tile, reply.Rays)
+					}
+					_, returned := err.(rpc.ServerError)
+					result := farmResult{tile, addr, err, err != nil && !returned}
+					select {
+					case results <- result:
+					case <-quit:
+						return
+					}
+					if result.lost {
+						return
+					}
+				}
+			}(f.addrs[w], client)
+		}
+	}
+
+	var err error
+	attempts := map[int]int{}
+	for remaining := len(todo); remaining > 0 && err == nil; {
+		result := <-results
+		if result.err == nil {
+			remaining--
+			continue
+		}
+		log.Printf("farm: %s: %v", result.addr, result.err)
+		if result.lost {
+			alive--
+		}
+		if attempts[result.tile]++; attempts[result.tile] >= farmTileAttempts {
+			err = fmt.Errorf("farm: a tile failed %d times", farmTileAttempts)
+		} else if alive == 0 {
+			err = fmt.Errorf("farm: no workers left")
+		} else {
+			jobs <- result.tile
+		}
+	}
+	close(quit)
+	close(jobs)
+	slots.Wait()
+	return err
+}
+
+// FarmService is the RPC service a farm worker serves, rendering tiles of
+// its scene. It keeps one canvas, for the render it was last asked about.
+type FarmService struct {
+	scene  *Scene
+	lock   sync.RWMutex // held for reading while tiles are painted
+	key    string
+	paint  tilePainter
+	canvas *Canvas
+}
+
+// ServeFarm serves tiles of the scene to a coordinator on addr, until it
+// fails.
+func ServeFarm(addr string, scene *Scene) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName("Farm", &FarmService{scene: scene}); err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Printf("farm: serving tiles on %s", listener.Addr())
+	server.Accept(listener)
+	return fmt.Errorf("farm: stopped listening on %s", addr)
+}
+
+// Hello replies with the number of tiles the worker renders at once.
+func (f *FarmService) Hello(_ struct{}, slots *int) error {
+	*slots = runtime.GOMAXPROCS(0)
+	return nil
+}
+
+// Tile renders one tile.
+func (f *FarmService) Tile(request FarmTileRequest, reply *FarmTileReply) error {
+	camera := request.Camera.camera()
+	scene := *f.scene
+	scene.integrator = request.Integrator
+	if checkpointKey(&scene, &camera) != request.Key {
+		return fmt.Errorf("worker started with other settings than the coordinator")
+	}
+	f.lock.RLock()
+	for f.key != request.Key {
+		// A new render: wait for any tiles of the last to finish.
+		f.lock.RUnlock()
+		f.lock.Lock()
+		if f.key != request.Key {
+			f.scene.CacheOrigin(camera.position)
+			f.canvas = MakeCanvas(&scene)
+			f.paint = painter(&scene, &camera, f.canvas, request.MaxRecursionDepth)
+			f.key = request.Key
+		}
+		f.lock.Unlock()
+		f.lock.RLock()
+	}
+	defer f.lock.RUnlock()
+
+	worker := scene
+	worker.rng, worker.rays = newRNG(), new(int64)
+	if f.scene.timings != nil {
+		// Tiles render at once, so each times its own rays.
+		worker.timings = new(Timings)
+		defer f.scene.timings.merge(worker.timings)
+	}
+	tile := Tile{request.X0, request.Y0, request.X1, request.Y1}
+	f.paint(&worker)(tile)
+	for x := tile.x0; x < tile.x1; x++ {
+		for y := tile.y0; y < tile.y1; y++ {
+			var c Color
+			var alpha float64
+			if i, j := ChangeCoord2D(x, y); j >= 0 && j < Ch {
+				c, alpha = f.canvas.radiance[j*Cw+i], f.canvas.alpha[j*Cw+i]
+			}
+			reply.Pixels = append(reply.Pixels, c.r, c.g, c.b, alpha)
+		}
+	}
+	reply.Rays = *worker.rays
+	return nil
+}
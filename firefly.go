@@ -0,0 +1,49 @@
+package main
+
+import "sort"
+
+// ClampSample limits a single sample of a pixel to scene.max_radiance in
+// its brightest channel, scaling it down whole to keep its hue. Rare paths
+// that find a small bright light through a mirror or a glossy bounce
+// otherwise leave isolated white pixels, fireflies, which take many more
+// samples to average out. The clamp loses that energy, so it biases the
+// render darker; 0 disables it.
+func (s *Scene) ClampSample(c Color) Color {
+	if s.max_radiance <= 0 {
+		return c
+	}
+	peak := c.r
+	if c.g > peak {
+		peak = c.g
+	}
+	if c.b > peak {
+		peak = c.b
+	}
+	if peak <= s.max_radiance {
+		return c
+	}
+	return WeightColor(c, s.max_radiance/peak)
+}
+
+// medianOfMeans returns the median, channel by channel, of the means of
+// groups of a pixel's samples. A firefly raises the mean of only the group
+// it falls in, which the median then passes over.
+func medianOfMeans(means []Color) Color {
+	median := func(channel func(Color) float64) float64 {
+		values := make([]float64, len(means))
+		for i, c := range means {
+			values[i] = channel(c)
+		}
+		sort.Float64s(values)
+		mid := len(values) / 2
+		if len(values)%2 == 0 {
+			return (values[mid-1] + values[mid]) / 2
+		}
+		return values[mid]
+	}
+	return Color{
+		median(func(c Color) float64 { return c.r }),
+		median(func(c Color) float64 { return c.g }),
+		median(func(c Color) float64 { return c.b }),
+	}
+}
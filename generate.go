@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// generateCommand runs the generate subcommand,
+//
+//	graphics-from-scratch generate [-seed n] [-grid n] [-render image] scene.json [render flags]
+//
+// which writes a random field of spheres after the cover of Ray Tracing in
+// One Weekend to a JSON or YAML scene file. With -render it returns the
+// arguments main renders that file with, the flags after it included.
+func generateCommand(arguments []string) ([]string, error) {
+	flags := flag.NewFlagSet("generate", flag.ExitOnError)
+	seed := flags.Int64("seed", 1, "seed for placing the spheres; the same seed generates the same scene")
+	grid := flags.Int("grid", 11, "scatter small spheres over the squares from -grid to grid, one to a square")
+	render := flags.String("render", "", "also render the scene to this image, with the flags given after the scene file")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s generate [flags] scene.json|scene.yaml [render flags]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(arguments)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return nil, errors.New("generate: no scene file to write")
+	}
+	if *grid < 0 {
+		return nil, fmt.Errorf("generate: -grid must be 0 or more, not %d", *grid)
+	}
+	path := flags.Arg(0)
+	if *render == "" && flags.NArg() > 1 {
+		return nil, errors.New("generate: flags after the scene file are for -render")
+	}
+
+	rng := newRNG()
+	rng.Seed(*seed)
+	file := GenerateScene(rng, *grid)
+	if err := writeSceneFile(path, file); err != nil {
+		return nil, fmt.Errorf("generate: %v", err)
+	}
+	if *render == "" {
+		return nil, nil
+	}
+	return append([]string{"-scene", path, "-output", *render}, flags.Args()[1:]...), nil
+}
+
+// GenerateScene returns a ground plane under three large spheres, of glass,
+// brown and polished metal, among small ones, one to each unit square from
+// -grid to grid in x and z: four in five matte, three in twenty metal and
+// the rest glass.
+func GenerateScene(rng *rand.Rand, grid int) SceneFile {
+	// Rounding keeps the file short.
+	round := func(x float64) float64 { return math.Round(x*1000) / 1000 }
+	vector := func(x, y, z float64) []float64 { return []float64{round(x), round(y), round(z)} }
+	shininess := 500.0
+	file := SceneFile{
+		Cameras: map[string]SceneCamera{
+			"default":  {Position: vector(12, 2.4, 3.5), LookAt: vector(0, 0.3, 0)},
+			"overview": {Position: vector(0, 16, 8), LookAt: vector(0, 0, 0)},
+		},
+		Materials: map[string]SceneMaterial{
+			"ground": {Color: vector(0.5, 0.5, 0.5)},
+			"glass":  {Color: vector(1, 1, 1), Specular: &shininess, Transparency: 1, IOR: 1.5},
+			"brown":  {Color: vector(0.4, 0.2, 0.1)},
+			"metal":  {Color: vector(0.7, 0.6, 0.5), Model: "pbr", Metallic: 1},
+		},
+		Objects: []SceneObject{{Type: "plane", Point: vector(0, 0, 0), Normal: vector(0, 1, 0), Material: "ground"}},
+		Lights: []SceneLight{
+			{Type: "ambient", Intensity: vector(0.3, 0.3, 0.3)},
+			{Type: "directional", Intensity: vector(0.7, 0.7, 0.7), Direction: vector(-1, 4, 2)},
+		},
+	}
+	sphere := func(center []float64, radius float64, material string) {
+		file.Objects = append(file.Objects, SceneObject{Type: "sphere", Center: center, Radius: radius, Material: material})
+	}
+	for a := -grid; a < grid; a++ {
+		for b := -grid; b < grid; b++ {
+			choose := rng.Float64()
+			x, z := float64(a)+0.9*rng.Float64(), float64(b)+0.9*rng.Float64()
+			if math.Hypot(x-4, z) <= 0.9 {
+				continue // clear of the metal sphere
+			}
+			name := "glass"
+			if choose < 0.95 {
+				name = fmt.Sprintf("sphere%d", len(file.Objects))
+				var material SceneMaterial
+				if choose < 0.8 {
+					material.Color = vector(rng.Float64()*rng.Float64(), rng.Float64()*rng.Float64(), rng.Float64()*rng.Float64())
+				} else {
+					material.Color = vector(0.5+0.5*rng.Float64(), 0.5+0.5*rng.Float64(), 0.5+0.5*rng.Float64())
+					material.Model, material.Metallic, material.Roughness = "pbr", 1, round(0.5*rng.Float64())
+				}
+				file.Materials[name] = material
+			}
+			sphere(vector(x, 0.2, z), 0.2, name)
+		}
+	}
+	sphere(vector(0, 1, 0), 1, "glass")
+	sphere(vector(-4, 1, 0), 1, "brown")
+	sphere(vector(4, 1, 0), 1, "metal")
+	return file
+}
+
+// numberArray matches a JSON array of numbers, as MarshalIndent puts it.
+var numberArray = regexp.MustCompile(`\[[-+0-9.eE,\s]*\]`)
+
+// writeSceneFile writes a scene file, as YAML if the path ends in .yaml or
+// .yml and otherwise as JSON, with vectors and colors kept on one line.
+func writeSceneFile(path string, file SceneFile) error {
+	var data []byte
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var node yaml.Node
+		if err := node.Encode(file); err != nil {
+			return err
+		}
+		var flow func(n *yaml.Node)
+		flow = func(n *yaml.Node) {
+			if n.Kind == yaml.SequenceNode && len(n.Content) > 0 && n.Content[0].Kind == yaml.ScalarNode {
+				n.Style = yaml.FlowStyle
+			}
+			for _, child := range n.Content {
+				flow(child)
+			}
+		}
+		flow(&node)
+		var out bytes.Buffer
+		encoder := yaml.NewEncoder(&out)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&node); err != nil {
+			return err
+		}
+		data = out.Bytes()
+	default:
+		var err error
+		if data, err = json.MarshalIndent(file, "", "  "); err != nil {
+			return err
+		}
+		data = numberArray.ReplaceAllFunc(data, func(array []byte) []byte {
+			return []byte("[" + strings.Join(strings.Fields(string(array[1:len(array)-1])), " ") + "]")
+		})
+		data = append(data, '\n')
+	}
+	return os.WriteFile(path, data, 0644)
+}
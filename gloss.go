@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// glossySamples is the number of rays averaged for a rough reflection. When
+// path tracing each hit takes a single ray and the pixel's samples do the
+// averaging.
+const glossySamples = 8
+
+// TraceGlossy returns the color reflected about the mirror direction R. A
+// surface with zero roughness reflects along R alone; rougher surfaces
+// average rays spread over a cone around R whose half-angle reaches a right
+// angle at roughness 1.
+func TraceGlossy(scene *Scene, point Vector, R Vector, normal Vector, roughness float64, recursion_depth int) Color {
+	if roughness <= 0 {
+		return TraceRay(scene, point, R, scene.Bias(point), math.Inf(1), recursion_depth)
+	}
+	n := glossySamples
+	if scene.path_samples > 0 {
+		n = 1
+	}
+	var r, g, b float64
+	for i := 0; i < n; i++ {
+		c := TraceRay(scene, point, glossyDirection(scene.rng, R, normal, roughness), scene.Bias(point), math.Inf(1), recursion_depth)
+		r, g, b = r+c.r, g+c.g, b+c.b
+	}
+	return MakeColor(r/float64(n), g/float64(n), b/float64(n))
+}
+
+// glossyDirection returns R for a smooth surface, or else a random
+// direction in TraceGlossy's cone around it, kept above the surface.
+func glossyDirection(rng *rand.Rand, R Vector, normal Vector, roughness float64) Vector {
+	if roughness <= 0 {
+		return R
+	}
+	direction := ConeSample(rng, normalize(R), math.Cos(math.Min(roughness, 1)*math.Pi/2))
+	if d := dot(direction, normal); d < 0 {
+		direction = sub(direction, scale(normal, 2*d))
+	}
+	return direction
+}
+
+// ConeSample returns a random unit direction, uniformly distributed over
+// the directions within the cone around the unit axis whose half-angle has
+// cosine cos_max.
+func ConeSample(rng *rand.Rand, axis Vector, cos_max float64) Vector {
+	return coneDirection(axis, cos_max, rng.Float64(), rng.Float64())
+}
+
+// coneDirection maps a point (s, t) of the unit square onto the directions
+// within the cone, preserving area, so stratified points give stratified
+// directions.
+func coneDirection(axis Vector, cos_max float64, s float64, t float64) Vector {
+	cos_theta := 1 - s*(1-cos_max)
+	sin_theta := math.Sqrt(math.Max(0, 1-cos_theta*cos_theta))
+	phi := 2 * math.Pi * t
+	a, b := perpendicularBasis(axis)
+	return add(add(scale(a, sin_theta*math.Cos(phi)), scale(b, sin_theta*math.Sin(phi))), scale(axis, cos_theta))
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The subset of the glTF 2.0 schema the loader understands.
+type gltfDocument struct {
+	Scene  *int `json:"scene"`
+	Scenes []struct {
+		Nodes []int `json:"nodes"`
+	} `json:"scenes"`
+	Nodes []struct {
+		Children    []int     `json:"children"`
+		Mesh        *int      `json:"mesh"`
+		Matrix      []float64 `json:"matrix"`
+		Translation []float64 `json:"translation"`
+		Rotation    []float64 `json:"rotation"`
+		Scale       []float64 `json:"scale"`
+	} `json:"nodes"`
+	Meshes []struct {
+		Primitives []struct {
+			Attributes map[string]int `json:"attributes"`
+			Indices    *int           `json:"indices"`
+			Material   *int           `json:"material"`
+			Mode       *int           `json:"mode"`
+		} `json:"primitives"`
+	} `json:"meshes"`
+	Materials []struct {
+		PBR *gltfPBR `json:"pbrMetallicRoughness"`
+	} `json:"materials"`
+	Accessors []struct {
+		BufferView    *int   `json:"bufferView"`
+		ByteOffset    int    `json:"byteOffset"`
+		ComponentType int    `json:"componentType"`
+		Count         int    `json:"count"`
+		Type          string `json:"type"`
+	} `json:"accessors"`
+	BufferViews []struct {
+		Buffer     int `json:"buffer"`
+		ByteOffset int `json:"byteOffset"`
+		ByteLength int `json:"byteLength"`
+		ByteStride int `json:"byteStride"`
+	} `json:"bufferViews"`
+	Buffers []struct {
+		URI        string `json:"uri"`
+		ByteLength int    `json:"byteLength"`
+	} `json:"buffers"`
+}
+
+type gltfPBR struct {
+	BaseColorFactor []float64 `json:"baseColorFactor"`
+	MetallicFactor  *float64  `json:"metallicFactor"`
+	RoughnessFactor *float64  `json:"roughnessFactor"`
+}
+
+// LoadGLTF reads the triangle meshes of the default scene of a .gltf or
+// .glb file, baking node transforms into the vertices. Each primitive
+// becomes its own Mesh so that it can keep its material; primitives with
+// no material use the supplied one.
+func LoadGLTF(path string, material *Material) ([]Mesh, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var bin []byte
+	if bytes.HasPrefix(data, []byte("glTF")) {
+		data, bin, err = splitGLB(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	var doc gltfDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	buffers := make([][]byte, len(doc.Buffers))
+	for i, b := range doc.Buffers {
+		switch {
+		case b.URI == "":
+			if bin == nil {
+				return nil, fmt.Errorf("%s: buffer %d has no data", path, i)
+			}
+			buffers[i] = bin
+		case strings.HasPrefix(b.URI, "data:"):
+			comma := strings.IndexByte(b.URI, ',')
+			if comma < 0 || !strings.HasSuffix(b.URI[:comma], ";base64") {
+				return nil, fmt.Errorf("%s: buffer %d: unsupported data URI", path, i)
+			}
+			buffers[i], err = base64.StdEncoding.DecodeString(b.URI[comma+1:])
+		default:
+			buffers[i], err = os.ReadFile(filepath.Join(filepath.Dir(path), filepath.FromSlash(b.URI)))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: buffer %d: %v", path, i, err)
+		}
+	}
+
+	// readAccessor returns every element of an accessor as float64s.
+	readAccessor := func(index int, want string) ([]float64, error) {
+		if index < 0 || index >= len(doc.Accessors) {
+			return nil, fmt.Errorf("accessor %d out of range", index)
+		}
+		acc := doc.Accessors[index]
+		if acc.Type != want {
+			return nil, fmt.Errorf("accessor %d is %s, want %s", index, acc.Type, want)
+		}
+		if acc.BufferView == nil {
+			return nil, fmt.Errorf("accessor %d has no buffer view", index)
+		}
+		if *acc.BufferView < 0 || *acc.BufferView >= len(doc.BufferViews) {
+			return nil, fmt.Errorf("accessor %d: buffer view %d out of range", index, *acc.BufferView)
+		}
+		view := doc.BufferViews[*acc.BufferView]
+		if view.Buffer < 0 || view.Buffer >= len(buffers) {
+			return nil, fmt.Errorf("buffer view %d: buffer %d out of range", *acc.BufferView, view.Buffer)
+		}
+		buf := buffers[view.Buffer]
+		components := map[string]int{"SCALAR": 1, "VEC3": 3}[want]
+		size := map[int]int{5121: 1, 5123: 2, 5125: 4, 5126: 4}[acc.ComponentType]
+		if size == 0 {
+			return nil, fmt.Errorf("accessor %d has unsupported component type %d", index, acc.ComponentType)
+		}
+		if acc.Count < 0 || acc.ByteOffset < 0 || view.ByteOffset < 0 || view.ByteStride < 0 {
+			return nil, fmt.Errorf("accessor %d has a negative count, offset or stride", index)
+		}
+		stride := view.ByteStride
+		if stride == 0 {
+			stride = size * components
+		}
+		if acc.Count > len(buf) {
+			return nil, fmt.Errorf("accessor %d runs past its buffer", index)
+		}
+		out := make([]float64, 0, acc.Count*components)
+		for i := 0; i < acc.Count; i++ {
+			for c := 0; c < components; c++ {
+				off := view.ByteOffset + acc.ByteOffset + i*stride + c*size
+				if off+size > len(buf) {
+					return nil, fmt.Errorf("accessor %d runs past its buffer", index)
+				}
+				switch acc.ComponentType {
+				case 5121:
+					out = append(out, float64(buf[off]))
+				case 5123:
+					out = append(out, float64(binary.LittleEndian.Uint16(buf[off:])))
+				case 5125:
+					out = append(out, float64(binary.LittleEndian.Uint32(buf[off:])))
+				case 5126:
+					out = append(out, float64(math.Float32frombits(binary.LittleEndian.Uint32(buf[off:]))))
+				}
+			}
+		}
+		return out, nil
+	}
+
+	var meshes []Mesh
+	var visit func(node int, parent Matrix4) error
+	visit = func(node int, parent Matrix4) error {
+		if node < 0 || node >= len(doc.Nodes) {
+			return fmt.Errorf("node %d out of range", node)
+		}
+		n := doc.Nodes[node]
+		world := parent.Mul(gltfLocalTransform(n.Matrix, n.Translation, n.Rotation, n.Scale))
+		if n.Mesh != nil {
+			if *n.Mesh < 0 || *n.Mesh >= len(doc.Meshes) {
+				return fmt.Errorf("node %d: mesh %d out of range", node, *n.Mesh)
+			}
+			for p, prim := range doc.Meshes[*n.Mesh].Primitives {
+				if prim.Mode != nil && *prim.Mode != 4 {
+					continue // only triangle lists
+				}
+				pos, ok := prim.Attributes["POSITION"]
+				if !ok {
+					return fmt.Errorf("mesh %d primitive %d has no POSITION", *n.Mesh, p)
+				}
+				xyz, err := readAccessor(pos, "VEC3")
+				if err != nil {
+					return err
+				}
+				vertices := make([]Vector, len(xyz)/3)
+				for i := range vertices {
+					vertices[i] = world.MulPoint(MakeVector(xyz[3*i], xyz[3*i+1], xyz[3*i+2]))
+				}
+				var indices []float64
+				if prim.Indices != nil {
+					if indices, err = readAccessor(*prim.Indices, "SCALAR"); err != nil {
+						return err
+					}
+				} else {
+					for i := range vertices {
+						indices = append(indices, float64(i))
+					}
+				}
+				faces := make([][3]int, 0, len(indices)/3)
+				for i := 0; i+2 < len(indices); i += 3 {
+					face := [3]int{int(indices[i]), int(indices[i+1]), int(indices[i+2])}
+					for _, v := range face {
+						if v >= len(vertices) {
+							return fmt.Errorf("mesh %d primitive %d: index %d out of range", *n.Mesh, p, v)
+						}
+					}
+					faces = append(faces, face)
+				}
+				if len(faces) == 0 {
+					continue
+				}
+				m := material
+				if prim.Material != nil && *prim.Material >= 0 && *prim.Material < len(doc.Materials) {
+					own := gltfMaterial(doc.Materials[*prim.Material].PBR)
+					m = &own
+				}
+				meshes = append(meshes, MakeMesh(vertices, faces, m))
+			}
+		}
+		for _, child := range n.Children {
+			if err := visit(child, world); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var roots []int
+	if len(doc.Scenes) > 0 {
+		scene := 0
+		if doc.Scene != nil {
+			scene = *doc.Scene
+		}
+		if scene < 0 || scene >= len(doc.Scenes) {
+			return nil, fmt.Errorf("%s: scene %d out of range", path, scene)
+		}
+		roots = doc.Scenes[scene].Nodes
+	} else {
+		for i := range doc.Nodes {
+			roots = append(roots, i)
+		}
+	}
+	for _, root := range roots {
+		if err := visit(root, Identity()); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	if len(meshes) == 0 {
+		return nil, fmt.Errorf("%s: no triangle meshes", path)
+	}
+	return meshes, nil
+}
+
+// splitGLB returns the JSON and binary chunks of a binary glTF container.
+func splitGLB(data []byte) ([]byte, []byte, error) {
+	if len(data) < 20 || binary.LittleEndian.Uint32(data[4:8]) != 2 {
+		return nil, nil, fmt.Errorf("unsupported GLB version")
+	}
+	var js, bin []byte
+	for off := 12; off+8 <= len(data); {
+		length := int(binary.LittleEndian.Uint32(data[off:]))
+		kind := string(data[off+4 : off+8])
+		if off+8+length > len(data) {
+			return nil, nil, fmt.Errorf("truncated GLB chunk")
+		}
+		chunk := data[off+8 : off+8+length]
+		switch kind {
+		case "JSON":
+			js = chunk
+		case "BIN\x00":
+			bin = chunk
+		}
+		off += 8 + length
+	}
+	if js == nil {
+		return nil, nil, fmt.Errorf("GLB has no JSON chunk")
+	}
+	return js, bin, nil
+}
+
+func gltfLocalTransform(matrix []float64, translation []float64, rotation []float64, scale []float64) Matrix4 {
+	if len(matrix) == 16 {
+		// glTF matrices are column-major.
+		var m Matrix4
+		for i, v := range matrix {
+			m[i%4][i/4] = v
+		}
+		return m
+	}
+	m := Identity()
+	if len(scale) == 3 {
+		m = Scale(MakeVector(scale[0], scale[1], scale[2]))
+	}
+	if len(rotation) == 4 {
+		m = m.Then(RotateQuaternion(rotation[0], rotation[1], rotation[2], rotation[3]))
+	}
+	if len(translation) == 3 {
+		m = m.Then(Translate(MakeVector(translation[0], translation[1], translation[2])))
+	}
+	return m
+}
+
+// gltfMaterial reads the metallic-roughness factors of a material, whose
+// defaults make a rough white metal.
+func gltfMaterial(pbr *gltfPBR) Material {
+	color := MakeColor(1, 1, 1)
+	metallic, roughness := 1., 1.
+	if pbr != nil {
+		if len(pbr.BaseColorFactor) >= 3 {
+			color = MakeColor(pbr.BaseColorFactor[0], pbr.BaseColorFactor[1], pbr.BaseColorFactor[2])
+		}
+		if pbr.MetallicFactor != nil {
+			metallic = *pbr.MetallicFactor
+		}
+		if pbr.RoughnessFactor != nil {
+			roughness = *pbr.RoughnessFactor
+		}
+	}
+	return MakePBRMaterial(color, metallic, roughness)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"math"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadGLTFIndices(t *testing.T) {
+	data := make([]byte, 36)
+	for i, v := range []float32{0, 0, 0, 1, 0, 0, 0, 1, 0} {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(v))
+	}
+	valid := `{
+  "scene": 0,
+  "scenes": [{"nodes": [0]}],
+  "nodes": [{"mesh": 0}],
+  "meshes": [{"primitives": [{"attributes": {"POSITION": 0}}]}],
+  "accessors": [{"bufferView": 0, "componentType": 5126, "count": 3, "type": "VEC3"}],
+  "bufferViews": [{"buffer": 0, "byteLength": 36}],
+  "buffers": [{"uri": "data:application/octet-stream;base64,` + base64.StdEncoding.EncodeToString(data) + `", "byteLength": 36}]
+}`
+	tests := []struct {
+		name, old, new, want string
+	}{
+		{"valid.gltf", "", "", ""},
+		{"scene.gltf", `"scene": 0`, `"scene": 1`, "scene 1 out of range"},
+		{"node.gltf", `"nodes": [0]`, `"nodes": [-1]`, "node -1 out of range"},
+		{"mesh.gltf", `"mesh": 0`, `"mesh": 2`, "node 0: mesh 2 out of range"},
+		{"accessor.gltf", `"POSITION": 0`, `"POSITION": 5`, "accessor 5 out of range"},
+		{"view.gltf", `"bufferView": 0`, `"bufferView": 3`, "accessor 0: buffer view 3 out of range"},
+		{"buffer.gltf", `"buffer": 0`, `"buffer": -2`, "buffer view 0: buffer -2 out of range"},
+		{"count.gltf", `"count": 3`, `"count": -3`, "accessor 0 has a negative count, offset or stride"},
+		{"huge.gltf", `"count": 3`, `"count": 1000000000000`, "accessor 0 runs past its buffer"},
+	}
+	for _, test := range tests {
+		dir := writeScene(t, map[string]string{test.name: strings.Replace(valid, test.old, test.new, 1)})
+		path := filepath.Join(dir, test.name)
+		meshes, err := LoadGLTF(path, nil)
+		if test.want == "" {
+			if err != nil || len(meshes) != 1 || len(meshes[0].faces) != 1 {
+				t.Errorf("%s: got %d meshes (%v), want one triangle", test.name, len(meshes), err)
+			}
+			continue
+		}
+		if want := path + ": " + test.want; err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %s", test.name, err, want)
+		}
+	}
+}
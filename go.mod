@@ -1,9 +1,8 @@
 module graphics-from-scratch
 
-go 1.16
+go 1.17
 
 require (
-	github.com/fogleman/gg v1.3.0 // indirect
-	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
-	golang.org/x/image v0.0.0-20210504121937-7319ad40d33e // indirect
+	github.com/yuin/gopher-lua v1.1.1
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,165 @@
+package main
+
+import "fmt"
+
+// gpuRender, if set by a build with a GPU compute backend (gpu_opencl.go,
+// built with -tags opencl), renders the whole canvas on the GPU after
+// uploading the scene, returning an error if no device is available or the
+// scene uses features its kernels lack.
+var gpuRender func(scene *Scene, camera *Camera, canvas *Canvas, max_recursion_depth int) error
+
+// A gpuScene is a scene packed into arrays of float32 for upload. Each
+// array holds records of a fixed size, laid out as the kernel reads them:
+//
+//	objects:   kind, material, three points, normal, radius, unused
+//	materials: color, specular, reflective, emission
+//	lights:    kind, intensity, position or direction, unused
+//	params:    camera position, right, up and forward, Vw, Vh, d, shift,
+//	           background, epsilon, relative_epsilon
+type gpuScene struct {
+	objects   []float32
+	materials []float32
+	lights    []float32
+	params    []float32
+}
+
+const (
+	gpuObjectSize   = 16
+	gpuMaterialSize = 8
+	gpuLightSize    = 8
+	gpuParamsSize   = 24
+)
+
+// Kinds of objects and lights in a gpuScene.
+const (
+	gpuSphere   = 0
+	gpuPlane    = 1
+	gpuTriangle = 2
+
+	gpuAmbient     = 0
+	gpuPoint       = 1
+	gpuDirectional = 2
+)
+
+// packGPUScene flattens the scene and camera for the GPU kernels, which
+// trace Whitted-style rays with one sample per pixel through spheres,
+// planes and triangles in plain Phong materials, lit by ambient, point and
+// directional lights. It returns an error naming the first feature of the
+// scene they lack, for Render to fall back to the CPU tracer.
+func packGPUScene(scene *Scene, camera *Camera) (gpuScene, error) {
+	var g gpuScene
+	switch {
+	case scene.integrator != "" && scene.integrator != "whitted":
+		return g, fmt.Errorf("the %s integrator is not supported", scene.integrator)
+	case scene.path_samples > 0 || scene.gi_samples > 0 || scene.photons != nil:
+		return g, fmt.Errorf("indirect light is not supported")
+	case scene.samples > 1 || scene.max_samples > 1:
+		return g, fmt.Errorf("more than one sample per pixel is not supported")
+	case scene.environment != nil || scene.alpha || scene.max_radiance > 0:
+		return g, fmt.Errorf("environments, alpha and clamping are not supported")
+	case camera.projection != "perspective" || camera.aperture > 0 || camera.shutter > 0:
+		return g, fmt.Errorf("only pinhole perspective cameras are supported")
+	}
+
+	material_ids := map[*Material]int{}
+	material := func(m *Material) (float32, error) {
+		if id, ok := material_ids[m]; ok {
+			return float32(id), nil
+		}
+		if m.model == "pbr" || m.texture != nil || m.normal_map != nil || m.bump != nil || m.transparency > 0 || m.roughness > 0 || m.tinted {
+			return 0, fmt.Errorf("only plain Phong materials are supported")
+		}
+		id := len(material_ids)
+		material_ids[m] = id
+		g.materials = append(g.materials, float32(m.color.r), float32(m.color.g), float32(m.color.b), float32(m.specular), float32(m.reflective), float32(m.emission.r), float32(m.emission.g), float32(m.emission.b))
+		return float32(id), nil
+	}
+	var pack func(objects []Object) error
+	pack = func(objects []Object) error {
+		for _, object := range objects {
+			record := make([]float32, gpuObjectSize)
+			var m *Material
+			switch o := object.(type) {
+			case *Sphere:
+				record[0], m = gpuSphere, o.material
+				packVector(record[2:], o.center)
+				record[14] = float32(o.radius)
+			case *Plane:
+				record[0], m = gpuPlane, o.material
+				packVector(record[2:], o.point)
+				packVector(record[11:], o.normal)
+			case *Triangle:
+				record[0], m = gpuTriangle, o.material
+				packVector(record[2:], o.v0)
+				packVector(record[5:], o.v1)
+				packVector(record[8:], o.v2)
+				packVector(record[11:], o.normal)
+			case *MeshFace:
+				face := o.mesh.faces[o.index]
+				record[0], m = gpuTriangle, o.mesh.material
+				packVector(record[2:], o.mesh.vertices[face[0]])
+				packVector(record[5:], o.mesh.vertices[face[1]])
+				packVector(record[8:], o.mesh.vertices[face[2]])
+				packVector(record[11:], o.mesh.normals[o.index])
+			case *Group:
+				if err := pack(o.objects); err != nil {
+					return err
+				}
+				continue
+			case *BVH:
+				if err := pack(append(o.objects, o.unbounded...)); err != nil {
+					return err
+				}
+				continue
+			default:
+				return fmt.Errorf("%T objects are not supported", object)
+			}
+			id, err := material(m)
+			if err != nil {
+				return err
+			}
+			record[1] = id
+			g.objects = append(g.objects, record...)
+		}
+		return nil
+	}
+	if err := pack(scene.objects); err != nil {
+		return g, err
+	}
+
+	for _, light := range scene.lights {
+		record := make([]float32, gpuLightSize)
+		switch light.kind {
+		case AmbientLight:
+			record[0] = gpuAmbient
+		case PointLight:
+			record[0] = gpuPoint
+			packVector(record[4:], light.position)
+		case DirectionalLight:
+			if light.angular_diameter > 0 {
+				return g, fmt.Errorf("sun lights are not supported")
+			}
+			record[0] = gpuDirectional
+			packVector(record[4:], light.direction)
+		default:
+			return g, fmt.Errorf("%v lights are not supported", light.kind)
+		}
+		packVector(record[1:], Vector{light.intensity.r, light.intensity.g, light.intensity.b})
+		g.lights = append(g.lights, record...)
+	}
+
+	g.params = make([]float32, gpuParamsSize)
+	packVector(g.params[0:], camera.position)
+	packVector(g.params[3:], camera.right)
+	packVector(g.params[6:], camera.up)
+	packVector(g.params[9:], camera.forward)
+	g.params[12], g.params[13], g.params[14] = float32(Vw), float32(Vh), float32(d)
+	g.params[15], g.params[16] = float32(camera.shift_x), float32(camera.shift_y)
+	packVector(g.params[17:], Vector{scene.background.r, scene.background.g, scene.background.b})
+	g.params[20], g.params[21] = float32(scene.epsilon), float32(scene.relative_epsilon)
+	return g, nil
+}
+
+func packVector(to []float32, v Vector) {
+	to[0], to[1], to[2] = float32(v.x), float32(v.y), float32(v.z)
+}
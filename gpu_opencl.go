@@ -0,0 +1,318 @@
+//go:build opencl
+// +build opencl
+
+package main
+
+/*
+#cgo !darwin LDFLAGS: -lOpenCL
+#cgo darwin LDFLAGS: -framework OpenCL
+#define CL_TARGET_OPENCL_VERSION 120
+#ifdef __APPLE__
+#include <OpenCL/opencl.h>
+#else
+#include <CL/cl.h>
+#endif
+#include <stdlib.h>
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+func init() {
+	gpuRender = renderOpenCL
+}
+
+// gpuKernel traces one pixel of a packed gpuScene per work item, as
+// ShadeHit does for the features packGPUScene accepts. Reflections are
+// followed in a loop, carrying the weight left for the rest of the path,
+// as kernels cannot recurse.
+const gpuKernel = `
+typedef struct { float x, y, z; } vec;
+
+vec make(float x, float y, float z) { vec v; v.x = x; v.y = y; v.z = z; return v; }
+vec load(__global const float *p) { return make(p[0], p[1], p[2]); }
+vec vadd(vec a, vec b) { return make(a.x + b.x, a.y + b.y, a.z + b.z); }
+vec vsub(vec a, vec b) { return make(a.x - b.x, a.y - b.y, a.z - b.z); }
+vec vmul(vec a, vec b) { return make(a.x * b.x, a.y * b.y, a.z * b.z); }
+vec vscale(vec a, float k) { return make(a.x * k, a.y * k, a.z * k); }
+float vdot(vec a, vec b) { return a.x * b.x + a.y * b.y + a.z * b.z; }
+vec vcross(vec a, vec b) { return make(a.y * b.z - a.z * b.y, a.z * b.x - a.x * b.z, a.x * b.y - a.y * b.x); }
+vec vnormalize(vec a) { return vscale(a, 1.0f / sqrt(vdot(a, a))); }
+vec reflect(vec ray, vec normal) { return vsub(vscale(normal, 2.0f * vdot(normal, ray)), ray); }
+
+float nearest(float t, float t_min, float t_max) {
+	return t_min <= t && t <= t_max ? t : INFINITY;
+}
+
+// intersect returns the t of the object's nearest hit in [t_min, t_max],
+// or INFINITY.
+float intersect(__global const float *o, vec origin, vec direction, float t_min, float t_max) {
+	int kind = (int)o[0];
+	if (kind == 0) { // sphere
+		vec co = vsub(origin, load(o + 2));
+		float a = vdot(direction, direction);
+		float half_b = vdot(co, direction);
+		float c = vdot(co, co) - o[14] * o[14];
+		float discrim = half_b * half_b - a * c;
+		if (discrim < 0.0f) {
+			return INFINITY;
+		}
+		float root = sqrt(discrim);
+		float t = nearest((-half_b - root) / a, t_min, t_max);
+		return t < INFINITY ? t : nearest((-half_b + root) / a, t_min, t_max);
+	}
+	if (kind == 1) { // plane
+		vec normal = load(o + 11);
+		float denom = vdot(normal, direction);
+		if (fabs(denom) < 1e-9f) {
+			return INFINITY;
+		}
+		return nearest(vdot(vsub(load(o + 2), origin), normal) / denom, t_min, t_max);
+	}
+	// triangle, by Moller-Trumbore
+	vec v0 = load(o + 2);
+	vec e1 = vsub(load(o + 5), v0);
+	vec e2 = vsub(load(o + 8), v0);
+	vec p = vcross(direction, e2);
+	float det = vdot(e1, p);
+	if (fabs(det) < 1e-9f) {
+		return INFINITY;
+	}
+	float inv_det = 1.0f / det;
+	vec s = vsub(origin, v0);
+	float u = vdot(s, p) * inv_det;
+	if (u < 0.0f || u > 1.0f) {
+		return INFINITY;
+	}
+	vec q = vcross(s, e1);
+	float v = vdot(direction, q) * inv_det;
+	if (v < 0.0f || u + v > 1.0f) {
+		return INFINITY;
+	}
+	return nearest(vdot(e2, q) * inv_det, t_min, t_max);
+}
+
+// closest returns the t of the nearest hit of any object, setting hit to
+// its index, or to -1 if the ray escapes.
+float closest(__global const float *objects, int object_count, vec origin, vec direction, float t_min, float t_max, int *hit) {
+	*hit = -1;
+	for (int i = 0; i < object_count; i++) {
+		float t = intersect(objects + 16 * i, origin, direction, t_min, t_max);
+		if (t < t_max) {
+			t_max = t;
+			*hit = i;
+		}
+	}
+	return t_max;
+}
+
+__kernel void render(__global const float *objects, int object_count, __global const float *materials, __global const float *lights, int light_count, __global const float *params, int width, int height, int depth, __global float *pixels) {
+	int i = get_global_id(0);
+	if (i >= width * height) {
+		return;
+	}
+	float x = (float)(i % width - width / 2);
+	float y = (float)(height / 2 - i / width);
+	float vw = params[12], vh = params[13];
+	vec origin = load(params);
+	vec direction = vadd(vadd(vscale(load(params + 3), x * vw / width + params[15] * vw), vscale(load(params + 6), y * vh / height + params[16] * vh)), vscale(load(params + 9), params[14]));
+	vec background = load(params + 17);
+	float epsilon = params[20], relative_epsilon = params[21];
+
+	vec color = make(0.0f, 0.0f, 0.0f);
+	float weight = 1.0f;
+	float t_min = 1.0f;
+	for (int bounce = depth; ; bounce--) {
+		int hit;
+		float t = closest(objects, object_count, origin, direction, t_min, INFINITY, &hit);
+		if (hit < 0) {
+			color = vadd(color, vscale(background, weight));
+			break;
+		}
+		__global const float *o = objects + 16 * hit;
+		__global const float *m = materials + 8 * (int)o[1];
+		vec point = vadd(origin, vscale(direction, t));
+		vec normal = (int)o[0] == 0 ? vnormalize(vsub(point, load(o + 2))) : load(o + 11);
+		if (vdot(normal, direction) > 0.0f) {
+			normal = vscale(normal, -1.0f);
+		}
+		float bias = epsilon + relative_epsilon * fmax(fabs(point.x), fmax(fabs(point.y), fabs(point.z)));
+		vec view = vnormalize(vscale(direction, -1.0f));
+
+		vec intensity = make(0.0f, 0.0f, 0.0f);
+		vec highlight = make(0.0f, 0.0f, 0.0f);
+		for (int j = 0; j < light_count; j++) {
+			__global const float *l = lights + 8 * j;
+			vec strength = load(l + 1);
+			if ((int)l[0] == 0) {
+				intensity = vadd(intensity, strength);
+				continue;
+			}
+			vec L = (int)l[0] == 1 ? vsub(load(l + 4), point) : load(l + 4);
+			float t_max = (int)l[0] == 1 ? 1.0f : INFINITY;
+			int shadow;
+			closest(objects, object_count, point, L, bias / sqrt(vdot(L, L)), t_max, &shadow);
+			if (shadow >= 0) {
+				continue;
+			}
+			L = vnormalize(L);
+			intensity = vadd(intensity, vscale(strength, fmax(0.0f, vdot(normal, L))));
+			if (m[3] != -1.0f) {
+				vec R = vnormalize(reflect(L, normal));
+				highlight = vadd(highlight, vscale(strength, pow(fmax(0.0f, vdot(R, view)), m[3])));
+			}
+		}
+		vec local = vadd(vmul(load(m), vadd(intensity, highlight)), load(m + 5));
+
+		float reflective = m[4];
+		if (bounce <= 0 || reflective <= 0.0f) {
+			color = vadd(color, vscale(local, weight));
+			break;
+		}
+		float r = reflective + (1.0f - reflective) * pow(fmax(0.0f, 1.0f - vdot(normal, view)), 5.0f);
+		color = vadd(color, vscale(local, weight * (1.0f - r)));
+		weight *= r;
+		origin = point;
+		direction = reflect(view, normal);
+		t_min = bias;
+	}
+	pixels[4 * i] = color.x;
+	pixels[4 * i + 1] = color.y;
+	pixels[4 * i + 2] = color.z;
+	pixels[4 * i + 3] = 1.0f;
+}
+`
+
+func clError(call string, status C.cl_int) error {
+	return fmt.Errorf("%s failed with OpenCL error %d", call, int(status))
+}
+
+// renderOpenCL is gpuRender for the first OpenCL GPU device found.
+func renderOpenCL(scene *Scene, camera *Camera, canvas *Canvas, max_recursion_depth int) error {
+	packed, err := packGPUScene(scene, camera)
+	if err != nil {
+		return err
+	}
+	device, err := openCLDevice()
+	if err != nil {
+		return err
+	}
+
+	var status C.cl_int
+	context := C.clCreateContext(nil, 1, &device, nil, nil, &status)
+	if status != C.CL_SUCCESS {
+		return clError("clCreateContext", status)
+	}
+	defer C.clReleaseContext(context)
+	queue := C.clCreateCommandQueue(context, device, 0, &status)
+	if status != C.CL_SUCCESS {
+		return clError("clCreateCommandQueue", status)
+	}
+	defer C.clReleaseCommandQueue(queue)
+
+	source := C.CString(gpuKernel)
+	defer C.free(unsafe.Pointer(source))
+	program := C.clCreateProgramWithSource(context, 1, &source, nil, &status)
+	if status != C.CL_SUCCESS {
+		return clError("clCreateProgramWithSource", status)
+	}
+	defer C.clReleaseProgram(program)
+	if status = C.clBuildProgram(program, 1, &device, nil, nil, nil); status != C.CL_SUCCESS {
+		var size C.size_t
+		C.clGetProgramBuildInfo(program, device, C.CL_PROGRAM_BUILD_LOG, 0, nil, &size)
+		build_log := make([]byte, size+1)
+		C.clGetProgramBuildInfo(program, device, C.CL_PROGRAM_BUILD_LOG, size, unsafe.Pointer(&build_log[0]), nil)
+		return fmt.Errorf("building the kernel: %s", C.GoString((*C.char)(unsafe.Pointer(&build_log[0]))))
+	}
+	name := C.CString("render")
+	defer C.free(unsafe.Pointer(name))
+	kernel := C.clCreateKernel(program, name, &status)
+	if status != C.CL_SUCCESS {
+		return clError("clCreateKernel", status)
+	}
+	defer C.clReleaseKernel(kernel)
+
+	// Buffers cannot be empty, so scenes without objects or lights upload a
+	// record of zeros that the counts leave unread.
+	upload := func(data []float32, record int) (C.cl_mem, error) {
+		if len(data) == 0 {
+			data = make([]float32, record)
+		}
+		mem := C.clCreateBuffer(context, C.CL_MEM_READ_ONLY|C.CL_MEM_COPY_HOST_PTR, C.size_t(4*len(data)), unsafe.Pointer(&data[0]), &status)
+		if status != C.CL_SUCCESS {
+			return nil, clError("clCreateBuffer", status)
+		}
+		return mem, nil
+	}
+	var buffers [4]C.cl_mem
+	for i, data := range [][]float32{packed.objects, packed.materials, packed.lights, packed.params} {
+		record := [...]int{gpuObjectSize, gpuMaterialSize, gpuLightSize, gpuParamsSize}[i]
+		if buffers[i], err = upload(data, record); err != nil {
+			return err
+		}
+		defer C.clReleaseMemObject(buffers[i])
+	}
+	pixels := make([]float32, 4*canvas.width*canvas.height)
+	output := C.clCreateBuffer(context, C.CL_MEM_WRITE_ONLY, C.size_t(4*len(pixels)), nil, &status)
+	if status != C.CL_SUCCESS {
+		return clError("clCreateBuffer", status)
+	}
+	defer C.clReleaseMemObject(output)
+
+	object_count := C.cl_int(len(packed.objects) / gpuObjectSize)
+	light_count := C.cl_int(len(packed.lights) / gpuLightSize)
+	width, height, depth := C.cl_int(canvas.width), C.cl_int(canvas.height), C.cl_int(max_recursion_depth)
+	args := []struct {
+		size  uintptr
+		value unsafe.Pointer
+	}{
+		{unsafe.Sizeof(buffers[0]), unsafe.Pointer(&buffers[0])},
+		{unsafe.Sizeof(object_count), unsafe.Pointer(&object_count)},
+		{unsafe.Sizeof(buffers[1]), unsafe.Pointer(&buffers[1])},
+		{unsafe.Sizeof(buffers[2]), unsafe.Pointer(&buffers[2])},
+		{unsafe.Sizeof(light_count), unsafe.Pointer(&light_count)},
+		{unsafe.Sizeof(buffers[3]), unsafe.Pointer(&buffers[3])},
+		{unsafe.Sizeof(width), unsafe.Pointer(&width)},
+		{unsafe.Sizeof(height), unsafe.Pointer(&height)},
+		{unsafe.Sizeof(depth), unsafe.Pointer(&depth)},
+		{unsafe.Sizeof(output), unsafe.Pointer(&output)},
+	}
+	for i, arg := range args {
+		if status = C.clSetKernelArg(kernel, C.cl_uint(i), C.size_t(arg.size), arg.value); status != C.CL_SUCCESS {
+			return clError("clSetKernelArg", status)
+		}
+	}
+	work := C.size_t(canvas.width * canvas.height)
+	if status = C.clEnqueueNDRangeKernel(queue, kernel, 1, nil, &work, nil, 0, nil, nil); status != C.CL_SUCCESS {
+		return clError("clEnqueueNDRangeKernel", status)
+	}
+	if status = C.clEnqueueReadBuffer(queue, output, C.CL_TRUE, 0, C.size_t(4*len(pixels)), unsafe.Pointer(&pixels[0]), 0, nil, nil); status != C.CL_SUCCESS {
+		return clError("clEnqueueReadBuffer", status)
+	}
+
+	for i := range canvas.radiance {
+		canvas.radiance[i] = Color{float64(pixels[4*i]), float64(pixels[4*i+1]), float64(pixels[4*i+2])}
+		canvas.alpha[i] = float64(pixels[4*i+3])
+	}
+	return nil
+}
+
+// openCLDevice returns the first GPU of any OpenCL platform.
+func openCLDevice() (C.cl_device_id, error) {
+	var count C.cl_uint
+	if status := C.clGetPlatformIDs(0, nil, &count); status != C.CL_SUCCESS || count == 0 {
+		return nil, fmt.Errorf("no OpenCL platform")
+	}
+	platforms := make([]C.cl_platform_id, count)
+	C.clGetPlatformIDs(count, &platforms[0], nil)
+	for _, platform := range platforms {
+		var device C.cl_device_id
+		if C.clGetDeviceIDs(platform, C.CL_DEVICE_TYPE_GPU, 1, &device, nil) == C.CL_SUCCESS {
+			return device, nil
+		}
+	}
+	return nil, fmt.Errorf("no OpenCL GPU device")
+}
@@ -0,0 +1,178 @@
+package main
+
+import "math"
+
+// gridDensity is the target number of cells per object, and gridMaxCells
+// caps the resolution along any one axis.
+const (
+	gridDensity  = 3
+	gridMaxCells = 128
+)
+
+// Grid is a uniform grid of cells listing the objects that overlap them.
+// It builds in a single pass over the objects, which suits scenes rebuilt
+// every frame, and is traversed cell by cell with a 3D-DDA. Objects with no
+// finite bounds are tested on every ray.
+type Grid struct {
+	bounds     AABB
+	resolution [3]int
+	cell_size  Vector
+	cells      [][]int
+	objects    []Object
+	unbounded  []Object
+}
+
+func MakeGrid(objects []Object) Grid {
+	g := Grid{bounds: EmptyAABB()}
+	var bounds []AABB
+	for _, object := range objects {
+		box := boundsOf(object)
+		if box.Finite() {
+			g.objects = append(g.objects, object)
+			bounds = append(bounds, box)
+			g.bounds = g.bounds.Union(box)
+		} else {
+			g.unbounded = append(g.unbounded, object)
+		}
+	}
+	if len(g.objects) == 0 {
+		return g
+	}
+
+	// Size cells to be roughly cubic with gridDensity objects per cell.
+	extent := sub(g.bounds.max, g.bounds.min)
+	volume := math.Max(extent.x, 1e-9) * math.Max(extent.y, 1e-9) * math.Max(extent.z, 1e-9)
+	per_unit := math.Cbrt(gridDensity * float64(len(g.objects)) / volume)
+	for axis := 0; axis < 3; axis++ {
+		r := int(math.Round(component(extent, axis) * per_unit))
+		if r < 1 {
+			r = 1
+		} else if r > gridMaxCells {
+			r = gridMaxCells
+		}
+		g.resolution[axis] = r
+	}
+	g.cell_size = MakeVector(
+		extent.x/float64(g.resolution[0]),
+		extent.y/float64(g.resolution[1]),
+		extent.z/float64(g.resolution[2]),
+	)
+
+	g.cells = make([][]int, g.resolution[0]*g.resolution[1]*g.resolution[2])
+	for i, box := range bounds {
+		lo, hi := g.cellOf(box.min), g.cellOf(box.max)
+		for z := lo[2]; z <= hi[2]; z++ {
+			for y := lo[1]; y <= hi[1]; y++ {
+				for x := lo[0]; x <= hi[0]; x++ {
+					c := g.cellIndex([3]int{x, y, z})
+					g.cells[c] = append(g.cells[c], i)
+				}
+			}
+		}
+	}
+	return g
+}
+
+// cellOf returns the coordinates of the cell containing p, clamped to the
+// grid.
+func (g *Grid) cellOf(p Vector) [3]int {
+	var cell [3]int
+	for axis := 0; axis < 3; axis++ {
+		size := component(g.cell_size, axis)
+		c := 0
+		if size > 0 {
+			c = int((component(p, axis) - component(g.bounds.min, axis)) / size)
+		}
+		if c < 0 {
+			c = 0
+		} else if c >= g.resolution[axis] {
+			c = g.resolution[axis] - 1
+		}
+		cell[axis] = c
+	}
+	return cell
+}
+
+func (g *Grid) cellIndex(cell [3]int) int {
+	return (cell[2]*g.resolution[1]+cell[1])*g.resolution[0] + cell[0]
+}
+
+// traverse walks the cells along the ray in order and stops once the
+// closest hit so far lies within the cell just visited.
+func (g *Grid) traverse(origin Vector, direction Vector, t_min float64, t_max float64, visit func(Object, float64) float64) float64 {
+	for _, object := range g.unbounded {
+		t_max = visit(object, t_max)
+	}
+	if len(g.objects) == 0 {
+		return t_max
+	}
+	inv_dir := MakeVector(1/direction.x, 1/direction.y, 1/direction.z)
+	t0, t1, ok := g.bounds.Clip(origin, inv_dir, t_min, t_max)
+	if !ok {
+		return t_max
+	}
+
+	// Set up the 3D-DDA from the point where the ray enters the grid.
+	cell := g.cellOf(add(origin, scale(direction, t0)))
+	var step, stop [3]int
+	var t_next, t_delta [3]float64
+	for axis := 0; axis < 3; axis++ {
+		d := component(direction, axis)
+		size := component(g.cell_size, axis)
+		lo := component(g.bounds.min, axis)
+		o := component(origin, axis)
+		switch {
+		case d > 0:
+			step[axis], stop[axis] = 1, g.resolution[axis]
+			t_next[axis] = (lo + float64(cell[axis]+1)*size - o) / d
+			t_delta[axis] = size / d
+		case d < 0:
+			step[axis], stop[axis] = -1, -1
+			t_next[axis] = (lo + float64(cell[axis])*size - o) / d
+			t_delta[axis] = -size / d
+		default:
+			step[axis], stop[axis] = 0, -1
+			t_next[axis] = math.Inf(1)
+		}
+	}
+
+	// Objects are visited once per cell they overlap; the repeats are
+	// cheaper than tracking which have been tested.
+	for {
+		for _, i := range g.cells[g.cellIndex(cell)] {
+			t_max = visit(g.objects[i], t_max)
+		}
+		axis := 0
+		if t_next[1] < t_next[axis] {
+			axis = 1
+		}
+		if t_next[2] < t_next[axis] {
+			axis = 2
+		}
+		exit := t_next[axis]
+		if t_max <= exit || exit > t1 {
+			break
+		}
+		cell[axis] += step[axis]
+		if cell[axis] == stop[axis] {
+			break
+		}
+		t_next[axis] += t_delta[axis]
+	}
+	return t_max
+}
+
+func (g *Grid) Hit(origin Vector, direction Vector, t_min float64, t_max float64) (Primitive, float64) {
+	return hitWith(g.traverse, origin, direction, t_min, t_max)
+}
+
+func (g *Grid) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	return intersectWith(g.traverse, origin, direction, t_min, t_max)
+}
+
+func (g *Grid) Bounds() AABB {
+	if len(g.unbounded) > 0 {
+		return InfiniteAABB()
+	}
+	return g.bounds
+}
@@ -0,0 +1,60 @@
+package main
+
+// Instance places a copy of shared geometry in the scene with its own
+// transform, and its own surface where the geometry has none. Many instances can reference one mesh without
+// duplicating its vertices or intersection structures; passing the mesh's
+// BVH as the geometry makes the scene accelerator a two-level hierarchy.
+type Instance struct {
+	Transformed
+	material *Material
+}
+
+func MakeInstance(geometry Object, to_world Matrix4, material *Material) Instance {
+	var i Instance
+	i.Transformed = MakeTransformed(geometry, to_world)
+	i.material = material
+	return i
+}
+
+func (i *Instance) Hit(origin Vector, direction Vector, t_min float64, t_max float64) (Primitive, float64) {
+	p, t := i.Transformed.Hit(origin, direction, t_min, t_max)
+	if p == nil {
+		return nil, 0
+	}
+	return &instancePrimitive{p, i}, t
+}
+
+func (i *Instance) Spans(origin Vector, direction Vector) []Span {
+	spans := i.Transformed.Spans(origin, direction)
+	for n := range spans {
+		spans[n].in.surface = &instancePrimitive{spans[n].in.surface, i}
+		spans[n].out.surface = &instancePrimitive{spans[n].out.surface, i}
+	}
+	return spans
+}
+
+// instancePrimitive shades a hit on shared geometry with the instance's
+// own surface, unless the geometry's has one.
+type instancePrimitive struct {
+	Primitive
+	instance *Instance
+}
+
+func (p *instancePrimitive) Material() *Material {
+	if m := p.Primitive.Material(); m != nil {
+		return m
+	}
+	return p.instance.material
+}
+
+func (p *instancePrimitive) UVAt(point Vector) (float64, float64) {
+	return uvAt(p.Primitive, point)
+}
+
+// MoveInstance places instance anew and rebuilds only the top level of the
+// scene's accelerator. The structures inside instances, such as the BVH of
+// a mesh they share, are kept as they are.
+func (s *Scene) MoveInstance(instance *Instance, to_world Matrix4) {
+	instance.SetTransform(to_world)
+	s.BuildAccelerator()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMoveInstance(t *testing.T) {
+	gray := MakeMaterial(MakeColor(0.5, 0.5, 0.5), -1, 0)
+	mesh := MakeMesh([]Vector{MakeVector(0, 0, 0), MakeVector(1, 0, 0), MakeVector(0, 1, 0), MakeVector(0, 0, 1)},
+		[][3]int{{0, 1, 2}, {0, 2, 3}, {0, 3, 1}, {1, 3, 2}}, &gray)
+	bottom := mesh.BVH()
+	nodes := &bottom.nodes[0]
+	near := MakeInstance(bottom, Translate(MakeVector(0, 0, 2)), nil)
+	far := MakeInstance(bottom, Translate(MakeVector(0, 0, 5)), nil)
+	scene := &Scene{objects: []Object{&near, &far}, accelerator: "bvh", rays: new(int64)}
+	scene.BuildAccelerator()
+	if top := scene.accel.(*BVH); len(top.objects) != 2 {
+		t.Fatalf("top level holds %d objects, want the two instances", len(top.objects))
+	}
+
+	origin, direction := MakeVector(0.2, 0.2, -1), MakeVector(0, 0, 1)
+	if _, hit_t := ClosestIntersection(scene, origin, direction, 0, math.Inf(1)); hit_t != 3 {
+		t.Fatalf("hit at t = %v, want the near instance at 3", hit_t)
+	}
+	scene.MoveInstance(&near, Translate(MakeVector(0, 0, 8)))
+	if _, hit_t := ClosestIntersection(scene, origin, direction, 0, math.Inf(1)); hit_t != 6 {
+		t.Errorf("after moving the near instance away, hit at t = %v, want the far one at 6", hit_t)
+	}
+	if mesh.BVH() != bottom || &bottom.nodes[0] != nodes || near.object != bottom || far.object != bottom {
+		t.Error("moving an instance rebuilt the mesh's BVH")
+	}
+}
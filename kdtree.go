@@ -0,0 +1,235 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Costs for the surface area heuristic, relative to one traversal step.
+const (
+	kdTraversalCost = 1
+	kdIntersectCost = 80
+	kdEmptyBonus    = 0.5
+	kdLeafSize      = 2
+)
+
+type kdNode struct {
+	// Interior nodes split at split along axis, store their above child
+	// index in offset and have their below child directly after them.
+	// Leaves have axis 3 and hold indices[offset : offset+count].
+	axis   int
+	split  float64
+	offset int
+	count  int
+}
+
+// KDTree partitions space with axis-aligned planes chosen by the surface
+// area heuristic. Unlike the BVH an object may be referenced from several
+// leaves. Objects with no finite bounds are tested on every ray.
+type KDTree struct {
+	nodes     []kdNode
+	objects   []Object
+	indices   []int
+	bounds    AABB
+	unbounded []Object
+}
+
+type kdEdge struct {
+	t     float64
+	start bool
+}
+
+func MakeKDTree(objects []Object) KDTree {
+	k := KDTree{bounds: EmptyAABB()}
+	var bounds []AABB
+	var indices []int
+	for _, object := range objects {
+		box := boundsOf(object)
+		if box.Finite() {
+			indices = append(indices, len(k.objects))
+			k.objects = append(k.objects, object)
+			bounds = append(bounds, box)
+			k.bounds = k.bounds.Union(box)
+		} else {
+			k.unbounded = append(k.unbounded, object)
+		}
+	}
+	if len(k.objects) > 0 {
+		max_depth := int(8 + 1.3*math.Log2(float64(len(k.objects))))
+		k.build(bounds, k.bounds, indices, max_depth, 0)
+	}
+	return k
+}
+
+func setComponent(v *Vector, axis int, value float64) {
+	switch axis {
+	case 0:
+		v.x = value
+	case 1:
+		v.y = value
+	default:
+		v.z = value
+	}
+}
+
+// build appends the subtree for the given objects inside box and returns
+// its index.
+func (k *KDTree) build(bounds []AABB, box AABB, indices []int, depth int, bad_refines int) int {
+	index := len(k.nodes)
+	k.nodes = append(k.nodes, kdNode{})
+	n := len(indices)
+	if n <= kdLeafSize || depth == 0 {
+		k.leaf(index, indices)
+		return index
+	}
+
+	// Sweep the box edges along each axis for the cheapest split.
+	leaf_cost := float64(kdIntersectCost * n)
+	best_cost, best_axis, best_split := math.Inf(1), -1, 0.0
+	inv_area := 1 / box.SurfaceArea()
+	extent := sub(box.max, box.min)
+	edges := make([]kdEdge, 0, 2*n)
+	for axis := 0; axis < 3; axis++ {
+		lo, hi := component(box.min, axis), component(box.max, axis)
+		e1, e2 := component(extent, (axis+1)%3), component(extent, (axis+2)%3)
+		edges = edges[:0]
+		for _, i := range indices {
+			edges = append(edges, kdEdge{component(bounds[i].min, axis), true}, kdEdge{component(bounds[i].max, axis), false})
+		}
+		sort.Slice(edges, func(i, j int) bool {
+			if edges[i].t == edges[j].t {
+				return edges[i].start && !edges[j].start
+			}
+			return edges[i].t < edges[j].t
+		})
+		below, above := 0, n
+		for _, edge := range edges {
+			if !edge.start {
+				above--
+			}
+			if edge.t > lo && edge.t < hi {
+				below_area := 2 * (e1*e2 + (edge.t-lo)*(e1+e2))
+				above_area := 2 * (e1*e2 + (hi-edge.t)*(e1+e2))
+				bonus := 0.0
+				if below == 0 || above == 0 {
+					bonus = kdEmptyBonus
+				}
+				cost := kdTraversalCost + kdIntersectCost*(1-bonus)*
+					(below_area*inv_area*float64(below)+above_area*inv_area*float64(above))
+				if cost < best_cost {
+					best_cost, best_axis, best_split = cost, axis, edge.t
+				}
+			}
+			if edge.start {
+				below++
+			}
+		}
+	}
+
+	if best_cost > leaf_cost {
+		bad_refines++
+	}
+	if best_axis == -1 || bad_refines == 3 || (best_cost > 4*leaf_cost && n < 16) {
+		k.leaf(index, indices)
+		return index
+	}
+
+	// Objects lying flat in the split plane go to both sides.
+	var below_indices, above_indices []int
+	for _, i := range indices {
+		lo, hi := component(bounds[i].min, best_axis), component(bounds[i].max, best_axis)
+		if lo < best_split || hi <= best_split {
+			below_indices = append(below_indices, i)
+		}
+		if hi > best_split || lo >= best_split {
+			above_indices = append(above_indices, i)
+		}
+	}
+	below_box, above_box := box, box
+	setComponent(&below_box.max, best_axis, best_split)
+	setComponent(&above_box.min, best_axis, best_split)
+	k.build(bounds, below_box, below_indices, depth-1, bad_refines)
+	above := k.build(bounds, above_box, above_indices, depth-1, bad_refines)
+	k.nodes[index] = kdNode{axis: best_axis, split: best_split, offset: above}
+	return index
+}
+
+func (k *KDTree) leaf(index int, indices []int) {
+	k.nodes[index] = kdNode{axis: 3, offset: len(k.indices), count: len(indices)}
+	k.indices = append(k.indices, indices...)
+}
+
+// traverse visits leaves front to back and stops once the closest hit so
+// far lies within the leaf just visited.
+func (k *KDTree) traverse(origin Vector, direction Vector, t_min float64, t_max float64, visit func(Object, float64) float64) float64 {
+	for _, object := range k.unbounded {
+		t_max = visit(object, t_max)
+	}
+	if len(k.nodes) == 0 {
+		return t_max
+	}
+	inv_dir := MakeVector(1/direction.x, 1/direction.y, 1/direction.z)
+	t0, t1, ok := k.bounds.Clip(origin, inv_dir, t_min, t_max)
+	if !ok {
+		return t_max
+	}
+
+	type entry struct {
+		index  int
+		t0, t1 float64
+	}
+	var stack [64]entry
+	top := 0
+	index := 0
+	for {
+		if t0 > t_max {
+			break
+		}
+		node := &k.nodes[index]
+		if node.axis != 3 {
+			o := component(origin, node.axis)
+			d := component(direction, node.axis)
+			near, far := index+1, node.offset
+			if o > node.split || (o == node.split && d > 0) {
+				near, far = far, near
+			}
+			t_split := (node.split - o) * component(inv_dir, node.axis)
+			if d == 0 || t_split > t1 || t_split <= 0 {
+				index = near
+			} else if t_split < t0 {
+				index = far
+			} else {
+				stack[top] = entry{far, t_split, t1}
+				top++
+				index = near
+				t1 = t_split
+			}
+			continue
+		}
+
+		for _, i := range k.indices[node.offset : node.offset+node.count] {
+			t_max = visit(k.objects[i], t_max)
+		}
+		if t_max <= t1 || top == 0 {
+			break
+		}
+		top--
+		index, t0, t1 = stack[top].index, stack[top].t0, stack[top].t1
+	}
+	return t_max
+}
+
+func (k *KDTree) Hit(origin Vector, direction Vector, t_min float64, t_max float64) (Primitive, float64) {
+	return hitWith(k.traverse, origin, direction, t_min, t_max)
+}
+
+func (k *KDTree) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	return intersectWith(k.traverse, origin, direction, t_min, t_max)
+}
+
+func (k *KDTree) Bounds() AABB {
+	if len(k.unbounded) > 0 {
+		return InfiniteAABB()
+	}
+	return k.bounds
+}
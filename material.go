@@ -0,0 +1,78 @@
+package main
+
+// Material describes how a surface responds to light. Objects refer to
+// their material by pointer, so many objects can share one.
+type Material struct {
+	color      Color
+	specular   float64 // shininess, or -1 for a matte surface
+	reflective float64 // reflectance at normal incidence
+	// transparency is the fraction of light refracted through the surface,
+	// bent by its index of refraction ior. dispersion spreads the index
+	// over wavelengths, splitting white light into colors; see IOR.
+	transparency float64
+	ior          float64
+	dispersion   float64
+	// texture, if set, replaces color with a color that varies across the
+	// surface.
+	texture Texture
+	// normal_map and bump, if set, perturb the shading normal; see
+	// ShadingNormal.
+	normal_map  Texture
+	bump        Texture
+	bump_height float64
+	// model selects the shading: "phong" (the default), with the fields
+	// above, or "pbr", lit by LightingPBR with color as the albedo.
+	model    string
+	metallic float64
+	// roughness blurs reflections under either model (see TraceGlossy)
+	// and widens the PBR highlight, and anisotropy in [-1, 1] stretches
+	// that highlight along or across the direction tangent_angle radians
+	// from the surface's u direction.
+	roughness     float64
+	anisotropy    float64
+	tangent_angle float64
+	// tint, if tinted, colors highlights and reflections, as metals do;
+	// otherwise highlights take the surface color and reflections are
+	// untouched. Under the PBR model it replaces the reflectance F0.
+	tint   Color
+	tinted bool
+	// emission is light the surface gives off by itself. It glows under
+	// any renderer and lights other objects when path tracing.
+	emission Color
+}
+
+func MakeMaterial(color Color, specular float64, reflective float64) Material {
+	var m Material
+	m.color = color
+	m.specular = specular
+	m.reflective = reflective
+	m.ior = 1
+	return m
+}
+
+// metalTints are the reflectances at normal incidence of common metals.
+var metalTints = map[string]Color{
+	"gold":     {1.00, 0.77, 0.34},
+	"copper":   {0.95, 0.64, 0.54},
+	"silver":   {0.97, 0.96, 0.91},
+	"aluminum": {0.91, 0.92, 0.92},
+	"iron":     {0.56, 0.57, 0.58},
+}
+
+// MakeMetal returns a polished metal whose highlights and reflections take
+// the color of its tint, over a dim diffuse base of the same color.
+func MakeMetal(tint Color) Material {
+	m := MakeMaterial(WeightColor(tint, 0.25), 200, 0.8)
+	m.tint = tint
+	m.tinted = true
+	return m
+}
+
+// ColorAt returns the color of the object's surface at a point on it.
+func (m *Material) ColorAt(object Primitive, point Vector) Color {
+	if m.texture == nil {
+		return m.color
+	}
+	u, v := uvAt(object, point)
+	return m.texture.ColorAt(u, v, point)
+}
@@ -0,0 +1,233 @@
+package main
+
+import "math"
+
+// Matrix4 is a row-major 4x4 affine transform acting on column vectors.
+type Matrix4 [4][4]float64
+
+func Identity() Matrix4 {
+	return Matrix4{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}, {0, 0, 0, 1}}
+}
+
+func Translate(offset Vector) Matrix4 {
+	m := Identity()
+	m[0][3], m[1][3], m[2][3] = offset.x, offset.y, offset.z
+	return m
+}
+
+func Scale(factors Vector) Matrix4 {
+	m := Identity()
+	m[0][0], m[1][1], m[2][2] = factors.x, factors.y, factors.z
+	return m
+}
+
+// Rotate returns a rotation of angle radians about axis, counter-clockwise
+// when looking down the axis towards the origin.
+func Rotate(axis Vector, angle float64) Matrix4 {
+	a := normalize(axis)
+	c, s := math.Cos(angle), math.Sin(angle)
+	t := 1 - c
+	return Matrix4{
+		{t*a.x*a.x + c, t*a.x*a.y - s*a.z, t*a.x*a.z + s*a.y, 0},
+		{t*a.x*a.y + s*a.z, t*a.y*a.y + c, t*a.y*a.z - s*a.x, 0},
+		{t*a.x*a.z - s*a.y, t*a.y*a.z + s*a.x, t*a.z*a.z + c, 0},
+		{0, 0, 0, 1},
+	}
+}
+
+func RotateX(angle float64) Matrix4 { return Rotate(MakeVector(1, 0, 0), angle) }
+func RotateY(angle float64) Matrix4 { return Rotate(MakeVector(0, 1, 0), angle) }
+func RotateZ(angle float64) Matrix4 { return Rotate(MakeVector(0, 0, 1), angle) }
+
+// RotateQuaternion returns the rotation described by the unit quaternion
+// x*i + y*j + z*k + w.
+func RotateQuaternion(x float64, y float64, z float64, w float64) Matrix4 {
+	return Matrix4{
+		{1 - 2*(y*y+z*z), 2 * (x*y - z*w), 2 * (x*z + y*w), 0},
+		{2 * (x*y + z*w), 1 - 2*(x*x+z*z), 2 * (y*z - x*w), 0},
+		{2 * (x*z - y*w), 2 * (y*z + x*w), 1 - 2*(x*x+y*y), 0},
+		{0, 0, 0, 1},
+	}
+}
+
+// Mul returns m*n, the transform that applies n first and then m.
+func (m Matrix4) Mul(n Matrix4) Matrix4 {
+	var r Matrix4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			for k := 0; k < 4; k++ {
+				r[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return r
+}
+
+// Then composes transforms in reading order: a.Then(b) applies a, then b.
+func (m Matrix4) Then(n Matrix4) Matrix4 {
+	return n.Mul(m)
+}
+
+func (m Matrix4) MulPoint(p Vector) Vector {
+	return MakeVector(
+		m[0][0]*p.x+m[0][1]*p.y+m[0][2]*p.z+m[0][3],
+		m[1][0]*p.x+m[1][1]*p.y+m[1][2]*p.z+m[1][3],
+		m[2][0]*p.x+m[2][1]*p.y+m[2][2]*p.z+m[2][3],
+	)
+}
+
+// MulDirection transforms a direction, ignoring translation.
+func (m Matrix4) MulDirection(d Vector) Vector {
+	return MakeVector(
+		m[0][0]*d.x+m[0][1]*d.y+m[0][2]*d.z,
+		m[1][0]*d.x+m[1][1]*d.y+m[1][2]*d.z,
+		m[2][0]*d.x+m[2][1]*d.y+m[2][2]*d.z,
+	)
+}
+
+func (m Matrix4) Transpose() Matrix4 {
+	var r Matrix4
+	for i := 0; i < 4; i++ {
+		for j := 0; j < 4; j++ {
+			r[i][j] = m[j][i]
+		}
+	}
+	return r
+}
+
+// Inverse inverts an affine transform by inverting its 3x3 linear part and
+// undoing the translation. Singular matrices yield a matrix of NaNs.
+func (m Matrix4) Inverse() Matrix4 {
+	a, b, c := m[0][0], m[0][1], m[0][2]
+	d, e, f := m[1][0], m[1][1], m[1][2]
+	g, h, i := m[2][0], m[2][1], m[2][2]
+	det := a*(e*i-f*h) - b*(d*i-f*g) + c*(d*h-e*g)
+	inv_det := 1 / det
+	if det == 0 {
+		inv_det = math.NaN()
+	}
+	var r Matrix4
+	r[0][0] = (e*i - f*h) * inv_det
+	r[0][1] = (c*h - b*i) * inv_det
+	r[0][2] = (b*f - c*e) * inv_det
+	r[1][0] = (f*g - d*i) * inv_det
+	r[1][1] = (a*i - c*g) * inv_det
+	r[1][2] = (c*d - a*f) * inv_det
+	r[2][0] = (d*h - e*g) * inv_det
+	r[2][1] = (b*g - a*h) * inv_det
+	r[2][2] = (a*e - b*d) * inv_det
+	t := r.MulDirection(MakeVector(m[0][3], m[1][3], m[2][3]))
+	r[0][3], r[1][3], r[2][3] = -t.x, -t.y, -t.z
+	r[3][3] = 1
+	return r
+}
+
+// Transformed places an object in the world through an object-to-world
+// matrix. Rays are carried into object space rather than transforming the
+// geometry, so any object can be moved, rotated and stretched.
+type Transformed struct {
+	object    Object
+	to_world  Matrix4
+	to_object Matrix4
+}
+
+func MakeTransformed(object Object, to_world Matrix4) Transformed {
+	var t Transformed
+	t.object = object
+	t.to_world = to_world
+	t.to_object = to_world.Inverse()
+	return t
+}
+
+// SetTransform moves the object. Any accelerator holding it must be
+// rebuilt, as Scene.MoveInstance does, but the object itself is untouched.
+func (t *Transformed) SetTransform(to_world Matrix4) {
+	t.to_world = to_world
+	t.to_object = to_world.Inverse()
+}
+
+// Directions are left unnormalized, so t is the same in both spaces.
+func (t *Transformed) toObject(origin Vector, direction Vector) (Vector, Vector) {
+	return t.to_object.MulPoint(origin), t.to_object.MulDirection(direction)
+}
+
+func (t *Transformed) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	o, d := t.toObject(origin, direction)
+	return t.object.Intersect(o, d, t_min, t_max)
+}
+
+func (t *Transformed) Hit(origin Vector, direction Vector, t_min float64, t_max float64) (Primitive, float64) {
+	o, d := t.toObject(origin, direction)
+	if compound, ok := t.object.(Compound); ok {
+		p, hit_t := compound.Hit(o, d, t_min, t_max)
+		if p == nil {
+			return nil, 0
+		}
+		return &transformedPrimitive{p, t}, hit_t
+	}
+	hit_t, ok := t.object.Intersect(o, d, t_min, t_max)
+	if !ok {
+		return nil, 0
+	}
+	return &transformedPrimitive{t.object.(Primitive), t}, hit_t
+}
+
+// CacheOrigin caches the origin in object space, where the rays reach the
+// object.
+func (t *Transformed) CacheOrigin(origin Vector) {
+	cacheOrigin(t.object, t.to_object.MulPoint(origin))
+}
+
+func (t *Transformed) Bounds() AABB {
+	inner := boundsOf(t.object)
+	if !inner.Finite() {
+		return InfiniteAABB()
+	}
+	box := EmptyAABB()
+	for i := 0; i < 8; i++ {
+		corner := inner.min
+		if i&1 != 0 {
+			corner.x = inner.max.x
+		}
+		if i&2 != 0 {
+			corner.y = inner.max.y
+		}
+		if i&4 != 0 {
+			corner.z = inner.max.z
+		}
+		box = box.Extend(t.to_world.MulPoint(corner))
+	}
+	return box
+}
+
+// Spans lets transformed solids take part in CSG. Objects that are not
+// solids have no spans.
+func (t *Transformed) Spans(origin Vector, direction Vector) []Span {
+	solid, ok := t.object.(Solid)
+	if !ok {
+		return nil
+	}
+	o, d := t.toObject(origin, direction)
+	spans := solid.Spans(o, d)
+	for i := range spans {
+		spans[i].in.surface = &transformedPrimitive{spans[i].in.surface, t}
+		spans[i].out.surface = &transformedPrimitive{spans[i].out.surface, t}
+	}
+	return spans
+}
+
+// transformedPrimitive shades an object-space primitive in world space.
+type transformedPrimitive struct {
+	Primitive
+	transform *Transformed
+}
+
+func (p *transformedPrimitive) NormalAt(point Vector) Vector {
+	// Normals transform by the inverse transpose of the object-to-world matrix.
+	n := p.Primitive.NormalAt(p.transform.to_object.MulPoint(point))
+	return normalize(p.transform.to_object.Transpose().MulDirection(n))
+}
+
+func (p *transformedPrimitive) UVAt(point Vector) (float64, float64) {
+	return uvAt(p.Primitive, p.transform.to_object.MulPoint(point))
+}
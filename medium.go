@@ -0,0 +1,64 @@
+package main
+
+import "math"
+
+// Medium is a constant-density volume such as fog or smoke filling a solid.
+// Rays travelling through it scatter at a random depth, so thin media let
+// most rays through and dense media look nearly opaque.
+type Medium struct {
+	boundary Solid
+	density  float64 // scattering events per unit distance
+	// material is matte in the given color; with the zero normal from
+	// NormalAt it lights scattering points from every direction.
+	material Material
+}
+
+func MakeMedium(boundary Solid, density float64, color Color) Medium {
+	var m Medium
+	m.boundary = boundary
+	m.density = density
+	m.material = MakeMaterial(color, -1, 0)
+	return m
+}
+
+func (m *Medium) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	length := norm(direction)
+	for _, span := range m.boundary.Spans(origin, direction) {
+		enter := math.Max(span.in.t, t_min)
+		exit := math.Min(span.out.t, t_max)
+		if enter >= exit {
+			continue
+		}
+		// Free-flight distance for an exponential attenuation, random
+		// in the ray: Intersect has no random source of its own.
+		u := (float64(rayHash(origin, direction)>>11) + 0.5) / (1 << 53)
+		distance := -math.Log(u) / m.density
+		if distance < (exit-enter)*length {
+			return enter + distance/length, true
+		}
+	}
+	return math.Inf(1), false
+}
+
+// rayHash mixes the bits of a ray's origin and direction.
+func rayHash(origin Vector, direction Vector) uint64 {
+	h := uint64(0)
+	for _, v := range [6]float64{origin.x, origin.y, origin.z, direction.x, direction.y, direction.z} {
+		h = mix64(h ^ math.Float64bits(v))
+	}
+	return h
+}
+
+// NormalAt returns the zero vector: a scattering point has no surface, and
+// Lighting treats it as lit equally from every direction.
+func (m *Medium) NormalAt(point Vector) Vector {
+	return Vector{}
+}
+
+func (m *Medium) Material() *Material {
+	return &m.material
+}
+
+func (m *Medium) Bounds() AABB {
+	return boundsOf(m.boundary)
+}
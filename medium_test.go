@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// randomDirection returns a direction uniform over the sphere.
+func randomDirection(rng *rand.Rand) Vector {
+	for {
+		v := MakeVector(2*rng.Float64()-1, 2*rng.Float64()-1, 2*rng.Float64()-1)
+		if l := norm(v); l > 0.1 && l <= 1 {
+			return v
+		}
+	}
+}
+
+func TestMediumFreeFlight(t *testing.T) {
+	const density, rays = 0.5, 20000
+	rng := rand.New(rand.NewSource(1))
+
+	// Inside a boundary too big to leave, rays scatter after 1/density on
+	// average, however long their directions.
+	world := MakeSphere(MakeVector(0, 0, 0), 1e6, nil)
+	fog := MakeMedium(&world, density, MakeColor(1, 1, 1))
+	sum := 0.0
+	for i := 0; i < rays; i++ {
+		origin := MakeVector(rng.Float64(), rng.Float64(), rng.Float64())
+		direction := scale(randomDirection(rng), 1+rng.Float64())
+		t_hit, ok := fog.Intersect(origin, direction, 0, math.Inf(1))
+		if !ok {
+			t.Fatal("a ray left a medium it cannot leave")
+		}
+		sum += t_hit * norm(direction)
+	}
+	if mean := sum / rays; math.Abs(mean-1/density) > 0.05/density {
+		t.Errorf("mean free flight is %v, want %v", mean, 1/density)
+	}
+
+	// Through a slab 1 thick, exp(-density) of the rays get across.
+	slab := MakeBox(MakeVector(-10, -10, 0), MakeVector(10, 10, 1), nil)
+	smoke := MakeMedium(&slab, density, MakeColor(1, 1, 1))
+	through := 0
+	for i := 0; i < rays; i++ {
+		origin := MakeVector(rng.Float64(), rng.Float64(), -1)
+		if _, ok := smoke.Intersect(origin, MakeVector(0, 0, 1), 0, math.Inf(1)); !ok {
+			through++
+		}
+	}
+	if got, want := float64(through)/rays, math.Exp(-density); math.Abs(got-want) > 0.02 {
+		t.Errorf("%v of rays cross the slab, want %v", got, want)
+	}
+}
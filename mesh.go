@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type Mesh struct {
+	vertices []Vector
+	faces    [][3]int // indices into vertices
+	normals  []Vector // per-face normal, parallel to faces
+	// uvs holds optional texture coordinates, parallel to vertices.
+	uvs      [][2]float64
+	material *Material
+	bvh      *BVH // built over the faces by BVH
+}
+
+func MakeMesh(vertices []Vector, faces [][3]int, material *Material) Mesh {
+	var m Mesh
+	m.vertices = vertices
+	m.faces = faces
+	m.normals = make([]Vector, len(faces))
+	for i, f := range faces {
+		v0, v1, v2 := vertices[f[0]], vertices[f[1]], vertices[f[2]]
+		m.normals[i] = normalize(cross(sub(v1, v0), sub(v2, v0)))
+	}
+	m.material = material
+	return m
+}
+
+// LoadModel loads the meshes in a model file, choosing the format by file
+// extension.
+func LoadModel(path string, material *Material) ([]Mesh, error) {
+	var mesh Mesh
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".obj":
+		mesh, err = LoadOBJ(path, material)
+	case ".stl":
+		mesh, err = LoadSTL(path, material)
+	case ".ply":
+		mesh, err = LoadPLY(path, material)
+	case ".bpt":
+		patches, err := LoadBezierPatches(path)
+		if err != nil {
+			return nil, err
+		}
+		mesh = TessellatePatches(patches, patchDivisions, material)
+	case ".gltf", ".glb":
+		return LoadGLTF(path, material)
+	default:
+		return nil, fmt.Errorf("%s: unsupported model format", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []Mesh{mesh}, nil
+}
+
+// LoadOBJ reads the vertex and face records of a Wavefront OBJ file.
+// Polygons with more than three vertices are fan-triangulated; texture
+// coordinates, vertex normals, groups and materials are ignored.
+func LoadOBJ(path string, material *Material) (Mesh, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Mesh{}, err
+	}
+	defer f.Close()
+
+	var vertices []Vector
+	var faces [][3]int
+	scanner := bufio.NewScanner(f)
+	line_no := 0
+	for scanner.Scan() {
+		line_no++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		switch fields[0] {
+		case "v":
+			if len(fields) < 4 {
+				return Mesh{}, fmt.Errorf("%s:%d: vertex needs 3 coordinates", path, line_no)
+			}
+			var xyz [3]float64
+			for i := range xyz {
+				xyz[i], err = strconv.ParseFloat(fields[i+1], 64)
+				if err != nil {
+					return Mesh{}, fmt.Errorf("%s:%d: bad vertex coordinate %q", path, line_no, fields[i+1])
+				}
+			}
+			vertices = append(vertices, MakeVector(xyz[0], xyz[1], xyz[2]))
+		case "f":
+			if len(fields) < 4 {
+				return Mesh{}, fmt.Errorf("%s:%d: face needs at least 3 vertices", path, line_no)
+			}
+			idx := make([]int, len(fields)-1)
+			for i, field := range fields[1:] {
+				// Faces may be written as v, v/vt, v//vn or v/vt/vn.
+				n, err := strconv.Atoi(strings.SplitN(field, "/", 2)[0])
+				if err != nil {
+					return Mesh{}, fmt.Errorf("%s:%d: bad face index %q", path, line_no, field)
+				}
+				if n < 0 {
+					n += len(vertices) // relative to the vertices read so far
+				} else {
+					n-- // OBJ indices are 1-based
+				}
+				if n < 0 || n >= len(vertices) {
+					return Mesh{}, fmt.Errorf("%s:%d: face index %q out of range", path, line_no, field)
+				}
+				idx[i] = n
+			}
+			for i := 1; i+1 < len(idx); i++ {
+				faces = append(faces, [3]int{idx[0], idx[i], idx[i+1]})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Mesh{}, err
+	}
+	if len(faces) == 0 {
+		return Mesh{}, fmt.Errorf("%s: no faces", path)
+	}
+	return MakeMesh(vertices, faces, material), nil
+}
+
+// MeshFace is a single triangle of a Mesh. Faces reference the mesh's
+// shared vertex data rather than copying it.
+type MeshFace struct {
+	mesh  *Mesh
+	index int
+}
+
+// Faces returns every triangle of the mesh as a traceable object.
+func (m *Mesh) Faces() []Object {
+	faces := make([]Object, len(m.faces))
+	for i := range m.faces {
+		faces[i] = &MeshFace{mesh: m, index: i}
+	}
+	return faces
+}
+
+// BVH returns a hierarchy over the mesh's faces, building it on first use.
+// Instances of the mesh share it as their bottom level, so moving an
+// instance only means rebuilding the scene's top-level structure.
+func (m *Mesh) BVH() *BVH {
+	if m.bvh == nil {
+		bvh := MakeBVH(m.Faces())
+		m.bvh = &bvh
+	}
+	return m.bvh
+}
+
+func (f *MeshFace) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	m := f.mesh
+	face := m.faces[f.index]
+	t := intersectTriangle(origin, direction, m.vertices[face[0]], m.vertices[face[1]], m.vertices[face[2]])
+	return nearestIn(t_min, t_max, t)
+}
+
+func (f *MeshFace) NormalAt(point Vector) Vector {
+	return f.mesh.normals[f.index]
+}
+
+func (f *MeshFace) Material() *Material {
+	return f.mesh.material
+}
+
+// UVAt interpolates the mesh's texture coordinates across the face, or
+// returns the barycentric weights of its second and third vertices if the
+// mesh has none.
+func (f *MeshFace) UVAt(point Vector) (float64, float64) {
+	face := f.mesh.faces[f.index]
+	v0, v1, v2 := f.mesh.vertices[face[0]], f.mesh.vertices[face[1]], f.mesh.vertices[face[2]]
+	b1, b2 := barycentric(point, v0, v1, v2)
+	if f.mesh.uvs == nil {
+		return b1, b2
+	}
+	t0, t1, t2 := f.mesh.uvs[face[0]], f.mesh.uvs[face[1]], f.mesh.uvs[face[2]]
+	b0 := 1 - b1 - b2
+	return b0*t0[0] + b1*t1[0] + b2*t2[0], b0*t0[1] + b1*t1[1] + b2*t2[1]
+}
+
+func (f *MeshFace) Bounds() AABB {
+	face := f.mesh.faces[f.index]
+	v := f.mesh.vertices
+	return AABB{v[face[0]], v[face[0]]}.Extend(v[face[1]]).Extend(v[face[2]])
+}
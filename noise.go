@@ -0,0 +1,142 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// perlinHash is a permutation of 0..255, repeated so that lookups of
+// index+1 need no wrapping. A fixed seed keeps noise the same between runs.
+var perlinHash = func() [512]int {
+	var hash [512]int
+	for i, p := range rand.New(rand.NewSource(1)).Perm(256) {
+		hash[i], hash[i+256] = p, p
+	}
+	return hash
+}()
+
+// Noise is Perlin's improved gradient noise: smooth, roughly in [-1, 1]
+// and zero at every integer lattice point.
+func Noise(p Vector) float64 {
+	fx, fy, fz := math.Floor(p.x), math.Floor(p.y), math.Floor(p.z)
+	x, y, z := p.x-fx, p.y-fy, p.z-fz
+	X, Y, Z := int(fx)&255, int(fy)&255, int(fz)&255
+	u, v, w := fade(x), fade(y), fade(z)
+
+	h := &perlinHash
+	a := h[X] + Y
+	aa, ab := h[a]+Z, h[a+1]+Z
+	b := h[X+1] + Y
+	ba, bb := h[b]+Z, h[b+1]+Z
+	return lerp(w,
+		lerp(v,
+			lerp(u, grad(h[aa], x, y, z), grad(h[ba], x-1, y, z)),
+			lerp(u, grad(h[ab], x, y-1, z), grad(h[bb], x-1, y-1, z))),
+		lerp(v,
+			lerp(u, grad(h[aa+1], x, y, z-1), grad(h[ba+1], x-1, y, z-1)),
+			lerp(u, grad(h[ab+1], x, y-1, z-1), grad(h[bb+1], x-1, y-1, z-1))))
+}
+
+func fade(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+func lerp(t float64, a float64, b float64) float64 {
+	return a + t*(b-a)
+}
+
+// grad picks one of twelve edge directions of a cube from the hash and
+// dots it with (x, y, z).
+func grad(hash int, x float64, y float64, z float64) float64 {
+	h := hash & 15
+	u := y
+	if h < 8 {
+		u = x
+	}
+	v := z
+	if h < 4 {
+		v = y
+	} else if h == 12 || h == 14 {
+		v = x
+	}
+	if h&1 != 0 {
+		u = -u
+	}
+	if h&2 != 0 {
+		v = -v
+	}
+	return u + v
+}
+
+// FBM sums octaves of noise, each at twice the frequency and half the
+// amplitude of the last, for detail at every scale such as in clouds.
+func FBM(p Vector, octaves int) float64 {
+	sum, amplitude := 0.0, 1.0
+	for i := 0; i < octaves; i++ {
+		sum += amplitude * Noise(p)
+		p = scale(p, 2)
+		amplitude /= 2
+	}
+	return sum
+}
+
+// Turbulence is FBM over the absolute value of the noise, whose creases
+// make the veins of marble and the licks of flame.
+func Turbulence(p Vector, octaves int) float64 {
+	sum, amplitude := 0.0, 1.0
+	for i := 0; i < octaves; i++ {
+		sum += amplitude * math.Abs(Noise(p))
+		p = scale(p, 2)
+		amplitude /= 2
+	}
+	return sum
+}
+
+// CloudTexture blends between two colors by fractal noise, sampled at
+// scale times the hit point.
+type CloudTexture struct {
+	a       Color
+	b       Color
+	scale   float64
+	octaves int
+}
+
+func MakeCloudTexture(a Color, b Color, scale float64, octaves int) CloudTexture {
+	var t CloudTexture
+	t.a = a
+	t.b = b
+	t.scale = scale
+	t.octaves = octaves
+	return t
+}
+
+func (t *CloudTexture) ColorAt(u float64, v float64, point Vector) Color {
+	k := math.Max(0, math.Min(1, 0.5+0.5*FBM(scale(point, t.scale), t.octaves)))
+	return AddColors(WeightColor(t.a, 1-k), WeightColor(t.b, k))
+}
+
+// MarbleTexture bands two colors along x, with the bands wound about by
+// turbulence of the given strength.
+type MarbleTexture struct {
+	a          Color
+	b          Color
+	scale      float64
+	turbulence float64
+	octaves    int
+}
+
+func MakeMarbleTexture(a Color, b Color, scale float64, turbulence float64, octaves int) MarbleTexture {
+	var t MarbleTexture
+	t.a = a
+	t.b = b
+	t.scale = scale
+	t.turbulence = turbulence
+	t.octaves = octaves
+	return t
+}
+
+func (t *MarbleTexture) ColorAt(u float64, v float64, point Vector) Color {
+	p := scale(point, t.scale)
+	k := 0.5 + 0.5*math.Sin(p.x+t.turbulence*Turbulence(p, t.octaves))
+	return AddColors(WeightColor(t.a, 1-k), WeightColor(t.b, k))
+}
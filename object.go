@@ -0,0 +1,106 @@
+package main
+
+import "math"
+
+// Object is anything the tracer can intersect a ray with.
+type Object interface {
+	// Intersect returns the nearest t in [t_min, t_max] at which
+	// origin + t*direction meets the object.
+	Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool)
+}
+
+// Primitive is an Object with a single surface that can be shaded.
+type Primitive interface {
+	Object
+	// NormalAt returns the outward unit normal at a point on the surface.
+	NormalAt(point Vector) Vector
+	// Material returns the material the surface is shaded with.
+	Material() *Material
+}
+
+// UVMapped primitives assign texture coordinates to points on their
+// surface.
+type UVMapped interface {
+	UVAt(point Vector) (float64, float64)
+}
+
+// Compound is an Object assembled from other objects. Hit resolves a ray to
+// the primitive that was struck so that it can be shaded.
+type Compound interface {
+	Object
+	Hit(origin Vector, direction Vector, t_min float64, t_max float64) (Primitive, float64)
+}
+
+// Group treats a collection of objects as one, such as the faces of a mesh
+// shared between many instances. Rays that miss the cached bounds of the
+// whole group skip the scan over its members.
+type Group struct {
+	objects []Object
+	bounds  AABB
+}
+
+func MakeGroup(objects []Object) Group {
+	var g Group
+	g.objects = objects
+	g.bounds = EmptyAABB()
+	for _, object := range objects {
+		g.bounds = g.bounds.Union(boundsOf(object))
+	}
+	return g
+}
+
+func (g *Group) Hit(origin Vector, direction Vector, t_min float64, t_max float64) (Primitive, float64) {
+	if !hitsBounds(g.bounds, origin, direction, t_min, t_max) {
+		return nil, 0
+	}
+	best_t := t_max
+	var best_object Primitive
+	for _, object := range g.objects {
+		if compound, ok := object.(Compound); ok {
+			if p, t := compound.Hit(origin, direction, t_min, best_t); p != nil {
+				best_object = p
+				best_t = t
+			}
+		} else if t, ok := object.Intersect(origin, direction, t_min, best_t); ok {
+			best_object = object.(Primitive)
+			best_t = t
+		}
+	}
+	return best_object, best_t
+}
+
+func (g *Group) CacheOrigin(origin Vector) {
+	for _, object := range g.objects {
+		cacheOrigin(object, origin)
+	}
+}
+
+func (g *Group) Bounds() AABB {
+	return g.bounds
+}
+
+func (g *Group) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	if !hitsBounds(g.bounds, origin, direction, t_min, t_max) {
+		return 0, false
+	}
+	best_t := t_max
+	found := false
+	for _, object := range g.objects {
+		if t, ok := object.Intersect(origin, direction, t_min, best_t); ok {
+			best_t = t
+			found = true
+		}
+	}
+	return best_t, found
+}
+
+// nearestIn picks the smallest candidate t inside [t_min, t_max].
+func nearestIn(t_min float64, t_max float64, ts ...float64) (float64, bool) {
+	best_t := math.Inf(1)
+	for _, t := range ts {
+		if t_min <= t && t <= t_max && t < best_t {
+			best_t = t
+		}
+	}
+	return best_t, !math.IsInf(best_t, 1)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// imageFormat returns the format an output path's extension names, "png",
+// "ppm", "jpeg", "webp", "exr", "hdr" or "pfm", or an error for any other
+// extension.
+func imageFormat(path string) (string, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".png", ".ppm", ".webp", ".exr", ".hdr", ".pfm":
+		return ext[1:], nil
+	case ".jpg", ".jpeg":
+		return "jpeg", nil
+	}
+	return "", fmt.Errorf("%s: unsupported image format, want .png, .ppm, .jpg, .webp, .exr, .hdr or .pfm", path)
+}
+
+// webpEncoder is the program that encodes WebP output, as Go's image
+// packages only decode it.
+const webpEncoder = "cwebp"
+
+// Save writes the canvas in the format its path's extension names: PNG or
+// plain PPM, JPEG or WebP at the given quality from 1 to 100 for small
+// previews, or OpenEXR, Radiance HDR or PFM for the pixels' linear colors
+// before tone mapping and encoding, to be graded elsewhere without banding
+// or clipping. EXR keeps full 32-bit floats; HDR's shared exponents take a
+// quarter of the space. PPM and PFM are simple enough for any tool to read.
+func (c *Canvas) Save(path string, quality int) error {
+	format, err := imageFormat(path)
+	if err != nil {
+		return err
+	}
+	w, h := c.width, c.height
+	if c.features != nil {
+		if err := c.features.WriteIDs(strings.TrimSuffix(path, filepath.Ext(path)) + "_ids.txt"); err != nil {
+			return err
+		}
+	}
+	if c.features != nil && format != "exr" {
+		for name, feature := range c.features.Canvases() {
+			if err := feature.Save(stereoPath(path, "_"+name), quality); err != nil {
+				return err
+			}
+		}
+	}
+	switch format {
+	case "exr":
+		layers := []exrLayer{{"", c.radiance}}
+		scalars := map[string][]float64{}
+		if c.transparent {
+			scalars["A"] = c.alpha
+		}
+		if c.features != nil {
+			layers = append(layers, exrLayer{"normal", c.features.normal}, exrLayer{"albedo", c.features.albedo})
+			scalars["Z"] = c.features.depth
+			for name, ids := range map[string][]int{"object_id": c.features.object_id, "material_id": c.features.material_id} {
+				values := make([]float64, len(ids))
+				for i, id := range ids {
+					values[i] = float64(id)
+				}
+				scalars[name] = values
+			}
+		}
+		return writeEXR(path, w, h, layers, scalars)
+	case "hdr":
+		return writeRGBE(path, c.radiance, w, h)
+	case "pfm":
+		return writePFM(path, c.radiance, w, h)
+	case "ppm":
+		return writePPM(path, c.Image())
+	case "jpeg":
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		if err := jpeg.Encode(f, c.Image(), &jpeg.Options{Quality: quality}); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	case "webp":
+		return writeWebP(path, c, quality)
+	}
+	return savePNG(path, c.Image())
+}
+
+// Display shows an image of a canvas, such as a preview of one still being
+// drawn.
+type Display func(img *image.NRGBA)
+
+// FileDisplay returns a Display writing PNG files to path, through a
+// temporary file so viewers never load a half-written image.
+func FileDisplay(path string) Display {
+	return func(img *image.NRGBA) {
+		partial := path + ".part"
+		if err := savePNG(partial, img); err != nil {
+			log.Print(err)
+			return
+		}
+		if err := os.Rename(partial, path); err != nil {
+			log.Print(err)
+		}
+	}
+}
+
+// browserDisplay, set when running in a web page with a canvas to draw on,
+// takes the place of the image files main would write; see browser_js.go.
+var browserDisplay Display
+
+func savePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writePPM writes an image as a plain (ASCII) PPM file, one row a line.
+func writePPM(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	out := bufio.NewWriter(f)
+	bounds := img.Bounds()
+	fmt.Fprintf(out, "P3\n%d %d\n255\n", bounds.Dx(), bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if x > bounds.Min.X {
+				out.WriteByte(' ')
+			}
+			fmt.Fprintf(out, "%d %d %d", r>>8, g>>8, b>>8)
+		}
+		out.WriteByte('\n')
+	}
+	if err := out.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeWebP encodes the canvas with webpEncoder, from a PNG copy.
+func writeWebP(path string, c *Canvas, quality int) error {
+	encoder, err := exec.LookPath(webpEncoder)
+	if err != nil {
+		return fmt.Errorf("%s: WebP output needs %s: %v", path, webpEncoder, err)
+	}
+	dir, err := os.MkdirTemp("", "webp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	png := filepath.Join(dir, "out.png")
+	if err := savePNG(png, c.Image()); err != nil {
+		return err
+	}
+	cmd := exec.Command(encoder, "-quiet", "-q", fmt.Sprint(quality), png, "-o", path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %v: %s", path, err, out)
+	}
+	return nil
+}
+
+// SideBySide returns a canvas twice as wide holding left and right.
+func SideBySide(left *Canvas, right *Canvas) *Canvas {
+	w, h := left.width, left.height
+	frame := &Canvas{width: 2 * w, height: h, radiance: make([]Color, 2*w*h), alpha: make([]float64, 2*w*h), encode: left.encode, transparent: left.transparent}
+	for j := 0; j < h; j++ {
+		copy(frame.radiance[j*2*w:], left.radiance[j*w:(j+1)*w])
+		copy(frame.radiance[j*2*w+w:], right.radiance[j*w:(j+1)*w])
+		copy(frame.alpha[j*2*w:], left.alpha[j*w:(j+1)*w])
+		copy(frame.alpha[j*2*w+w:], right.alpha[j*w:(j+1)*w])
+	}
+	return frame
+}
+
+// stereoPath returns path with suffix added before its extension, as in
+// out_left.png.
+func stereoPath(path string, suffix string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + suffix + ext
+}
@@ -0,0 +1,81 @@
+package main
+
+// packetSize is the number of rays in a packet, traced as a 2x2 block of
+// neighbouring pixels.
+const packetSize = 4
+
+// PacketTracer is implemented by accelerators that can find the closest
+// hits for a packet of coherent rays in one traversal.
+type PacketTracer interface {
+	HitPacket(origins [packetSize]Vector, directions [packetSize]Vector, t_min float64, t_max float64) ([packetSize]Primitive, [packetSize]float64)
+}
+
+// HitPacket walks the BVH once for the whole packet. A node is entered if
+// any ray hits its box, and only those rays are tested against its
+// children, so coherent rays share most of the traversal work.
+func (b *BVH) HitPacket(origins [packetSize]Vector, directions [packetSize]Vector, t_min float64, t_max float64) ([packetSize]Primitive, [packetSize]float64) {
+	var objects [packetSize]Primitive
+	var ts [packetSize]float64
+	var inv_dirs [packetSize]Vector
+	for i, direction := range directions {
+		ts[i] = t_max
+		inv_dirs[i] = MakeVector(1/direction.x, 1/direction.y, 1/direction.z)
+	}
+
+	test := func(object Object, i int) {
+		if compound, ok := object.(Compound); ok {
+			if p, t := compound.Hit(origins[i], directions[i], t_min, ts[i]); p != nil {
+				objects[i], ts[i] = p, t
+			}
+		} else if t, ok := object.Intersect(origins[i], directions[i], t_min, ts[i]); ok {
+			objects[i], ts[i] = object.(Primitive), t
+		}
+	}
+	for _, object := range b.unbounded {
+		for i := range directions {
+			test(object, i)
+		}
+	}
+	if len(b.nodes) == 0 {
+		return objects, ts
+	}
+
+	// The first ray's direction orders the children for the whole packet.
+	negative := [3]bool{directions[0].x < 0, directions[0].y < 0, directions[0].z < 0}
+	var stack [64]int
+	top := 0
+	stack[top] = 0
+	top++
+	for top > 0 {
+		top--
+		index := stack[top]
+		node := &b.nodes[index]
+		var active [packetSize]bool
+		any := false
+		for i := range directions {
+			active[i] = node.bounds.Hit(origins[i], inv_dirs[i], t_min, ts[i])
+			any = any || active[i]
+		}
+		if !any {
+			continue
+		}
+		if node.count > 0 {
+			for _, object := range b.objects[node.offset : node.offset+node.count] {
+				for i := range directions {
+					if active[i] {
+						test(object, i)
+					}
+				}
+			}
+			continue
+		}
+		near, far := index+1, node.offset
+		if negative[node.axis] {
+			near, far = far, near
+		}
+		stack[top] = far
+		stack[top+1] = near
+		top += 2
+	}
+	return objects, ts
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// PathPainter paints the scene by path tracing: every pixel averages
+// scene.path_samples camera rays. With the "path" and "bdpt" integrators
+// each is traced by pathTracer or bidirectionalTracer; otherwise ShadeHit follows each diffuse hit with a random
+// bounce, so light reflected and emitted by objects reaches the rest of the
+// scene. Each path starts from a stratified point in its pixel, which also
+// anti-aliases edges.
+func PathPainter(scene *Scene, camera *Camera, canvas *Canvas, max_recursion_depth int) tilePainter {
+	samples := scene.path_samples
+	if samples <= 0 {
+		samples = defaultPathSamples
+	}
+	groups := scene.mean_groups
+	if groups > samples {
+		groups = samples
+	}
+	if groups < 1 {
+		groups = 1
+	}
+	return func(scene *Scene) func(tile Tile) {
+		tracer := makePathTracer(scene)
+		bidirectional := makeBidirectionalTracer(scene)
+		sampler := MakeSampler(scene.rng, samples)
+		sums := make([]Color, groups)
+		counts := make([]int, groups)
+		alpha := 1.0
+		return func(tile Tile) {
+			for x := tile.x0; x < tile.x1; x++ {
+				for y := tile.y0; y < tile.y1; y++ {
+					for i := range sums {
+						sums[i], counts[i] = Color{}, 0
+					}
+					hits := 0
+					scene.seedPixel(x, y, 0)
+					sampler.StartPixel()
+					for s := 0; s < samples; s++ {
+						if O, D, ok := sampler.CameraRay(camera, x, y, s, true); ok {
+							var c Color
+							if scene.alpha {
+								if object, _ := ClosestIntersection(scene, O, D, 1, math.Inf(1)); object == nil {
+									counts[s*groups/samples]++
+									continue
+								}
+								hits++
+							}
+							if scene.integrator == "path" && scene.spectral {
+								tracer.wavelength = minWavelength + (maxWavelength-minWavelength)*sampler.Get1D(wavelengthDimension, s)
+								c = SpectralRGB(tracer.Trace(O, D, 1).r, tracer.wavelength)
+							} else if scene.integrator == "path" {
+								c = tracer.Trace(O, D, 1)
+							} else if scene.integrator == "bdpt" {
+								c = bidirectional.Trace(O, D, 1, sampler.Get1D(lightDimension, s))
+							} else {
+								c = TraceRay(scene, O, D, 1, math.Inf(1), max_recursion_depth)
+							}
+							sums[s*groups/samples] = AddColors(sums[s*groups/samples], scene.ClampSample(c))
+						}
+						counts[s*groups/samples]++
+					}
+					for i := range sums {
+						sums[i] = WeightColor(sums[i], 1/float64(counts[i]))
+					}
+					if scene.alpha {
+						alpha = float64(hits) / float64(samples)
+					}
+					canvas.PutPixel(x, y, medianOfMeans(sums), alpha)
+				}
+			}
+		}
+	}
+}
+
+// GatherIndirect returns the mean light arriving at a point over samples
+// cosine-weighted rays around the unit normal, each seen with its direct
+// lighting only, so the estimate is a single bounce of light from the
+// surfaces around.
+func GatherIndirect(scene *Scene, point Vector, normal Vector, samples int) Color {
+	var sum Color
+	for i := 0; i < samples; i++ {
+		c := TraceRay(scene, point, CosineSample(scene.rng, normal), scene.Bias(point), math.Inf(1), 0)
+		sum = AddColors(sum, c)
+	}
+	return WeightColor(sum, 1/float64(samples))
+}
+
+// CosineSample returns a random unit direction in the hemisphere around the
+// unit normal, more likely near the normal in proportion to the cosine.
+// Weighting incoming light by that density leaves a Lambertian surface's
+// estimate as just the albedo times the light found.
+func CosineSample(rng *rand.Rand, normal Vector) Vector {
+	r := math.Sqrt(rng.Float64())
+	phi := 2 * math.Pi * rng.Float64()
+	a, b := perpendicularBasis(normal)
+	z := math.Sqrt(math.Max(0, 1-r*r))
+	return add(add(scale(a, r*math.Cos(phi)), scale(b, r*math.Sin(phi))), scale(normal, z))
+}
+
+// defaultPathSamples is the number of paths per pixel PathPainter traces
+// for the path integrator when scene.path_samples is unset.
+const defaultPathSamples = 16
+
+// maxPathBounces ends the rare paths Russian roulette has not.
+const maxPathBounces = 64
+
+// pathTracer is the unbiased integrator chosen by scene.integrator "path".
+// Unlike TraceRay it has no recursion limit and no ambient term: ambient
+// lights become a uniform sky, seen by rays that escape the scene, and all
+// other light is gathered by sampling the lights directly at every diffuse
+// hit and continuing the path in one randomly chosen direction.
+//
+// Emissive spheres are sampled directly too, by a shadow ray towards a
+// random point of the cone they fill, so small glowing objects do not need
+// a lucky bounce to be found. Diffuse bounces that then hit them do not
+// count their emission again.
+type pathTracer struct {
+	scene    *Scene // a copy of the scene without its ambient lights
+	ambient  Color
+	emitters []*Sphere
+	// wavelength, if not 0, is the wavelength in nanometers a spectral
+	// path carries; see spectrum.
+	wavelength float64
+}
+
+func makePathTracer(scene *Scene) pathTracer {
+	var p pathTracer
+	direct := *scene
+	direct.lights = nil
+	for _, light := range scene.lights {
+		if light.kind == AmbientLight {
+			p.ambient = AddColors(p.ambient, light.intensity)
+		} else {
+			direct.lights = append(direct.lights, light)
+		}
+	}
+	p.scene = &direct
+	for _, object := range scene.objects {
+		if s, ok := object.(*Sphere); ok && s.material.emission != (Color{}) {
+			p.emitters = append(p.emitters, s)
+		}
+	}
+	return p
+}
+
+// spectrum returns a color for a path of the tracer's wavelength: the
+// value of the spectrum it stands for there, or the color itself when
+// tracing in RGB. The path's colors multiply as spectra do, so its
+// throughput through colored glass or metals follows each wavelength.
+func (p *pathTracer) spectrum(c Color) Color {
+	if p.wavelength == 0 {
+		return c
+	}
+	return SpectralValue(c, p.wavelength)
+}
+
+// isEmitter reports whether the object is one of the sampled emitters.
+func (p *pathTracer) isEmitter(object Primitive) bool {
+	for _, s := range p.emitters {
+		if object == Primitive(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// sampleEmitters returns the light from the emissive spheres reflected by a
+// diffuse surface of unit albedo at point, or scattered by a volume when
+// normal is zero. Each sphere is sampled once over the cone of directions
+// it subtends.
+func (p *pathTracer) sampleEmitters(point Vector, normal Vector) Color {
+	var sum Color
+	for _, s := range p.emitters {
+		to_center := sub(s.center, point)
+		d2 := dot(to_center, to_center)
+		if d2 <= s.radius_squared {
+			continue
+		}
+		cos_max := math.Sqrt(1 - s.radius_squared/d2)
+		L := ConeSample(p.scene.rng, normalize(to_center), cos_max)
+		weight := (1 - cos_max) / 2 // a volume's even phase over the cone's solid angle
+		if normal != (Vector{}) {
+			cos := dot(normal, L)
+			if cos <= 0 {
+				continue
+			}
+			weight = 2 * (1 - cos_max) * cos // the Lambertian 1/π likewise
+		}
+		began := p.scene.timings.start(shadowRays)
+		object, _ := ClosestIntersection(p.scene, point, L, p.scene.Bias(point), math.Inf(1))
+		p.scene.timings.stop(shadowRays, began)
+		if object != Primitive(s) {
+			continue
+		}
+		sum = AddColors(sum, WeightColor(s.material.emission, weight))
+	}
+	return sum
+}
+
+// Trace returns the light arriving along a ray from origin, counting hits
+// from t_min on. Each hit chooses one event with the probability of its
+// weight in ShadeHit, such as refraction for a transparency of the time,
+// so the estimate averages to the same blend without branching.
+func (p *pathTracer) Trace(origin Vector, direction Vector, t_min float64) Color {
+	scene, rng := p.scene, p.scene.rng
+	throughput := Color{1, 1, 1}
+	var radiance Color
+	gather := func(c Color) {
+		radiance.r += throughput.r * c.r
+		radiance.g += throughput.g * c.g
+		radiance.b += throughput.b * c.b
+	}
+	attenuate := func(c Color, k float64) {
+		throughput = Color{throughput.r * c.r * k, throughput.g * c.g * k, throughput.b * c.b * k}
+	}
+
+	sampled := false // whether emitters were sampled at the last hit
+	dispersed := -1  // the channel an RGB path kept through dispersive glass
+	for bounce := 0; bounce < maxPathBounces; bounce++ {
+		var began time.Time
+		if bounce == 0 {
+			began = scene.timings.start(primaryRays)
+		}
+		object, t := ClosestIntersection(scene, origin, direction, t_min, math.Inf(1))
+		scene.timings.stop(primaryRays, began)
+		if object == nil {
+			if scene.environment != nil {
+				gather(p.spectrum(scene.environment.Sample(direction)))
+			} else {
+				gather(p.spectrum(p.ambient))
+			}
+			break
+		}
+
+		material := object.Material()
+		point := add(origin, scale(direction, t))
+		t_min = scene.Bias(point)
+		geometric := object.NormalAt(point)
+		normal := geometric
+		if dot(normal, direction) > 0 {
+			normal = neg(normal)
+		}
+		normal = material.ShadingNormal(object, point, normal)
+		albedo := p.spectrum(material.ColorAt(object, point))
+		view := neg(direction)
+		cos_i := -dot(normal, normalize(direction))
+		if !sampled || !p.isEmitter(object) {
+			gather(p.spectrum(material.emission))
+		}
+		sampled = false
+		origin = point
+
+		// Refraction or Fresnel reflection off glass. An RGB path meeting
+		// dispersive glass goes on as one channel, picked at random, as
+		// the channels refract apart.
+		if material.transparency > 0 && rng.Float64() < material.transparency {
+			lambda := p.wavelength
+			if lambda == 0 && material.dispersion != 0 {
+				if dispersed < 0 {
+					dispersed = rng.Intn(3)
+					attenuate(onlyChannel(Color{1, 1, 1}, dispersed), 3)
+				}
+				lambda = channelWavelengths[dispersed]
+			}
+			n1, n2 := 1.0, material.IOR(lambda)
+			if dot(geometric, direction) > 0 {
+				n1, n2 = n2, 1.0
+			}
+			next := ReflectRay(view, normal)
+			if T, ok := RefractRay(direction, normal, n1/n2); ok {
+				cos := cos_i
+				if n1 > n2 {
+					cos = -dot(normal, T)
+				}
+				if rng.Float64() >= Schlick(math.Pow((n1-n2)/(n1+n2), 2), cos) {
+					next = T
+				}
+			}
+			direction = next
+			continue
+		}
+
+		// Phong mirror reflection, replacing the local color
+		if material.model != "pbr" && material.reflective > 0 && normal != (Vector{}) {
+			if rng.Float64() < Schlick(material.reflective, cos_i) {
+				if material.tinted {
+					attenuate(p.spectrum(material.tint), 1)
+				}
+				direction = glossyDirection(rng, ReflectRay(view, normal), normal, material.roughness)
+				continue
+			}
+		}
+
+		// Direct light
+		diffuse := albedo
+		if material.model == "pbr" {
+			gather(p.spectrum(LightingPBR(scene, object, point, normal, view, material, albedo)))
+			diffuse = WeightColor(albedo, 1-material.metallic)
+		} else {
+			intensity, highlight := Lighting(scene, point, normal, view, material.specular)
+			intensity, highlight = p.spectrum(intensity), p.spectrum(highlight)
+			specular := albedo
+			if material.tinted {
+				specular = p.spectrum(material.tint)
+			}
+			gather(Color{albedo.r*intensity.r + specular.r*highlight.r, albedo.g*intensity.g + specular.g*highlight.g, albedo.b*intensity.b + specular.b*highlight.b})
+		}
+		gather(MultiplyColors(p.spectrum(p.sampleEmitters(point, normal)), diffuse))
+
+		// Continue the path, off the PBR specular lobe in proportion to its
+		// mean weight and otherwise diffusely.
+		mirror := 0.0
+		var F Color
+		if material.Mirrors() {
+			gloss := (1 - material.roughness) * (1 - material.roughness)
+			F = p.spectrum(WeightColor(SchlickColor(material.F0(albedo), cos_i), gloss))
+			mirror = (F.r + F.g + F.b) / 3
+		}
+		if mirror > 0 && rng.Float64() < mirror {
+			attenuate(F, 1/mirror)
+			direction = glossyDirection(rng, ReflectRay(view, normal), normal, material.roughness)
+		} else if normal == (Vector{}) {
+			attenuate(diffuse, 1/(1-mirror))
+			direction = SphereSample(rng) // volumes scatter evenly
+			sampled = true
+		} else {
+			attenuate(diffuse, 1/(1-mirror))
+			direction = CosineSample(rng, normal)
+			sampled = true
+		}
+
+		// Russian roulette, keeping bright paths more often
+		if bounce >= 3 {
+			q := math.Min(0.95, math.Max(throughput.r, math.Max(throughput.g, throughput.b)))
+			if rng.Float64() >= q {
+				break
+			}
+			attenuate(Color{1, 1, 1}, 1/q)
+		}
+	}
+	return radiance
+}
+
+// SphereSample returns a random unit direction, uniformly distributed over
+// the sphere.
+func SphereSample(rng *rand.Rand) Vector {
+	z := 1 - 2*rng.Float64()
+	r := math.Sqrt(math.Max(0, 1-z*z))
+	phi := 2 * math.Pi * rng.Float64()
+	return MakeVector(r*math.Cos(phi), r*math.Sin(phi), z)
+}
@@ -0,0 +1,123 @@
+package main
+
+import "math"
+
+// dielectricF0 is the reflectance at normal incidence of common
+// non-metals, about 4% for an index of refraction of 1.5.
+const dielectricF0 = 0.04
+
+// MakePBRMaterial returns a metallic-roughness material as authored for
+// glTF: metals take their specular color from albedo and have no diffuse
+// term, and roughness in [0, 1] widens the GGX highlight.
+func MakePBRMaterial(albedo Color, metallic float64, roughness float64) Material {
+	m := MakeMaterial(albedo, -1, 0)
+	m.model = "pbr"
+	m.metallic = metallic
+	m.roughness = roughness
+	return m
+}
+
+// Mirrors reports whether the material is a PBR material smooth enough to
+// show mirror reflections.
+func (m *Material) Mirrors() bool {
+	return m.model == "pbr" && m.roughness < 1
+}
+
+// F0 returns the material's reflectance at normal incidence: its tint if it
+// has one, or otherwise tinted by the albedo as it becomes metallic.
+func (m *Material) F0(albedo Color) Color {
+	if m.tinted {
+		return m.tint
+	}
+	dielectric := MakeColor(dielectricF0, dielectricF0, dielectricF0)
+	return AddColors(WeightColor(dielectric, 1-m.metallic), WeightColor(albedo, m.metallic))
+}
+
+func MultiplyColors(c1 Color, c2 Color) Color {
+	return MakeColor(c1.r*c2.r, c1.g*c2.g, c1.b*c2.b)
+}
+
+// SchlickColor applies Schlick's approximation to each channel of f0.
+func SchlickColor(f0 Color, cos_i float64) Color {
+	return MakeColor(Schlick(f0.r, cos_i), Schlick(f0.g, cos_i), Schlick(f0.b, cos_i))
+}
+
+// LightingPBR shades a point on the object with the Cook-Torrance
+// microfacet BRDF: a GGX distribution, Smith-Schlick shadowing-masking and
+// Schlick's Fresnel term, over a Lambertian diffuse base. The BRDF is
+// scaled by π so that a white dielectric is as bright as under Lighting.
+//
+// Anisotropic materials stretch the distribution along the surface's u
+// direction, turned about the normal by tangent_angle, for positive
+// anisotropy and across it for negative. Shadowing-masking still uses the
+// mean roughness.
+func LightingPBR(scene *Scene, object Primitive, point Vector, normal Vector, view Vector, material *Material, albedo Color) Color {
+	N := normalize(normal)
+	V := normalize(view)
+	f0 := material.F0(albedo)
+	diffuse_albedo := WeightColor(albedo, 1-material.metallic)
+	alpha := math.Max(material.roughness*material.roughness, 1e-3)
+	k := (material.roughness + 1) * (material.roughness + 1) / 8
+	n_v := math.Max(dot(N, V), 1e-4)
+
+	var T, B Vector
+	alpha_t, alpha_b := alpha, alpha
+	if material.anisotropy != 0 {
+		tangent, bitangent := uvFrame(object, point, N)
+		s, c := math.Sincos(material.tangent_angle)
+		T = normalize(add(scale(tangent, c), scale(bitangent, s)))
+		B = cross(N, T)
+		aspect := math.Sqrt(1 - 0.9*math.Abs(material.anisotropy))
+		if material.anisotropy > 0 {
+			alpha_t, alpha_b = alpha/aspect, alpha*aspect
+		} else {
+			alpha_t, alpha_b = alpha*aspect, alpha/aspect
+		}
+	}
+
+	result := MakeColor(0, 0, 0)
+	for _, light := range scene.lights {
+		if light.kind == AmbientLight {
+			result = AddColors(result, MultiplyColors(albedo, light.intensity))
+			continue
+		}
+		L, strength, ok := IncidentLight(scene, light, point)
+		if !ok {
+			continue
+		}
+		n_l := dot(N, L)
+		if n_l <= 0 {
+			continue
+		}
+		H := normalize(add(L, V))
+		n_h := math.Max(dot(N, H), 0)
+		F := SchlickColor(f0, math.Max(dot(V, H), 0))
+		D := GGX(n_h, alpha)
+		if material.anisotropy != 0 {
+			D = AnisotropicGGX(n_h, dot(H, T), dot(H, B), alpha_t, alpha_b)
+		}
+		G := (n_l / (n_l*(1-k) + k)) * (n_v / (n_v*(1-k) + k))
+		specular := WeightColor(F, math.Pi*D*G/(4*n_l*n_v))
+		kd := MakeColor(1-F.r, 1-F.g, 1-F.b)
+		diffuse := MultiplyColors(kd, diffuse_albedo)
+		result = AddColors(result, MultiplyColors(AddColors(diffuse, specular), WeightColor(strength, n_l)))
+	}
+	return result
+}
+
+// GGX is the Trowbridge-Reitz normal distribution for the cosine between
+// the normal and the half vector, with alpha the squared roughness.
+func GGX(n_h float64, alpha float64) float64 {
+	a2 := alpha * alpha
+	denom := n_h*n_h*(a2-1) + 1
+	return a2 / (math.Pi * denom * denom)
+}
+
+// AnisotropicGGX is the GGX distribution with separate roughnesses along
+// the tangent and bitangent, given the half vector's components along the
+// normal, tangent and bitangent.
+func AnisotropicGGX(n_h float64, t_h float64, b_h float64, alpha_t float64, alpha_b float64) float64 {
+	x, y := t_h/alpha_t, b_h/alpha_b
+	denom := x*x + y*y + n_h*n_h
+	return 1 / (math.Pi * alpha_t * alpha_b * denom * denom)
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// Photon gathering: the nearest photonNeighbors photons within
+// photonRadius of a point, and the same for the caustic map, whose photons
+// are denser and filtered over a tighter disk for sharp focus.
+const (
+	photonNeighbors  = 100
+	photonRadius     = 0.5
+	causticNeighbors = 50
+	causticRadius    = 0.1
+	maxPhotonBounces = 8
+)
+
+// Photon is a packet of light power that landed on a diffuse surface.
+type Photon struct {
+	position Vector
+	power    Color
+}
+
+// PhotonMap stores photons as a balanced kd-tree laid out in the slice
+// itself: the photon at the middle of any range splits it along axes[i],
+// with the lower half before it and the upper half after.
+type PhotonMap struct {
+	photons []Photon
+	axes    []int
+}
+
+// PhotonMaps holds the photons traced by TracePhotons: caustic photons
+// reached a diffuse surface through mirrors and glass alone, and global
+// photons after at least one diffuse bounce. Photons straight from a light
+// are not stored, as ShadeHit traces direct light itself.
+type PhotonMaps struct {
+	global  PhotonMap
+	caustic PhotonMap
+}
+
+func MakePhotonMap(photons []Photon) PhotonMap {
+	var m PhotonMap
+	m.photons = photons
+	m.axes = make([]int, len(photons))
+	m.build(0, len(photons))
+	return m
+}
+
+func (m *PhotonMap) build(lo int, hi int) {
+	if hi-lo <= 0 {
+		return
+	}
+	box := EmptyAABB()
+	for _, p := range m.photons[lo:hi] {
+		box = box.Extend(p.position)
+	}
+	extent := sub(box.max, box.min)
+	axis := 0
+	if extent.y > extent.x && extent.y >= extent.z {
+		axis = 1
+	} else if extent.z > extent.x && extent.z > extent.y {
+		axis = 2
+	}
+	span := m.photons[lo:hi]
+	sort.Slice(span, func(i, j int) bool {
+		return component(span[i].position, axis) < component(span[j].position, axis)
+	})
+	mid := (lo + hi) / 2
+	m.axes[mid] = axis
+	m.build(lo, mid)
+	m.build(mid+1, hi)
+}
+
+type photonEntry struct {
+	photon *Photon
+	dist2  float64
+}
+
+// photonHeap is a max-heap of photons by squared distance, so the farthest
+// of the nearest photons found so far is the first replaced.
+type photonHeap []photonEntry
+
+func (h photonHeap) Len() int            { return len(h) }
+func (h photonHeap) Less(i, j int) bool  { return h[i].dist2 > h[j].dist2 }
+func (h photonHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *photonHeap) Push(x interface{}) { *h = append(*h, x.(photonEntry)) }
+func (h *photonHeap) Pop() interface{} {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// Irradiance estimates the light arriving at a point from the k photons
+// nearest to it within radius, as their power over the area of the disk
+// that holds them. Photons arriving from behind the surface are skipped.
+func (m *PhotonMap) Irradiance(point Vector, normal Vector, k int, radius float64) Color {
+	if len(m.photons) == 0 {
+		return Color{}
+	}
+	h := &photonHeap{}
+	max_dist2 := radius * radius
+	var search func(lo int, hi int)
+	search = func(lo int, hi int) {
+		if hi-lo <= 0 {
+			return
+		}
+		mid := (lo + hi) / 2
+		p := &m.photons[mid]
+		axis := m.axes[mid]
+		delta := component(point, axis) - component(p.position, axis)
+		near_lo, near_hi, far_lo, far_hi := lo, mid, mid+1, hi
+		if delta > 0 {
+			near_lo, near_hi, far_lo, far_hi = mid+1, hi, lo, mid
+		}
+		search(near_lo, near_hi)
+		offset := sub(p.position, point)
+		// Flatten the search towards the surface so photons on nearby
+		// walls or folds do not bleed through.
+		if d2 := dot(offset, offset); d2 < max_dist2 && math.Abs(dot(offset, normal)) < 0.25*radius {
+			heap.Push(h, photonEntry{p, d2})
+			if h.Len() > k {
+				heap.Pop(h)
+			}
+			if h.Len() == k {
+				max_dist2 = (*h)[0].dist2
+			}
+		}
+		if delta*delta < max_dist2 {
+			search(far_lo, far_hi)
+		}
+	}
+	search(0, len(m.photons))
+	if h.Len() == 0 {
+		return Color{}
+	}
+	var sum Color
+	for _, e := range *h {
+		sum = AddColors(sum, e.photon.power)
+	}
+	return WeightColor(sum, 1/(math.Pi*max_dist2))
+}
+
+// TracePhotons shoots about n photons from the scene's point, spot and area
+// lights, splitting them by intensity. A light's photons carry 4π times its
+// intensity between them, which lands as the intensity itself on a surface
+// facing the light at unit distance, as in Lighting.
+func TracePhotons(scene *Scene, n int) *PhotonMaps {
+	var emitting []*Light
+	total := 0.0
+	for _, light := range scene.lights {
+		switch light.kind {
+		case PointLight, SpotLight, RectLight, DiskLight:
+			emitting = append(emitting, light)
+			total += luminance(light.intensity)
+		}
+	}
+	maps := &PhotonMaps{}
+	if total <= 0 || n <= 0 {
+		return maps
+	}
+	scene.seedPixel(0, 0, photonStream)
+	rng := scene.rng
+	var global, caustic []Photon
+	for _, light := range emitting {
+		count := int(float64(n) * luminance(light.intensity) / total)
+		if count == 0 {
+			continue
+		}
+		power := WeightColor(light.intensity, 4*math.Pi/float64(count))
+		for i := 0; i < count; i++ {
+			origin := light.position
+			if light.kind == RectLight || light.kind == DiskLight {
+				origin = light.AreaSample(rng.Float64(), rng.Float64())
+			}
+			direction := SphereSample(rng)
+			p := power
+			if light.kind == SpotLight {
+				factor := light.SpotFactor(direction)
+				if factor <= 0 {
+					continue
+				}
+				p = WeightColor(p, factor)
+			}
+			tracePhoton(scene, origin, direction, p, &global, &caustic)
+		}
+	}
+	maps.global = MakePhotonMap(global)
+	maps.caustic = MakePhotonMap(caustic)
+	return maps
+}
+
+// tracePhoton follows one photon through the scene, choosing at each hit
+// among the events ShadeHit blends, as pathTracer does, and storing it at
+// every diffuse hit after the first. Russian roulette by the surface's
+// albedo decides whether it bounces on.
+func tracePhoton(scene *Scene, origin Vector, direction Vector, power Color, global *[]Photon, caustic *[]Photon) {
+	rng := scene.rng
+	diffuse_bounces := 0
+	specular := false
+	for bounce := 0; bounce < maxPhotonBounces; bounce++ {
+		object, t := ClosestIntersection(scene, origin, direction, scene.Bias(origin), math.Inf(1))
+		if object == nil {
+			return
+		}
+		material := object.Material()
+		point := add(origin, scale(direction, t))
+		geometric := object.NormalAt(point)
+		if geometric == (Vector{}) {
+			return // volumes are lit directly but hold no photons
+		}
+		normal := geometric
+		if dot(normal, direction) > 0 {
+			normal = neg(normal)
+		}
+		albedo := material.ColorAt(object, point)
+		view := neg(direction)
+		cos_i := -dot(normal, normalize(direction))
+		origin = point
+
+		if material.transparency > 0 && rng.Float64() < material.transparency {
+			n1, n2 := 1.0, material.ior
+			if dot(geometric, direction) > 0 {
+				n1, n2 = material.ior, 1.0
+			}
+			next := ReflectRay(view, normal)
+			if T, ok := RefractRay(direction, normal, n1/n2); ok {
+				cos := cos_i
+				if n1 > n2 {
+					cos = -dot(normal, T)
+				}
+				if rng.Float64() >= Schlick(math.Pow((n1-n2)/(n1+n2), 2), cos) {
+					next = T
+				}
+			}
+			direction = next
+			specular = true
+			continue
+		}
+		mirror := 0.0
+		if material.model != "pbr" && material.reflective > 0 {
+			mirror = Schlick(material.reflective, cos_i)
+		} else if material.Mirrors() {
+			gloss := (1 - material.roughness) * (1 - material.roughness)
+			F := SchlickColor(material.F0(albedo), cos_i)
+			mirror = gloss * (F.r + F.g + F.b) / 3
+		}
+		if rng.Float64() < mirror {
+			if material.tinted {
+				power = MultiplyColors(power, material.tint)
+			}
+			direction = glossyDirection(rng, ReflectRay(view, normal), normal, material.roughness)
+			specular = true
+			continue
+		}
+
+		if diffuse_bounces == 0 && specular {
+			*caustic = append(*caustic, Photon{point, power})
+		} else if diffuse_bounces > 0 {
+			*global = append(*global, Photon{point, power})
+		}
+		diffuse := albedo
+		if material.model == "pbr" {
+			diffuse = WeightColor(albedo, 1-material.metallic)
+		}
+		survive := (diffuse.r + diffuse.g + diffuse.b) / 3
+		if rng.Float64() >= survive {
+			return
+		}
+		power = WeightColor(MultiplyColors(power, diffuse), 1/survive)
+		direction = CosineSample(rng, normal)
+		diffuse_bounces++
+		specular = false
+	}
+}
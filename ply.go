@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type plyProperty struct {
+	name       string
+	kind       string
+	list       bool
+	count_kind string // type of the length prefix of a list property
+}
+
+type plyElement struct {
+	name       string
+	count      int
+	properties []plyProperty
+}
+
+// LoadPLY reads the vertex positions and faces of an ASCII or binary
+// Stanford PLY file. Other properties and elements are skipped and
+// polygons are fan-triangulated.
+func LoadPLY(path string, material *Material) (Mesh, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Mesh{}, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	format, elements, err := readPLYHeader(r)
+	if err != nil {
+		return Mesh{}, fmt.Errorf("%s: %v", path, err)
+	}
+	var read func(kind string) (float64, error)
+	switch format {
+	case "ascii":
+		read = plyASCIIReader(r)
+	case "binary_little_endian":
+		read = plyBinaryReader(r, binary.LittleEndian)
+	case "binary_big_endian":
+		read = plyBinaryReader(r, binary.BigEndian)
+	default:
+		return Mesh{}, fmt.Errorf("%s: unknown format %q", path, format)
+	}
+
+	// No face has more corners than there are vertices, nor any other list
+	// more than plyMaxList entries, so a corrupt count fails here rather
+	// than in allocating it.
+	max_corners := 0
+	for _, e := range elements {
+		if e.name == "vertex" {
+			max_corners += e.count
+		}
+	}
+
+	var vertices []Vector
+	var faces [][3]int
+	for _, e := range elements {
+		for i := 0; i < e.count; i++ {
+			var xyz [3]float64
+			for _, p := range e.properties {
+				if p.list {
+					n, err := read(p.count_kind)
+					if err != nil {
+						return Mesh{}, fmt.Errorf("%s: %s %d: %v", path, e.name, i, err)
+					}
+					limit := plyMaxList
+					if e.name == "face" && (p.name == "vertex_indices" || p.name == "vertex_index") && max_corners < limit {
+						limit = max_corners
+					}
+					if !(n >= 0 && n <= float64(limit)) { // and not NaN
+						return Mesh{}, fmt.Errorf("%s: %s %d: %s of %v entries, want 0 to %d", path, e.name, i, p.name, n, limit)
+					}
+					idx := make([]int, int(n))
+					for j := range idx {
+						v, err := read(p.kind)
+						if err != nil {
+							return Mesh{}, fmt.Errorf("%s: %s %d: %v", path, e.name, i, err)
+						}
+						idx[j] = int(v)
+					}
+					if e.name == "face" && (p.name == "vertex_indices" || p.name == "vertex_index") {
+						for j := 1; j+1 < len(idx); j++ {
+							faces = append(faces, [3]int{idx[0], idx[j], idx[j+1]})
+						}
+					}
+					continue
+				}
+				v, err := read(p.kind)
+				if err != nil {
+					return Mesh{}, fmt.Errorf("%s: %s %d: %v", path, e.name, i, err)
+				}
+				if e.name == "vertex" {
+					switch p.name {
+					case "x":
+						xyz[0] = v
+					case "y":
+						xyz[1] = v
+					case "z":
+						xyz[2] = v
+					}
+				}
+			}
+			if e.name == "vertex" {
+				vertices = append(vertices, MakeVector(xyz[0], xyz[1], xyz[2]))
+			}
+		}
+	}
+
+	if len(faces) == 0 {
+		return Mesh{}, fmt.Errorf("%s: no faces (point clouds are not supported)", path)
+	}
+	for _, face := range faces {
+		for _, v := range face {
+			if v < 0 || v >= len(vertices) {
+				return Mesh{}, fmt.Errorf("%s: face index %d out of range", path, v)
+			}
+		}
+	}
+	return MakeMesh(vertices, faces, material), nil
+}
+
+func readPLYHeader(r *bufio.Reader) (string, []plyElement, error) {
+	line, err := r.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != "ply" {
+		return "", nil, fmt.Errorf("not a PLY file")
+	}
+	format := ""
+	var elements []plyElement
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", nil, fmt.Errorf("unterminated header")
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "format":
+			if len(fields) < 2 {
+				return "", nil, fmt.Errorf("malformed format line")
+			}
+			format = fields[1]
+		case "element":
+			if len(fields) != 3 {
+				return "", nil, fmt.Errorf("malformed element line %q", strings.TrimSpace(line))
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil || count < 0 {
+				return "", nil, fmt.Errorf("bad element count %q", fields[2])
+			}
+			elements = append(elements, plyElement{name: fields[1], count: count})
+		case "property":
+			if len(elements) == 0 {
+				return "", nil, fmt.Errorf("property before any element")
+			}
+			e := &elements[len(elements)-1]
+			if len(fields) == 5 && fields[1] == "list" {
+				e.properties = append(e.properties, plyProperty{name: fields[4], kind: fields[3], list: true, count_kind: fields[2]})
+			} else if len(fields) == 3 {
+				e.properties = append(e.properties, plyProperty{name: fields[2], kind: fields[1]})
+			} else {
+				return "", nil, fmt.Errorf("malformed property line %q", strings.TrimSpace(line))
+			}
+		case "end_header":
+			return format, elements, nil
+		}
+	}
+}
+
+func plyASCIIReader(r *bufio.Reader) func(kind string) (float64, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(bufio.ScanWords)
+	return func(kind string) (float64, error) {
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return 0, err
+			}
+			return 0, io.ErrUnexpectedEOF
+		}
+		return strconv.ParseFloat(scanner.Text(), 64)
+	}
+}
+
+// plyMaxList is the most entries LoadPLY reads into a list property.
+const plyMaxList = 1 << 20
+
+// plySizes holds the size in bytes of each binary PLY property type.
+var plySizes = map[string]int{
+	"char": 1, "int8": 1, "uchar": 1, "uint8": 1,
+	"short": 2, "int16": 2, "ushort": 2, "uint16": 2,
+	"int": 4, "int32": 4, "uint": 4, "uint32": 4, "float": 4, "float32": 4,
+	"double": 8, "float64": 8,
+}
+
+func plyBinaryReader(r *bufio.Reader, order binary.ByteOrder) func(kind string) (float64, error) {
+	var buf [8]byte
+	return func(kind string) (float64, error) {
+		size := plySizes[kind]
+		if size == 0 {
+			return 0, fmt.Errorf("unknown property type %q", kind)
+		}
+		if _, err := io.ReadFull(r, buf[:size]); err != nil {
+			return 0, err
+		}
+		b := buf[:size]
+		switch kind {
+		case "char", "int8":
+			return float64(int8(b[0])), nil
+		case "uchar", "uint8":
+			return float64(b[0]), nil
+		case "short", "int16":
+			return float64(int16(order.Uint16(b))), nil
+		case "ushort", "uint16":
+			return float64(order.Uint16(b)), nil
+		case "int", "int32":
+			return float64(int32(order.Uint32(b))), nil
+		case "uint", "uint32":
+			return float64(order.Uint32(b)), nil
+		case "float", "float32":
+			return float64(math.Float32frombits(order.Uint32(b))), nil
+		default:
+			return math.Float64frombits(order.Uint64(b)), nil
+		}
+	}
+}
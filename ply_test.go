@@ -0,0 +1,48 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadPLYListCounts(t *testing.T) {
+	const valid = `ply
+format ascii 1.0
+element vertex 4
+property float x
+property float y
+property float z
+element face 1
+property list uchar int vertex_indices
+end_header
+0 0 0
+1 0 0
+1 1 0
+0 1 0
+4 0 1 2 3
+`
+	tests := []struct {
+		name, old, new, want string
+	}{
+		{"valid.ply", "", "", ""},
+		{"negative.ply", "4 0 1 2 3", "-1 0 1 2 3", "face 0: vertex_indices of -1 entries, want 0 to 4"},
+		{"huge.ply", "4 0 1 2 3", "1e12 0 1 2 3", "face 0: vertex_indices of 1e+12 entries, want 0 to 4"},
+		{"nan.ply", "4 0 1 2 3", "nan 0 1 2 3", "face 0: vertex_indices of NaN entries, want 0 to 4"},
+		{"elements.ply", "element face 1", "element face -1", `bad element count "-1"`},
+	}
+	for _, test := range tests {
+		dir := writeScene(t, map[string]string{test.name: strings.Replace(valid, test.old, test.new, 1)})
+		path := filepath.Join(dir, test.name)
+		mesh, err := LoadPLY(path, nil)
+		if test.want == "" {
+			if err != nil || len(mesh.faces) != 2 {
+				t.Errorf("%s: got %d faces (%v), want the quad's two", test.name, len(mesh.faces), err)
+			}
+			continue
+		}
+		if want := path + ": " + test.want; err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %s", test.name, err, want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+	"sync"
+	"time"
+)
+
+// startProfiles starts writing a CPU profile and an execution trace to the
+// files named, if any, for go tool pprof and go tool trace. The function
+// it returns stops them and writes a heap profile to memprofile, if set.
+func startProfiles(cpuprofile string, memprofile string, trace_path string) (func(), error) {
+	var files []*os.File
+	stop := func() {
+		if trace_path != "" {
+			trace.Stop()
+		}
+		if cpuprofile != "" {
+			pprof.StopCPUProfile()
+		}
+		for _, f := range files {
+			if err := f.Close(); err != nil {
+				log.Print(err)
+			}
+		}
+		if memprofile != "" {
+			f, err := os.Create(memprofile)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			runtime.GC() // for up-to-date statistics
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				log.Print(err)
+			}
+			if err := f.Close(); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+	if cpuprofile != "" {
+		f, err := os.Create(cpuprofile)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if trace_path != "" {
+		f, err := os.Create(trace_path)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		files = append(files, f)
+		if err := trace.Start(f); err != nil {
+			trace_path = ""
+			stop()
+			return nil, err
+		}
+	}
+	return stop, nil
+}
+
+// rayKind sorts the rays Timings times.
+type rayKind int
+
+const (
+	primaryRays rayKind = iota // camera rays, to their first hit
+	shadowRays                 // shadow, connection and occlusion rays
+	rayKinds
+)
+
+// timedRays is how often Timings times a ray: reading the clock for every
+// one would cost about as much as tracing it.
+const timedRays = 16
+
+// Timings records where a run's time went, for -timings: the wall time of
+// building the scene, rendering and writing output, and the time each
+// kind of ray spent finding its intersection, summed over the workers, who
+// each time their own rays with a Timings of their own.
+type Timings struct {
+	build  time.Duration
+	render time.Duration
+	output time.Duration
+	lock   sync.Mutex // over rays, when merging
+	rays   [rayKinds]time.Duration
+	counts [rayKinds]int
+}
+
+// start returns the time now, for stop, for every timedRays-th ray of a
+// kind, or nothing otherwise or if t is nil, as it is when not timing.
+func (t *Timings) start(kind rayKind) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	t.counts[kind]++
+	if t.counts[kind]%timedRays != 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// stop counts the time since began for the timedRays rays it stands for.
+func (t *Timings) stop(kind rayKind, began time.Time) {
+	if t != nil && !began.IsZero() {
+		t.rays[kind] += timedRays * time.Since(began)
+	}
+}
+
+// merge adds a worker's ray times to t's.
+func (t *Timings) merge(worker *Timings) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for kind, d := range worker.rays {
+		t.rays[kind] += d
+	}
+}
+
+// Print writes the timings as a table. The ray times are totals over the
+// workers, so they can add up to more than the render.
+func (t *Timings) Print(w io.Writer) {
+	fmt.Fprintf(w, "build           %10v\n", t.build.Round(time.Millisecond))
+	fmt.Fprintf(w, "render          %10v\n", t.render.Round(time.Millisecond))
+	fmt.Fprintf(w, "  primary rays  %10v  (all workers)\n", t.rays[primaryRays].Round(time.Millisecond))
+	fmt.Fprintf(w, "  shadow rays   %10v  (all workers)\n", t.rays[shadowRays].Round(time.Millisecond))
+	fmt.Fprintf(w, "output          %10v\n", t.output.Round(time.Millisecond))
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Progress is told, as a render goes, the fraction of it done, the rays
+// traced so far and the time since it began.
+type Progress func(done float64, rays int64, elapsed time.Duration)
+
+// progressWidth is the number of characters in ConsoleProgress's bar, and
+// progressInterval the least time between its redraws.
+const (
+	progressWidth    = 40
+	progressInterval = 200 * time.Millisecond
+)
+
+// ConsoleProgress returns a Progress that redraws a bar on one line of w,
+// with the percentage done, the rays traced per second and the time left
+// at the rate so far, ending the line when the render is done.
+func ConsoleProgress(w io.Writer) Progress {
+	var last time.Duration
+	return func(done float64, rays int64, elapsed time.Duration) {
+		finished := done >= 1
+		if elapsed < last {
+			last = 0 // a new render in the same process
+		}
+		if !finished && elapsed-last < progressInterval {
+			return
+		}
+		last = elapsed
+		if finished {
+			last = 0
+		}
+		filled := int(done * progressWidth)
+		bar := strings.Repeat("#", filled) + strings.Repeat(" ", progressWidth-filled)
+		rate := float64(rays) / elapsed.Seconds()
+		left := time.Duration(float64(elapsed) * (1 - done) / done).Round(time.Second)
+		fmt.Fprintf(w, "\r[%s] %3.0f%% %8s rays/s  ETA %-8v", bar, 100*done, siPrefix(rate), left)
+		if finished {
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+// siPrefix formats a number with three significant digits and a k, M or G
+// suffix, as in 1.25M.
+func siPrefix(v float64) string {
+	for _, prefix := range []string{"", "k", "M", "G"} {
+		if v < 1000 || prefix == "G" {
+			return fmt.Sprintf("%.3g%s", v, prefix)
+		}
+		v /= 1000
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package main
+
+import "math"
+
+// Quadric is the surface
+//
+//	A x² + B y² + C z² + D xy + E yz + F xz + G x + H y + I z + J = 0
+//
+// clipped to the box between min and max. For example an ellipsoid with
+// radii a, b, c is {1/a², 1/b², 1/c², 0, 0, 0, 0, 0, 0, -1}, a paraboloid
+// opening up y is {1, 0, 1, 0, 0, 0, 0, -1, 0, 0} and a hyperboloid of one
+// sheet is {1, -1, 1, 0, 0, 0, 0, 0, 0, -1}.
+type Quadric struct {
+	q        Matrix4 // symmetric form: p^T q p = 0 for homogeneous p
+	min      Vector
+	max      Vector
+	material *Material
+}
+
+func MakeQuadric(coefficients [10]float64, min Vector, max Vector, material *Material) Quadric {
+	A, B, C, D, E, F, G, H, I, J := coefficients[0], coefficients[1], coefficients[2], coefficients[3], coefficients[4],
+		coefficients[5], coefficients[6], coefficients[7], coefficients[8], coefficients[9]
+	var q Quadric
+	q.q = Matrix4{
+		{A, D / 2, F / 2, G / 2},
+		{D / 2, B, E / 2, H / 2},
+		{F / 2, E / 2, C, I / 2},
+		{G / 2, H / 2, I / 2, J},
+	}
+	q.min = min
+	q.max = max
+	q.material = material
+	return q
+}
+
+// form evaluates u^T q v for homogeneous vectors with w components uw, vw.
+func (q *Quadric) form(u Vector, uw float64, v Vector, vw float64) float64 {
+	m := &q.q
+	qv := [4]float64{
+		m[0][0]*v.x + m[0][1]*v.y + m[0][2]*v.z + m[0][3]*vw,
+		m[1][0]*v.x + m[1][1]*v.y + m[1][2]*v.z + m[1][3]*vw,
+		m[2][0]*v.x + m[2][1]*v.y + m[2][2]*v.z + m[2][3]*vw,
+		m[3][0]*v.x + m[3][1]*v.y + m[3][2]*v.z + m[3][3]*vw,
+	}
+	return u.x*qv[0] + u.y*qv[1] + u.z*qv[2] + uw*qv[3]
+}
+
+func (q *Quadric) inside(p Vector) bool {
+	return p.x >= q.min.x && p.x <= q.max.x &&
+		p.y >= q.min.y && p.y <= q.max.y &&
+		p.z >= q.min.z && p.z <= q.max.z
+}
+
+func (q *Quadric) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	// Substituting origin + t*direction gives a*t² + b*t + c = 0.
+	a := q.form(direction, 0, direction, 0)
+	b := 2 * q.form(origin, 1, direction, 0)
+	c := q.form(origin, 1, origin, 1)
+	var roots []float64
+	if math.Abs(a) < 1e-12 {
+		if math.Abs(b) < 1e-12 {
+			return math.Inf(1), false
+		}
+		roots = []float64{-c / b}
+	} else {
+		roots = SolveQuadratic(a, b, c)
+	}
+	best_t := math.Inf(1)
+	for _, t := range roots {
+		if t_min <= t && t <= t_max && t < best_t && q.inside(add(origin, scale(direction, t))) {
+			best_t = t
+		}
+	}
+	return best_t, !math.IsInf(best_t, 1)
+}
+
+func (q *Quadric) NormalAt(point Vector) Vector {
+	// The gradient of p^T q p is 2 q p.
+	m := &q.q
+	return normalize(MakeVector(
+		m[0][0]*point.x+m[0][1]*point.y+m[0][2]*point.z+m[0][3],
+		m[1][0]*point.x+m[1][1]*point.y+m[1][2]*point.z+m[1][3],
+		m[2][0]*point.x+m[2][1]*point.y+m[2][2]*point.z+m[2][3],
+	))
+}
+
+func (q *Quadric) Material() *Material {
+	return q.material
+}
+
+// Bounds is the clip box, which is infinite for unclipped quadrics.
+func (q *Quadric) Bounds() AABB {
+	return AABB{q.min, q.max}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestQuadricIntersect(t *testing.T) {
+	inf := math.Inf(1)
+	unclipped := func(coefficients [10]float64) Quadric {
+		return MakeQuadric(coefficients, MakeVector(-inf, -inf, -inf), MakeVector(inf, inf, inf), nil)
+	}
+	sqrt2, sqrt3 := math.Sqrt(2), math.Sqrt(3)
+	tests := []struct {
+		name              string
+		quadric           Quadric
+		origin, direction Vector
+		t                 float64
+		normal            Vector
+	}{
+		// x²/4 + y² + z²/0.25 = 1, met at 0.5 up, where x = -√3
+		{"ellipsoid", unclipped([10]float64{0.25, 1, 4, 0, 0, 0, 0, 0, 0, -1}),
+			MakeVector(-5, 0.5, 0), MakeVector(1, 0, 0), 5 - sqrt3, normalize(MakeVector(-sqrt3/4, 0.5, 0))},
+		// y = x² + z², straight down onto x = 0.5, where the equation in t
+		// is linear
+		{"paraboloid", unclipped([10]float64{1, 0, 1, 0, 0, 0, 0, -1, 0, 0}),
+			MakeVector(0.5, 5, 0), MakeVector(0, -2, 0), 2.375, normalize(MakeVector(0.5, -0.5, 0))},
+		// x² - y² + z² = 1, met at 1 up, where x = -√2
+		{"hyperboloid", unclipped([10]float64{1, -1, 1, 0, 0, 0, 0, 0, 0, -1}),
+			MakeVector(-5, 1, 0), MakeVector(1, 0, 0), 5 - sqrt2, normalize(MakeVector(-sqrt2, -1, 0))},
+		// The same hyperboloid from inside its waist, along the axis of
+		// neither sheet
+		{"hyperboloid inside", unclipped([10]float64{1, -1, 1, 0, 0, 0, 0, 0, 0, -1}),
+			MakeVector(0, 0, 0), MakeVector(0, 0, 1), 1, MakeVector(0, 0, 1)},
+	}
+	for _, test := range tests {
+		got, ok := test.quadric.Intersect(test.origin, test.direction, 1e-6, inf)
+		if !ok || math.Abs(got-test.t) > 1e-9 {
+			t.Errorf("%s: hit at t = %v (%v), want %v", test.name, got, ok, test.t)
+			continue
+		}
+		point := add(test.origin, scale(test.direction, got))
+		if normal := test.quadric.NormalAt(point); norm(sub(normal, test.normal)) > 1e-9 {
+			t.Errorf("%s: normal %v, want %v", test.name, normal, test.normal)
+		}
+	}
+
+	// Clipping the paraboloid below where the ray meets it leaves nothing
+	// to hit, and clipping away the near side of the ellipsoid leaves the
+	// far side.
+	paraboloid := MakeQuadric([10]float64{1, 0, 1, 0, 0, 0, 0, -1, 0, 0}, MakeVector(-1, 0, -1), MakeVector(1, 0.2, 1), nil)
+	if got, ok := paraboloid.Intersect(MakeVector(0.5, 5, 0), MakeVector(0, -1, 0), 1e-6, inf); ok {
+		t.Errorf("clipped paraboloid: hit at t = %v, want a miss", got)
+	}
+	ellipsoid := MakeQuadric([10]float64{0.25, 1, 4, 0, 0, 0, 0, 0, 0, -1}, MakeVector(0, -1, -1), MakeVector(2, 1, 1), nil)
+	if got, ok := ellipsoid.Intersect(MakeVector(-5, 0.5, 0), MakeVector(1, 0, 0), 1e-6, inf); !ok || math.Abs(got-(5+sqrt3)) > 1e-9 {
+		t.Errorf("clipped ellipsoid: hit at t = %v (%v), want the far side at %v", got, ok, 5+sqrt3)
+	}
+}
@@ -1,16 +1,50 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"image"
+	"log"
 	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
-
-	"github.com/fogleman/gg"
+	"time"
 )
 
 type Canvas struct {
-	lock sync.Mutex
-	wg   sync.WaitGroup
-	ctx  *gg.Context
+	// lock is held for reading by the workers putting pixels, and for
+	// writing to take a preview of them.
+	lock          sync.RWMutex
+	width, height int
+	// radiance holds the linear colors of the pixels, row by row from the
+	// top, which encode converts for Image, and alpha their coverage. When
+	// transparent the background was left out, and radiance is
+	// premultiplied by alpha.
+	radiance    []Color
+	alpha       []float64
+	encode      func(Color) Color
+	transparent bool
+	// features, if set, are saved with the pixels in OpenEXR output.
+	features *Features
+	// preview, if set, is shown the canvas every preview_interval while it
+	// is drawn.
+	preview          Display
+	preview_interval time.Duration
+	preview_lock     sync.Mutex
+	last_preview     time.Time
+	previewing       bool
+}
+
+// MakeCanvas returns a blank Cw x Ch canvas, encoding pixels for the scene.
+func MakeCanvas(scene *Scene) *Canvas {
+	c := &Canvas{width: Cw, height: Ch, radiance: make([]Color, Cw*Ch), alpha: make([]float64, Cw*Ch), encode: scene.Encode, transparent: scene.alpha}
+	c.preview, c.preview_interval, c.last_preview = scene.preview, scene.preview_interval, time.Now()
+	return c
 }
 
 type Color struct {
@@ -26,28 +60,239 @@ type Vector struct {
 }
 
 type Sphere struct {
-	center     Vector
-	radius     float64
-	color      Color
-	specular   float64 // shininess
-	reflective float64
+	center   Vector
+	radius   float64
+	material *Material
+
+	// Intersection constants: radius², and dot(CO, CO) - radius² for rays
+	// from a fixed origin, such as the camera (see Scene.CacheOrigin).
+	radius_squared float64
+	has_origin     bool
+	origin         Vector
+	origin_c       float64
+}
+
+type Plane struct {
+	point     Vector
+	normal    Vector
+	material  *Material
+	tangent   Vector // tangent, bitangent and normal form an orthonormal basis
+	bitangent Vector
+}
+
+type Triangle struct {
+	v0       Vector
+	v1       Vector
+	v2       Vector
+	normal   Vector // per-face normal, from the winding of v0, v1, v2
+	material *Material
+}
+
+type Scene struct {
+	objects []Object
+	lights  []*Light
+	// accelerator selects the structure BuildAccelerator builds over
+	// objects: "bvh" (the default), "kdtree" or "grid".
+	accelerator string
+	accel       Compound
+	cameras     map[string]*Camera // named viewpoints, chosen with Camera
+	// path_samples is the number of paths per pixel traced by PathPainter,
+	// or 0 for Render's Whitted-style ray tracing.
+	path_samples int
+	// gi_samples, when Whitted ray tracing, is the number of rays each
+	// diffuse hit gathers indirect light along; 0 leaves it to the ambient
+	// light.
+	gi_samples int
+	// samples is the number of camera rays Render averages per pixel, each
+	// through a stratified point within it. 0 or 1 casts a single ray
+	// through the pixel center.
+	samples int
+	// max_samples, if above samples, lets Render sample adaptively: every
+	// pixel takes at least samples (and at least adaptiveMinimum) rays,
+	// then more while its estimate stays noisy, up to max_samples.
+	max_samples int
+	// integrator is "whitted" (the default) for recursive ray tracing,
+	// "path" and "bdpt" for PathPainter's unbiased path tracers, or "ao" for ambient
+	// occlusion alone, with occluders counted within ao_distance.
+	integrator  string
+	ao_distance float64
+	// photons, if set, are gathered at diffuse hits for indirect light and
+	// caustics, in place of gi_samples.
+	photons *PhotonMaps
+	// epsilon is how far past its origin a secondary ray starts looking
+	// for hits, so that it does not hit the surface it leaves. It grows by
+	// relative_epsilon of the origin's largest coordinate, where rounding
+	// errors in the hit point grow too.
+	epsilon          float64
+	relative_epsilon float64
+	// tonemap names the operator ToneMap applies before Encode.
+	tonemap string
+	// encoding and gamma select how Encode writes linear colors to the
+	// image.
+	encoding string
+	gamma    float64
+	// preview and preview_interval, if preview is set, have canvases
+	// shown there periodically while they render.
+	preview          Display
+	preview_interval time.Duration
+	// checkpoint, if set, is a file the tiles rendered so far are saved to
+	// every checkpoint_interval, from which a render killed before it is
+	// done can resume; see renderTiles.
+	checkpoint          string
+	checkpoint_interval time.Duration
+	resume              bool
+	// farm, if set, renders the tiles on other machines; see Farm.
+	farm *Farm
+	// gpu has Render try gpuRender first.
+	gpu bool
+	// tile_size is the side of the square tiles rendering is split into,
+	// or 0 for defaultTileSize.
+	tile_size int
+	// background is seen where Whitted rays escape the scene without an
+	// environment.
+	background Color
+	// alpha leaves out the background, seen and lighting nothing where
+	// camera rays miss, and records in each pixel's alpha the fraction of
+	// its rays that hit the scene, for compositing over other images.
+	alpha bool
+	// spectral has the path integrator trace a wavelength per sample
+	// rather than RGB; see SpectralRGB.
+	spectral bool
+	// max_radiance, if above 0, clamps each sample with ClampSample, and
+	// mean_groups above 1 has PathPainter take the median of the means of
+	// that many groups of each pixel's samples.
+	max_radiance float64
+	mean_groups  int
+	// seed seeds the random sampling from rng; renders with the same
+	// seed are identical. Each render worker has its own copy of the scene
+	// and so its own rng.
+	seed int64
+	rng  *rand.Rand
+	// rays counts the rays traced with the scene's rng, by one goroutine.
+	rays *int64
+	// timings, if set, times the rays traced with the scene's rng.
+	timings *Timings
+	// progress, if set, is told how far each render has got; see
+	// ConsoleProgress.
+	progress Progress
+	// environment, if set, surrounds the scene with distant light.
+	environment *Environment
+}
+
+// LightKind selects how a Light illuminates the scene.
+type LightKind int
+
+const (
+	AmbientLight LightKind = iota
+	PointLight
+	DirectionalLight
+	SpotLight
+	RectLight
+	DiskLight
+)
+
+func (k LightKind) String() string {
+	switch k {
+	case AmbientLight:
+		return "ambient"
+	case PointLight:
+		return "point"
+	case DirectionalLight:
+		return "directional"
+	case SpotLight:
+		return "spot"
+	case RectLight:
+		return "rect"
+	case DiskLight:
+		return "disk"
+	}
+	return fmt.Sprintf("LightKind(%d)", int(k))
 }
 
 type Light struct {
-	kind      string // TODO: change to enum
-	intensity float64
+	kind LightKind
+	// intensity is the light's color and brightness; its channels may
+	// exceed 1.
+	intensity Color
 	position  Vector
+	// direction points towards a directional light, but is the direction a
+	// spot light shines in.
 	direction Vector
+	// Spot lights are full strength within inner_angle of their direction
+	// and dark beyond outer_angle (both half-angles, in radians). Between
+	// the two the light fades as a power falloff of the fraction of the way
+	// from the outer to the inner edge.
+	inner_angle float64
+	outer_angle float64
+	falloff     float64
+	// Area lights are rectangles centered on position with edges edge_u
+	// and edge_v, or disks of the given radius facing
+	// direction. They shine to both sides, and shadow rays towards jittered
+	// points over them, samples rounded up to a square number, measure how
+	// much of the light is visible.
+	edge_u  Vector
+	edge_v  Vector
+	radius  float64
+	samples int
+	// Directional lights with an angular_diameter (in radians) above zero
+	// are disks in the sky, like the sun at about 0.5°, and shadow rays
+	// spread over it in the same way.
+	angular_diameter float64
 }
 
-func (c *Canvas) PutPixel(x int, y int, color Color) {
-	defer c.wg.Done()
+// PutPixel sets the pixel at canvas point (x, y). It takes no lock, as
+// workers each put the pixels of their own tiles, inside Paint.
+func (c *Canvas) PutPixel(x int, y int, color Color, alpha float64) {
 	i, j := ChangeCoord2D(x, y)
+	if j < 0 || j >= c.height {
+		return
+	}
+	c.radiance[j*c.width+i] = color
+	c.alpha[j*c.width+i] = alpha
+}
+
+// Paint runs paint, which puts pixels no other worker puts, and then
+// writes the preview if one is due.
+func (c *Canvas) Paint(paint func()) {
+	c.lock.RLock()
+	paint()
+	c.lock.RUnlock()
+	if c.preview == nil {
+		return
+	}
+	c.preview_lock.Lock()
+	due := !c.previewing && time.Since(c.last_preview) >= c.preview_interval
+	c.previewing = c.previewing || due
+	c.preview_lock.Unlock()
+	if !due {
+		return
+	}
 	c.lock.Lock()
-	c.ctx.SetPixel(i, j)
-	c.ctx.SetRGB(color.r, color.g, color.b)
-	c.ctx.Fill()
+	img := c.Image()
 	c.lock.Unlock()
+	c.preview(img)
+	c.preview_lock.Lock()
+	c.last_preview, c.previewing = time.Now(), false
+	c.preview_lock.Unlock()
+}
+
+// Image encodes the canvas's pixels for 8-bit output. Pixels never put
+// stay transparent black.
+func (c *Canvas) Image() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, c.width, c.height))
+	for k, color := range c.radiance {
+		alpha := c.alpha[k]
+		if alpha <= 0 {
+			continue
+		}
+		if alpha < 1 {
+			color = WeightColor(color, 1/alpha)
+		}
+		color = ClampColor(c.encode(color))
+		p := img.Pix[4*k : 4*k+4]
+		p[0], p[1], p[2], p[3] = uint8(color.r*255), uint8(color.g*255), uint8(color.b*255), uint8(alpha*255)
+	}
+	return img
 }
 
 func MakeVector(x float64, y float64, z float64) Vector {
@@ -58,14 +303,22 @@ func MakeVector(x float64, y float64, z float64) Vector {
 	return p
 }
 
+// MakeColor returns a linear color. Colors are not clamped as they are
+// computed, so light sums to values above 1 until ClampColor clamps it
+// for output.
 func MakeColor(r float64, g float64, b float64) Color {
 	var c Color
-	c.r = math.Max(math.Min(r, 1.0), 0.0)
-	c.g = math.Max(math.Min(g, 1.0), 0.0)
-	c.b = math.Max(math.Min(b, 1.0), 0.0)
+	c.r = r
+	c.g = g
+	c.b = b
 	return c
 }
 
+// ClampColor clamps each channel of a color to [0, 1].
+func ClampColor(c Color) Color {
+	return Color{math.Max(math.Min(c.r, 1.0), 0.0), math.Max(math.Min(c.g, 1.0), 0.0), math.Max(math.Min(c.b, 1.0), 0.0)}
+}
+
 func WeightColor(c Color, w float64) Color {
 	return MakeColor(c.r*w, c.g*w, c.b*w)
 }
@@ -74,17 +327,35 @@ func AddColors(c1 Color, c2 Color) Color {
 	return MakeColor(c1.r+c2.r, c1.g+c2.g, c1.b+c2.b)
 }
 
-func MakeSphere(center Vector, radius float64, color Color, specular float64, reflective float64) Sphere {
+func MakeSphere(center Vector, radius float64, material *Material) Sphere {
 	var s Sphere
 	s.center = center
 	s.radius = radius
-	s.color = color
-	s.specular = specular
-	s.reflective = reflective
+	s.material = material
+	s.radius_squared = radius * radius
 	return s
 }
 
-func MakeLight(kind string, intensity float64, position Vector, direction Vector) Light {
+func MakePlane(point Vector, normal Vector, material *Material) Plane {
+	var p Plane
+	p.point = point
+	p.normal = normalize(normal)
+	p.material = material
+	p.tangent, p.bitangent = perpendicularBasis(p.normal)
+	return p
+}
+
+func MakeTriangle(v0 Vector, v1 Vector, v2 Vector, material *Material) Triangle {
+	var t Triangle
+	t.v0 = v0
+	t.v1 = v1
+	t.v2 = v2
+	t.normal = normalize(cross(sub(v1, v0), sub(v2, v0)))
+	t.material = material
+	return t
+}
+
+func makeLight(kind LightKind, intensity Color, position Vector, direction Vector) Light {
 	var l Light
 	l.kind = kind
 	l.intensity = intensity
@@ -93,6 +364,80 @@ func MakeLight(kind string, intensity float64, position Vector, direction Vector
 	return l
 }
 
+// MakeAmbientLight returns a light reaching every point equally from all
+// directions, unshadowed.
+func MakeAmbientLight(intensity Color) Light {
+	return makeLight(AmbientLight, intensity, Vector{}, Vector{})
+}
+
+func MakePointLight(intensity Color, position Vector) Light {
+	return makeLight(PointLight, intensity, position, Vector{})
+}
+
+// MakeDirectionalLight returns a light infinitely far away in the given
+// direction.
+func MakeDirectionalLight(intensity Color, direction Vector) Light {
+	return makeLight(DirectionalLight, intensity, Vector{}, direction)
+}
+
+func MakeSpotLight(intensity Color, position Vector, direction Vector, inner_angle float64, outer_angle float64, falloff float64) Light {
+	l := makeLight(SpotLight, intensity, position, normalize(direction))
+	l.inner_angle = inner_angle
+	l.outer_angle = outer_angle
+	l.falloff = falloff
+	return l
+}
+
+// areaLightSamples is the default number of shadow rays per area light.
+const areaLightSamples = 16
+
+func MakeRectLight(intensity Color, center Vector, edge_u Vector, edge_v Vector) Light {
+	l := makeLight(RectLight, intensity, center, normalize(cross(edge_u, edge_v)))
+	l.edge_u = edge_u
+	l.edge_v = edge_v
+	l.samples = areaLightSamples
+	return l
+}
+
+func MakeDiskLight(intensity Color, center Vector, normal Vector, radius float64) Light {
+	l := makeLight(DiskLight, intensity, center, normalize(normal))
+	l.radius = radius
+	l.samples = areaLightSamples
+	return l
+}
+
+func MakeSunLight(intensity Color, direction Vector, angular_diameter float64) Light {
+	l := MakeDirectionalLight(intensity, normalize(direction))
+	l.angular_diameter = angular_diameter
+	l.samples = areaLightSamples
+	return l
+}
+
+// AreaSample maps a point (s, t) of the unit square onto the surface of an
+// area light.
+func (l *Light) AreaSample(s float64, t float64) Vector {
+	if l.kind == DiskLight {
+		a, b := perpendicularBasis(l.direction)
+		r, phi := l.radius*math.Sqrt(s), 2*math.Pi*t
+		return add(l.position, add(scale(a, r*math.Cos(phi)), scale(b, r*math.Sin(phi))))
+	}
+	return add(l.position, add(scale(l.edge_u, s-0.5), scale(l.edge_v, t-0.5)))
+}
+
+// SpotFactor returns the fraction of a spot light's intensity that shines
+// in the unit direction d.
+func (l *Light) SpotFactor(d Vector) float64 {
+	cos := dot(d, l.direction)
+	cos_inner, cos_outer := math.Cos(l.inner_angle), math.Cos(l.outer_angle)
+	if cos >= cos_inner {
+		return 1
+	}
+	if cos <= cos_outer {
+		return 0
+	}
+	return math.Pow((cos-cos_outer)/(cos_inner-cos_outer), l.falloff)
+}
+
 func ChangeCoord2D(cx int, cy int) (int, int) {
 	// Change coords from [-C/2, C/2] to [0, C]
 	return Cw/2 + cx, Ch/2 - cy
@@ -114,10 +459,22 @@ func mul(a Vector, b Vector) Vector {
 	return MakeVector(a.x*b.x, a.y*b.y, a.z*b.z)
 }
 
+func cross(a Vector, b Vector) Vector {
+	return MakeVector(a.y*b.z-a.z*b.y, a.z*b.x-a.x*b.z, a.x*b.y-a.y*b.x)
+}
+
+func scale(a Vector, k float64) Vector {
+	return MakeVector(a.x*k, a.y*k, a.z*k)
+}
+
 func neg(a Vector) Vector {
 	return MakeVector(-a.x, -a.y, -a.z)
 }
 
+func abs(a Vector) Vector {
+	return MakeVector(math.Abs(a.x), math.Abs(a.y), math.Abs(a.z))
+}
+
 func norm(a Vector) float64 {
 	return math.Sqrt(dot(a, a))
 }
@@ -127,153 +484,1138 @@ func normalize(a Vector) Vector {
 	return MakeVector(a.x/length, a.y/length, a.z/length)
 }
 
-const Vw, Vh = 1, 1
-const Cw, Ch = 1024, 1024
-const d = 1
+// perpendicularBasis returns two unit vectors that form an orthonormal
+// basis with the unit vector n.
+func perpendicularBasis(n Vector) (Vector, Vector) {
+	helper := MakeVector(1, 0, 0)
+	if math.Abs(n.x) > 0.9 {
+		helper = MakeVector(0, 1, 0)
+	}
+	tangent := normalize(cross(helper, n))
+	return tangent, cross(n, tangent)
+}
+
+// The canvas of Cw x Ch pixels maps onto a viewport Vw x Vh units across,
+// d units in front of the camera. main sets them from its flags before
+// anything is rendered, with Vw following the canvas's aspect ratio.
+var Vw, Vh = 1.0, 1.0
+var Cw, Ch = 1024, 1024
+var d = 1.0
 
 func main() {
-	O := MakeVector(0, 0, -3)
-	var canvas Canvas
-	canvas.ctx = gg.NewContext(Cw, Ch)
+	if len(os.Args) > 1 && os.Args[1] == "generate" {
+		render, err := generateCommand(os.Args[2:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		if render == nil {
+			return
+		}
+		os.Args = append(os.Args[:1], render...)
+	}
+	scene_path := flag.String("scene", "", "JSON, YAML or Lua scene file to render in place of the built-in scene, whose material, light and camera flags it ignores; see scenes/spheres.json and scenes/random.lua")
+	watch := flag.Bool("watch", false, "re-render the -scene each time it or a file it uses is saved, at -watch-scale of the resolution, until interrupted")
+	watch_scale := flag.Float64("watch-scale", 0.5, "fraction of -width and -height to render at with -watch, for quicker previews")
+	model_path := flag.String("model", "", "OBJ, STL, PLY, glTF or Bézier patch (.bpt) model to add to the scene")
+	heightmap_path := flag.String("heightmap", "", "grayscale image to add as terrain over the ground")
+	checker := flag.Float64("checker", 0, "checkerboard the ground plane with this many squares per unit; 0 keeps it plain")
+	noise := flag.String("noise", "", "noise texture for the spheres: marble or clouds")
+	bump := flag.Float64("bump", 0, "height of noise bumps on the ground plane; 0 keeps it flat")
+	pbr := flag.Bool("pbr", false, "shade the spheres with the metallic-roughness model")
+	metallic := flag.Float64("metallic", 0, "sphere metalness for -pbr, from 0 to 1")
+	roughness := flag.Float64("roughness", 0, "sphere roughness from 0 to 1, blurring reflections and, with -pbr, widening highlights")
+	glow := flag.Float64("glow", 0, "brightness of a glowing sphere added between the others; 0 leaves it out")
+	path_samples := flag.Int("path-samples", 0, "path trace with this many samples per pixel; 0 ray traces")
+	integrator := flag.String("integrator", "whitted", "light transport: whitted, path for unbiased path tracing or bdpt for bidirectional (16 samples unless -path-samples is set), or ao for ambient occlusion")
+	photons := flag.Int("photons", 0, "photons to trace from the lights for indirect light and caustics; 0 disables photon mapping")
+	glass := flag.Bool("glass", false, "make the middle sphere clear glass")
+	dispersion := flag.Float64("dispersion", 0, "Cauchy B coefficient of the -glass sphere in µm², splitting light into colors (0.0042 for crown glass)")
+	spectral := flag.Bool("spectral", false, "with -integrator path, trace a wavelength per sample instead of RGB")
+	gi_samples := flag.Int("gi-samples", 0, "rays per diffuse hit gathering one bounce of indirect light, for color bleeding; 0 disables")
+	ao_distance := flag.Float64("ao-distance", 1, "reach of ambient occlusion rays; 0 is unlimited")
+	denoise := flag.Bool("denoise", false, "filter the render with Open Image Denoise, guided by albedo and normal buffers")
+	oidn := flag.String("oidn", "oidnDenoise", "path to Open Image Denoise's oidnDenoise program, for -denoise")
+	ao_output := flag.String("ao-output", "", "also render an ambient occlusion pass to this PNG file")
+	anisotropy := flag.Float64("anisotropy", 0, "stretch of the -pbr sphere highlights, from -1 (across) to 1 (around)")
+	metal := flag.String("metal", "", "make the spheres polished metal: gold, copper, silver, aluminum or iron")
+	environment_path := flag.String("environment", "", "latitude-longitude .hdr or image to light the scene and fill the background")
+	spot := flag.Bool("spot", false, "add a spot light shining down on the middle sphere")
+	light_color := flag.String("light-color", "1,1,1", "color of the main light as r,g,b, such as 1,0.8,0.6 for warm light")
+	fill_color := flag.String("fill-color", "1,1,1", "color of the directional fill light as r,g,b")
+	light_size := flag.Float64("light-size", 0, "width of the main light; above 0 it becomes a square area light casting soft shadows")
+	sun_size := flag.Float64("sun-size", 0, "angular diameter in degrees of the directional light, softening its shadows; 0 keeps them sharp")
+	terrain_texture := flag.String("terrain-texture", "", "image to drape over the heightmap terrain")
+	terrain_normal_map := flag.String("terrain-normal-map", "", "tangent-space normal map image for the heightmap terrain")
+	samples := flag.Int("samples", 1, "jittered camera rays averaged per pixel, for anti-aliasing")
+	max_samples := flag.Int("max-samples", 0, "sample adaptively, adding rays to noisy pixels up to this many; 0 disables")
+	epsilon := flag.Float64("epsilon", 0.001, "distance secondary rays skip from their origin to avoid shadow acne")
+	relative_epsilon := flag.Float64("relative-epsilon", 1e-7, "growth of -epsilon per unit of distance of a ray's origin from the world origin, taken as its largest coordinate, for large scenes")
+	tonemap := flag.String("tonemap", "none", "tone mapping of bright colors before output: none, reinhard, aces or filmic")
+	encoding := flag.String("encoding", "linear", "how colors are written to the image: linear, srgb, or gamma for -gamma")
+	gamma := flag.Float64("gamma", 2.2, "display gamma for -encoding gamma")
+	max_radiance := flag.Float64("max-radiance", 0, "clamp each sample's brightest channel to this, removing fireflies at the cost of some bias; 0 disables")
+	mean_groups := flag.Int("median-of-means", 0, "with path tracing, split each pixel's samples into this many groups and take the median of their means")
+	output := flag.String("output", "out.png", "image to write: .png, .ppm, .jpg or .webp, or .exr, .hdr or .pfm for linear, unclamped colors")
+	alpha := flag.Bool("alpha", false, "make the background transparent, for .png, .webp and .exr output")
+	aovs := flag.Bool("aovs", false, "also write depth, normal, albedo, object ID and material ID passes, as layers of .exr output or otherwise as images beside it, and a list of the IDs")
+	width := flag.Int("width", 1024, "width of the image in pixels, an even number")
+	height := flag.Int("height", 1024, "height of the image in pixels, an even number")
+	plane_distance := flag.Float64("plane-distance", 1, "distance from the camera to the viewport, one unit high, that the image maps onto; more zooms in")
+	max_depth := flag.Int("max-depth", 3, "reflections and refractions followed recursively by the whitted integrator")
+	background := flag.String("background", "0,0,0", "color as r,g,b seen where rays escape the scene without an -environment")
+	threads := flag.Int("threads", 0, "rendering threads; 0 uses every CPU")
+	tile_size := flag.Int("tile-size", defaultTileSize, "side of the square tiles of pixels workers render in turn")
+	gpu := flag.Bool("gpu", false, "render on the GPU if this build has a backend (-tags opencl) and a device is available, and the scene uses only what its kernels support, or else on the CPU")
+	serve := flag.String("serve", "", "serve tiles of the scene to a -farm coordinator, listening on this address, such as :7070")
+	farm := flag.String("farm", "", "comma-separated addresses of -serve workers, started with the same flags, to render the tiles on")
+	checkpoint := flag.String("checkpoint", "", "file to save the render to as it goes, such as render.ckpt, removed when it is done")
+	checkpoint_interval := flag.Duration("checkpoint-interval", time.Minute, "time between -checkpoint saves")
+	resume := flag.Bool("resume", false, "continue the render saved in the -checkpoint file, started with the same flags")
+	bench := flag.Bool("bench", false, "render the fixed benchmark scenes instead, reporting their speed in Mrays/s")
+	cpuprofile := flag.String("cpuprofile", "", "write a CPU profile to this file, for go tool pprof")
+	memprofile := flag.String("memprofile", "", "write a heap profile to this file when done, for go tool pprof")
+	trace_path := flag.String("trace", "", "write an execution trace to this file, for go tool trace")
+	timings := flag.Bool("timings", false, "print the time taken to build the scene, trace primary and shadow rays, and write the output")
+	progress := flag.Bool("progress", true, "show a progress bar with the rays traced per second and the time left")
+	preview := flag.String("preview", "", "PNG file to write the partial image to while rendering, such as preview.png; in a browser the page's canvas is drawn instead")
+	preview_interval := flag.Duration("preview-interval", 5*time.Second, "time between -preview writes")
+	quality := flag.Int("quality", 90, "quality of .jpg and .webp output, from 1 to 100")
+	seed := flag.Int64("seed", 1, "seed for random sampling; the same seed renders the same image")
+	accelerator := flag.String("accel", "bvh", "acceleration structure: bvh, kdtree or grid; overrides the scene file's accel")
+	camera_name := flag.String("camera", "default", "scene camera to render: default, set by the camera flags, or overview")
+	eye := flag.String("eye", "0,0,-3", "camera position as x,y,z")
+	look_at := flag.String("look-at", "0,0,0", "point the camera looks at, as x,y,z")
+	projection := flag.String("projection", "perspective", "camera projection: perspective, orthographic, fisheye or panorama")
+	fov := flag.Float64("fov", 180, "fisheye field of view in degrees")
+	aperture := flag.Float64("aperture", 0, "lens radius for depth of field; 0 is a pinhole")
+	focal_distance := flag.Float64("focal-distance", 0, "distance to the plane in focus; defaults to the look-at point")
+	shutter := flag.Float64("shutter", 0, "time the shutter stays open, for motion blur")
+	camera_velocity := flag.String("camera-velocity", "0,0,0", "camera movement per unit time while the shutter is open, as x,y,z")
+	shift_x := flag.Float64("shift-x", 0, "horizontal lens shift, as a fraction of the viewport width")
+	shift_y := flag.Float64("shift-y", 0, "vertical lens shift, as a fraction of the viewport height")
+	stereo := flag.String("stereo", "", "render a stereo pair: separate (-output with _left and _right added) or side-by-side")
+	interaxial := flag.Float64("interaxial", 0.1, "distance between the stereo cameras")
+	flag.Parse()
+
+	stop_profiles, err := startProfiles(*cpuprofile, *memprofile, *trace_path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer stop_profiles()
+	if *threads > 0 {
+		runtime.GOMAXPROCS(*threads)
+	}
+	if *bench {
+		Bench(os.Stdout)
+		return
+	}
+	began := time.Now()
 
+	if *width <= 0 || *height <= 0 || *width%2 != 0 || *height%2 != 0 {
+		log.Fatalf("-width and -height must be positive and even, not %dx%d", *width, *height)
+	}
+	if *plane_distance <= 0 {
+		log.Fatalf("-plane-distance must be positive, not %v", *plane_distance)
+	}
+	Cw, Ch, d = *width, *height, *plane_distance
+	if *watch {
+		if *scene_path == "" || *serve != "" || *farm != "" {
+			log.Fatal("-watch needs a -scene to watch and no -serve or -farm")
+		}
+		if *watch_scale <= 0 || *watch_scale > 1 {
+			log.Fatalf("-watch-scale must be above 0 and at most 1, not %v", *watch_scale)
+		}
+		// Keep the sizes even, for Render's packets.
+		Cw = 2 * int(math.Max(1, math.Round(*watch_scale*float64(*width)/2)))
+		Ch = 2 * int(math.Max(1, math.Round(*watch_scale*float64(*height)/2)))
+	}
+	Vw = Vh * float64(Cw) / float64(Ch)
+
+	position, err := parseVector(*eye)
+	if err != nil {
+		log.Fatal(err)
+	}
+	target, err := parseVector(*look_at)
+	if err != nil {
+		log.Fatal(err)
+	}
+	velocity, err := parseVector(*camera_velocity)
+	if err != nil {
+		log.Fatal(err)
+	}
+	camera := MakeCamera(position, target, MakeVector(0, 1, 0))
+	camera.shutter = *shutter
+	camera.velocity = velocity
+	camera.shift_x = *shift_x
+	camera.shift_y = *shift_y
+	camera.projection = *projection
+	camera.fov = *fov * math.Pi / 180
+	camera.aperture = *aperture
+	if *focal_distance > 0 {
+		camera.focal_distance = *focal_distance
+	}
 	// Define scene.
-	s1 := MakeSphere(MakeVector(0, -1, 3), 1, MakeColor(1.0, 0, 0), 500, 0.2)
-	s2 := MakeSphere(MakeVector(2, 0, 4), 1, MakeColor(0., 0., 1.0), 500, 0.3)
-	s3 := MakeSphere(MakeVector(-2, 0, 4), 1, MakeColor(0., 1.0, 0.), 10, 0.4)
-	s4 := MakeSphere(MakeVector(0, -5001, 0), 5000, MakeColor(1.0, 1.0, 0), 1000, 0.5)
-	spheres := []*Sphere{&s1, &s2, &s3, &s4}
-
-	l1 := MakeLight("ambient", 0.2, MakeVector(0, 0, 0), MakeVector(0, 0, 0))
-	l2 := MakeLight("point", 0.6, MakeVector(2, 1, 0), MakeVector(0, 0, 0))
-	l3 := MakeLight("directional", 0.2, MakeVector(0, 0, 0), MakeVector(1, 4, 4))
+	red := MakeMaterial(MakeColor(1.0, 0, 0), 500, 0.2)
+	blue := MakeMaterial(MakeColor(0., 0., 1.0), 500, 0.3)
+	green := MakeMaterial(MakeColor(0., 1.0, 0.), 10, 0.4)
+	yellow := MakeMaterial(MakeColor(1.0, 1.0, 0), 1000, 0.5)
+	for _, m := range []*Material{&red, &blue, &green} {
+		if *metal != "" {
+			tint, ok := metalTints[*metal]
+			if !ok {
+				log.Fatalf("unknown metal %q", *metal)
+			}
+			if *pbr {
+				*m = MakePBRMaterial(tint, 1, *roughness)
+			} else {
+				*m = MakeMetal(tint)
+			}
+		} else if *pbr {
+			*m = MakePBRMaterial(m.color, *metallic, *roughness)
+		}
+		m.roughness = *roughness
+		m.anisotropy = *anisotropy
+		switch *noise {
+		case "":
+		case "marble":
+			marble := MakeMarbleTexture(m.color, MakeColor(1, 1, 1), 2, 4, 6)
+			m.texture = &marble
+		case "clouds":
+			clouds := MakeCloudTexture(m.color, MakeColor(1, 1, 1), 2, 6)
+			m.texture = &clouds
+		default:
+			log.Fatalf("unknown noise texture %q", *noise)
+		}
+	}
+	if *glass {
+		red = MakeMaterial(MakeColor(1, 1, 1), 500, 0)
+		red.transparency = 0.9
+		red.ior = 1.5
+		red.dispersion = *dispersion
+	}
+	if *checker > 0 {
+		checks := MakeCheckerTexture(yellow.color, MakeColor(0.1, 0.1, 0.1), *checker)
+		yellow.texture = &checks
+	}
+	if *bump > 0 {
+		ripples := MakeCloudTexture(MakeColor(0, 0, 0), MakeColor(1, 1, 1), 4, 4)
+		yellow.bump = &ripples
+		yellow.bump_height = *bump
+	}
+	s1 := MakeSphere(MakeVector(0, -1, 3), 1, &red)
+	s2 := MakeSphere(MakeVector(2, 0, 4), 1, &blue)
+	s3 := MakeSphere(MakeVector(-2, 0, 4), 1, &green)
+	p1 := MakePlane(MakeVector(0, -1, 0), MakeVector(0, 1, 0), &yellow)
+	objects := []Object{&s1, &s2, &s3, &p1}
+
+	key_color, err := parseVector(*light_color)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sky_color, err := parseVector(*fill_color)
+	if err != nil {
+		log.Fatal(err)
+	}
+	l1 := MakeAmbientLight(MakeColor(0.2, 0.2, 0.2))
+	l2 := MakePointLight(Color{0.6 * key_color.x, 0.6 * key_color.y, 0.6 * key_color.z}, MakeVector(2, 1, 0))
+	if *light_size > 0 {
+		l2 = MakeRectLight(l2.intensity, l2.position, MakeVector(*light_size, 0, 0), MakeVector(0, 0, *light_size))
+	}
+	l3 := MakeDirectionalLight(Color{0.2 * sky_color.x, 0.2 * sky_color.y, 0.2 * sky_color.z}, MakeVector(1, 4, 4))
+	if *sun_size > 0 {
+		l3 = MakeSunLight(l3.intensity, l3.direction, *sun_size*math.Pi/180)
+	}
 	lights := []*Light{&l1, &l2, &l3}
+	if *spot {
+		l4 := MakeSpotLight(MakeColor(0.8, 0.8, 0.8), MakeVector(0, 3, 1), MakeVector(0, -4, 2), 8*math.Pi/180, 16*math.Pi/180, 1)
+		lights = append(lights, &l4)
+	}
+
+	overview := MakeCamera(MakeVector(0, 8, -2), MakeVector(0, -1, 3.5), MakeVector(0, 1, 0))
+	flag_cameras := map[string]*Camera{"default": &camera, "overview": &overview}
+	cameras := flag_cameras
+	var scene_files []string
+	// withSceneCameras returns the cameras with those of the scene file
+	// in place of any of the same names.
+	withSceneCameras := func(file_cameras map[string]*Camera) map[string]*Camera {
+		merged := map[string]*Camera{}
+		for _, from := range []map[string]*Camera{flag_cameras, file_cameras} {
+			for name, c := range from {
+				merged[name] = c
+			}
+		}
+		return merged
+	}
+	accel_set := false
+	flag.Visit(func(f *flag.Flag) {
+		accel_set = accel_set || f.Name == "accel"
+	})
+	// withSceneAccel returns the accelerator the scene file chooses, unless
+	// -accel was given.
+	withSceneAccel := func(file_accel string) string {
+		if accel_set || file_accel == "" {
+			return *accelerator
+		}
+		return file_accel
+	}
+	chosen_accel := *accelerator
+	if *scene_path != "" {
+		var file_cameras map[string]*Camera
+		var file_accel string
+		objects, lights, file_cameras, file_accel, scene_files, err = LoadSceneFile(*scene_path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		cameras = withSceneCameras(file_cameras)
+		chosen_accel = withSceneAccel(file_accel)
+	}
+	file_objects := len(objects) // the rest are added by flags
+
+	scene := Scene{objects: objects, lights: lights, accelerator: chosen_accel, cameras: cameras, path_samples: *path_samples, samples: *samples, max_samples: *max_samples}
+	scene.seed, scene.rng, scene.rays = *seed, newRNG(), new(int64)
+	if *progress {
+		scene.progress = ConsoleProgress(os.Stderr)
+	}
+	scene.max_radiance = *max_radiance
+	scene.mean_groups = *mean_groups
+	scene.epsilon = *epsilon
+	scene.relative_epsilon = *relative_epsilon
+	scene.ao_distance = *ao_distance
+	scene.gi_samples = *gi_samples
+	switch *integrator {
+	case "whitted", "path", "bdpt", "ao":
+		scene.integrator = *integrator
+	default:
+		log.Fatalf("unknown integrator %q", *integrator)
+	}
+	if *spectral && scene.integrator != "path" {
+		log.Fatal("-spectral needs -integrator path")
+	}
+	scene.spectral = *spectral
+	scene.alpha = *alpha
+	if *preview != "" {
+		scene.preview = FileDisplay(*preview)
+	}
+	scene.preview_interval = *preview_interval
+	if browserDisplay != nil {
+		scene.preview = browserDisplay
+	}
+	switch scene.accelerator {
+	case "bvh", "kdtree", "grid":
+	default:
+		log.Fatalf("unknown accelerator %q", scene.accelerator)
+	}
+	scene.tile_size = *tile_size
+	scene.gpu = *gpu
+	if *gpu && gpuRender == nil {
+		log.Print("no GPU backend in this build; rendering on the CPU")
+	}
+	scene.checkpoint, scene.checkpoint_interval, scene.resume = *checkpoint, *checkpoint_interval, *resume
+	switch *tonemap {
+	case "none", "reinhard", "aces", "filmic":
+		scene.tonemap = *tonemap
+	default:
+		log.Fatalf("unknown tone mapping %q", *tonemap)
+	}
+	switch *encoding {
+	case "linear", "srgb", "gamma":
+		scene.encoding = *encoding
+	default:
+		log.Fatalf("unknown encoding %q", *encoding)
+	}
+	if *gamma <= 0 {
+		log.Fatalf("gamma must be positive, not %v", *gamma)
+	}
+	scene.gamma = *gamma
+	background_color, err := parseVector(*background)
+	if err != nil {
+		log.Fatal(err)
+	}
+	scene.background = Color{background_color.x, background_color.y, background_color.z}
+	if *environment_path != "" {
+		scene.environment, err = LoadEnvironment(*environment_path)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *glow > 0 {
+		lamp := MakeMaterial(MakeColor(1, 0.9, 0.7), -1, 0)
+		lamp.emission = WeightColor(lamp.color, *glow)
+		bulb := MakeSphere(MakeVector(0, -0.6, 1.6), 0.4, &lamp)
+		scene.objects = append(scene.objects, &bulb)
+	}
+	if *model_path != "" {
+		gray := MakeMaterial(MakeColor(0.8, 0.8, 0.8), 100, 0.1)
+		meshes, err := LoadModel(*model_path, &gray)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for i := range meshes {
+			scene.objects = append(scene.objects, meshes[i].Faces()...)
+		}
+	}
+
+	if *heightmap_path != "" {
+		grass := MakeMaterial(MakeColor(0.4, 0.7, 0.3), -1, 0)
+		if *terrain_texture != "" {
+			texture, err := LoadImageTexture(*terrain_texture)
+			if err != nil {
+				log.Fatal(err)
+			}
+			grass.texture = &texture
+		}
+		if *terrain_normal_map != "" {
+			normal_map, err := LoadImageTexture(*terrain_normal_map)
+			if err != nil {
+				log.Fatal(err)
+			}
+			grass.normal_map = &normal_map
+		}
+		terrain, err := LoadHeightmap(*heightmap_path, MakeVector(-6, -1, 0), MakeVector(12, 1.5, 12), &grass)
+		if err != nil {
+			log.Fatal(err)
+		}
+		scene.objects = append(scene.objects, terrain.Faces()...)
+	}
+
+	scene.BuildAccelerator()
+	if *photons > 0 {
+		scene.photons = TracePhotons(&scene, *photons)
+	}
+	if *serve != "" {
+		log.Fatal(ServeFarm(*serve, &scene))
+	}
+	if *farm != "" {
+		if scene.farm, err = DialFarm(strings.Split(*farm, ",")); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var timing Timings
+	timing.build = time.Since(began)
+	if *timings {
+		scene.timings = &timing
+		defer timing.Print(os.Stderr)
+	}
+
+	max_recursion_depth := *max_depth // for recursive raytracing of reflections
+
+	selected, err := scene.Camera(*camera_name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch *stereo {
+	case "", "separate", "side-by-side":
+	default:
+		log.Fatalf("unknown stereo layout %q", *stereo)
+	}
+	for _, path := range []string{*output, *ao_output} {
+		if path == "" {
+			continue
+		}
+		format, err := imageFormat(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if format == "webp" && browserDisplay == nil {
+			// Fail now rather than after the render.
+			if _, err := exec.LookPath(webpEncoder); err != nil {
+				log.Fatalf("%s: %v", path, err)
+			}
+		}
+	}
+	save := func(canvas *Canvas, path string) error {
+		defer func(began time.Time) { timing.output += time.Since(began) }(time.Now())
+		if browserDisplay != nil {
+			// There are no files to write in a browser.
+			browserDisplay(canvas.Image())
+			return nil
+		}
+		return canvas.Save(path, *quality)
+	}
 
-	max_recursion_depth := 3 // for recursive raytracing of reflections
+	render := func(camera *Camera) *Canvas {
+		defer func(began time.Time) { timing.render += time.Since(began) }(time.Now())
+		canvas := Render(&scene, camera, max_recursion_depth)
+		if *denoise {
+			if err := Denoise(canvas, &scene, camera, *oidn); err != nil {
+				log.Print(err) // keep the noisy render
+			}
+		}
+		if *aovs {
+			features := RenderFeatures(&scene, camera)
+			canvas.features = &features
+		}
+		return canvas
+	}
+	// write renders the images asked for and saves them.
+	write := func() error {
+		if *ao_output != "" {
+			ao := scene
+			ao.integrator = "ao"
+			rendering := time.Now()
+			canvas := Render(&ao, selected, max_recursion_depth)
+			timing.render += time.Since(rendering)
+			if err := save(canvas, *ao_output); err != nil {
+				return err
+			}
+		}
+		if *stereo == "" {
+			return save(render(selected), *output)
+		}
+		left, right := selected.StereoPair(*interaxial)
+		left_canvas := render(&left)
+		right_canvas := render(&right)
+		if *stereo == "separate" {
+			if err := save(left_canvas, stereoPath(*output, "_left")); err != nil {
+				return err
+			}
+			return save(right_canvas, stereoPath(*output, "_right"))
+		}
+		return save(SideBySide(left_canvas, right_canvas), *output)
+	}
+	if err := write(); err != nil {
+		log.Fatal(err)
+	}
+	if !*watch {
+		return
+	}
+
+	log.Printf("watching %s for changes", *scene_path)
+	Watch(scene_files, func() []string {
+		objects, lights, file_cameras, file_accel, files, err := LoadSceneFile(*scene_path)
+		if err != nil {
+			log.Print(err) // keep the last render until the file is fixed
+			return files
+		}
+		scene.objects = append(objects, scene.objects[file_objects:]...)
+		file_objects = len(objects)
+		scene.lights = lights
+		scene.cameras = withSceneCameras(file_cameras)
+		scene.accelerator = withSceneAccel(file_accel)
+		scene.BuildAccelerator()
+		if *photons > 0 {
+			scene.photons = TracePhotons(&scene, *photons)
+		}
+		if selected, err = scene.Camera(*camera_name); err != nil {
+			log.Print(err)
+			return files
+		}
+		if err := write(); err != nil {
+			log.Print(err)
+			return files
+		}
+		log.Printf("rendered %s", *output)
+		return files
+	})
+}
+
+// Render draws the scene as seen by the camera.
+func Render(scene *Scene, camera *Camera, max_recursion_depth int) *Canvas {
+	canvas := MakeCanvas(scene)
+	if scene.gpu && gpuRender != nil {
+		err := gpuRender(scene, camera, canvas, max_recursion_depth)
+		if err == nil {
+			return canvas
+		}
+		log.Printf("gpu: %v; rendering on the CPU", err)
+		canvas = MakeCanvas(scene)
+	}
+	scene.CacheOrigin(camera.position)
+	renderTiles(scene, camera, canvas, max_recursion_depth)
+	return canvas
+}
 
-	// Draw scene.
-	for x := -Cw / 2; x < Cw/2; x++ {
-		for y := -Ch / 2; y < Ch/2; y++ {
-			D := CanvasToViewPort(x, y) // TODO: Add support for camera rotation (left-multiply by rotation matrix)
-			canvas.wg.Add(1)
-			func(spheres []*Sphere, lights []*Light, O Vector, D Vector, t_min float64, t_max float64, r int, x int, y int) {
-				color := TraceRay(spheres, lights, O, D, t_min, t_max, r)
-				canvas.PutPixel(x, y, color)
-			}(spheres, lights, O, D, 1, math.Inf(1), max_recursion_depth, x, y)
+// tilePainter is called by each worker of a render, with its own copy of
+// the scene, for the function it puts the pixels of a tile with.
+type tilePainter func(worker *Scene) func(tile Tile)
+
+// painter returns the tilePainter for the scene's integrator, which puts
+// pixels on the canvas: PathPainter's for path tracing, or else one
+// tracing rays recursively with ShadeHit.
+func painter(scene *Scene, camera *Camera, canvas *Canvas, max_recursion_depth int) tilePainter {
+	if scene.integrator == "path" || scene.integrator == "bdpt" || (scene.path_samples > 0 && scene.integrator != "ao") {
+		return PathPainter(scene, camera, canvas, max_recursion_depth)
+	}
+	samples := scene.samples
+	if samples < 1 {
+		samples = 1
+	}
+	adaptive := scene.max_samples > samples
+	if adaptive && samples < adaptiveMinimum {
+		samples = adaptiveMinimum
+		if samples > scene.max_samples {
+			samples = scene.max_samples
 		}
 	}
 
-	canvas.wg.Wait()
-	canvas.ctx.SavePNG("out.png")
+	// Trace primary rays in 2x2 packets.
+	return func(scene *Scene) func(tile Tile) {
+		var samplers [packetSize]Sampler
+		for i := range samplers {
+			samplers[i] = MakeSampler(scene.rng, samples)
+		}
+		return func(tile Tile) {
+			for x := tile.x0; x < tile.x1; x += 2 {
+				for y := tile.y0; y < tile.y1; y += 2 {
+					var estimates [packetSize]pixelEstimate
+					scene.seedPixel(x, y, 0)
+					for i := range samplers {
+						samplers[i].StartPixel()
+					}
+					for s := 0; s < samples; s++ {
+						var O, D [packetSize]Vector
+						var covered [packetSize]bool
+						for i := range D {
+							O[i], D[i], covered[i] = samplers[i].CameraRay(camera, x+i%2, y+i/2, s, samples > 1)
+						}
+						began := scene.timings.start(primaryRays)
+						objects, ts := ClosestIntersectionPacket(scene, O, D, 1, math.Inf(1))
+						scene.timings.stop(primaryRays, began)
+						for i := range D {
+							var c Color
+							if objects[i] != nil && covered[i] {
+								estimates[i].hits++
+							}
+							if scene.alpha && objects[i] == nil {
+								// the background is left out
+							} else if covered[i] && scene.integrator == "ao" {
+								c = ShadeOcclusion(scene, O[i], D[i], objects[i], ts[i])
+							} else if covered[i] {
+								c = ShadeHit(scene, O[i], D[i], objects[i], ts[i], max_recursion_depth)
+							}
+							estimates[i].add(scene.ClampSample(c))
+						}
+					}
+					for i := range estimates {
+						if adaptive {
+							scene.seedPixel(x+i%2, y+i/2, refineStream)
+							estimates[i].refine(scene, camera, x+i%2, y+i/2, max_recursion_depth)
+						}
+						canvas.PutPixel(x+i%2, y+i/2, estimates[i].mean(), estimates[i].alpha(scene))
+					}
+				}
+			}
+		}
+	}
 }
 
-func TraceRay(spheres []*Sphere, lights []*Light, origin Vector, direction Vector, t_min float64, t_max float64, recursion_depth int) Color {
-	best_sphere, best_t := ClosestIntersection(spheres, origin, direction, t_min, t_max)
+// Bias returns the t_min for a secondary ray that starts at point along a
+// unit direction. Rays along longer ones, such as shadow rays to a point
+// light, divide it by their length to skip the same distance.
+func (s *Scene) Bias(point Vector) float64 {
+	extent := math.Max(math.Abs(point.x), math.Max(math.Abs(point.y), math.Abs(point.z)))
+	return s.epsilon + s.relative_epsilon*extent
+}
+
+func TraceRay(scene *Scene, origin Vector, direction Vector, t_min float64, t_max float64, recursion_depth int) Color {
+	best_object, best_t := ClosestIntersection(scene, origin, direction, t_min, t_max)
+	return ShadeHit(scene, origin, direction, best_object, best_t, recursion_depth)
+}
 
-	if best_sphere == nil {
-		return MakeColor(0.0, 0.0, 0.0) // default background color
+// ShadeHit computes the color seen along a ray given its closest hit, which
+// is nil if the ray escaped the scene.
+func ShadeHit(scene *Scene, origin Vector, direction Vector, best_object Primitive, best_t float64, recursion_depth int) Color {
+	if best_object == nil {
+		if scene.environment != nil {
+			return scene.environment.Sample(direction)
+		}
+		return scene.background
 	}
 
 	// Lighting
+	material := best_object.Material()
 	t := MakeVector(best_t, best_t, best_t)
 	intersection_pt := add(origin, mul(t, direction))
-	normal := normalize(sub(intersection_pt, best_sphere.center))
-	intensity := Lighting(spheres, lights, intersection_pt, normal, neg(direction), best_sphere.specular)
-	local_color := WeightColor(best_sphere.color, intensity)
+	// Shade the side the ray hit: a ray leaving a closed surface, or
+	// striking the back of a plane or triangle, sees the normal flipped
+	normal := best_object.NormalAt(intersection_pt)
+	leaving := dot(normal, direction) > 0
+	if leaving {
+		normal = neg(normal)
+	}
+	normal = material.ShadingNormal(best_object, intersection_pt, normal)
+	albedo := material.ColorAt(best_object, intersection_pt)
+	var local_color Color
+	if material.model == "pbr" {
+		local_color = LightingPBR(scene, best_object, intersection_pt, normal, neg(direction), material, albedo)
+	} else {
+		intensity, highlight := Lighting(scene, intersection_pt, normal, neg(direction), material.specular)
+		if material.tinted {
+			local_color = AddColors(MultiplyColors(albedo, intensity), MultiplyColors(material.tint, highlight))
+		} else {
+			local_color = MultiplyColors(albedo, AddColors(intensity, highlight))
+		}
+	}
+	local_color = AddColors(local_color, material.emission)
+
+	// Diffuse interreflection, sampled by one random bounce when path
+	// tracing, estimated from photons, gathered over gi_samples rays, or
+	// otherwise light from the environment
+	diffuse := albedo
+	if material.model == "pbr" {
+		diffuse = WeightColor(albedo, 1-material.metallic)
+	}
+	if scene.path_samples > 0 && recursion_depth > 0 && normal != (Vector{}) {
+		indirect := TraceRay(scene, intersection_pt, CosineSample(scene.rng, normal), scene.Bias(intersection_pt), math.Inf(1), recursion_depth-1)
+		local_color = AddColors(local_color, MultiplyColors(diffuse, indirect))
+	} else if scene.photons != nil && normal != (Vector{}) {
+		indirect := scene.photons.global.Irradiance(intersection_pt, normal, photonNeighbors, photonRadius)
+		caustics := scene.photons.caustic.Irradiance(intersection_pt, normal, causticNeighbors, causticRadius)
+		local_color = AddColors(local_color, MultiplyColors(diffuse, AddColors(indirect, caustics)))
+	} else if scene.gi_samples > 0 && recursion_depth > 0 && normal != (Vector{}) {
+		local_color = AddColors(local_color, MultiplyColors(diffuse, GatherIndirect(scene, intersection_pt, normal, scene.gi_samples)))
+	} else if scene.environment != nil && scene.path_samples == 0 && normal != (Vector{}) {
+		local_color = AddColors(local_color, MultiplyColors(diffuse, scene.environment.Irradiance(normal)))
+	}
 
-	// Reflections
-	r := best_sphere.reflective
-	if recursion_depth <= 0 || r <= 0 {
+	transparency, ior := material.transparency, material.ior
+	if recursion_depth <= 0 || (material.reflective <= 0 && transparency <= 0 && !material.Mirrors()) {
 		return local_color
 	}
 	R := ReflectRay(neg(direction), normal)
-	reflected_color := TraceRay(spheres, lights, intersection_pt, R, 0.001, math.Inf(1), recursion_depth-1)
+	reflected_color := TraceGlossy(scene, intersection_pt, R, normal, material.roughness, recursion_depth-1)
+	cos_i := -dot(normal, normalize(direction))
 
+	// Refraction, split with reflection by the Fresnel reflectance of the
+	// interface. Dispersive materials refract each channel at its own
+	// index, fanning white light out into colored fringes.
+	if transparency > 0 {
+		refract := func(ior float64) Color {
+			n1, n2 := 1.0, ior // entering
+			if leaving {
+				n1, n2 = ior, 1.0
+			}
+			T, ok := RefractRay(direction, normal, n1/n2)
+			if !ok {
+				return reflected_color // total internal reflection
+			}
+			cos := cos_i
+			if n1 > n2 {
+				cos = -dot(normal, T) // Schlick needs the angle on the thinner side
+			}
+			F := Schlick(math.Pow((n1-n2)/(n1+n2), 2), cos)
+			refracted_color := TraceRay(scene, intersection_pt, T, scene.Bias(intersection_pt), math.Inf(1), recursion_depth-1)
+			return AddColors(WeightColor(reflected_color, F), WeightColor(refracted_color, 1-F))
+		}
+		var through Color
+		if material.dispersion == 0 {
+			through = refract(ior)
+		} else {
+			for i, lambda := range channelWavelengths {
+				through = AddColors(through, onlyChannel(refract(material.IOR(lambda)), i))
+			}
+		}
+		local_color = AddColors(WeightColor(local_color, 1-transparency), WeightColor(through, transparency))
+	}
+
+	// PBR reflections, fading out as the surface roughens and its
+	// highlight spreads
+	if material.Mirrors() {
+		gloss := (1 - material.roughness) * (1 - material.roughness)
+		F := SchlickColor(material.F0(albedo), cos_i)
+		return AddColors(local_color, WeightColor(MultiplyColors(reflected_color, F), gloss))
+	}
+
+	// Reflections, with reflective as the reflectance at normal incidence
+	if material.reflective <= 0 {
+		return local_color
+	}
+	r := Schlick(material.reflective, cos_i)
+	if material.tinted {
+		reflected_color = MultiplyColors(reflected_color, material.tint)
+	}
 	return AddColors(WeightColor(local_color, (1-r)), WeightColor(reflected_color, r))
 }
 
-func ClosestIntersection(spheres []*Sphere, origin Vector, direction Vector, t_min float64, t_max float64) (*Sphere, float64) {
+// Schlick approximates the Fresnel reflectance of a surface from its
+// reflectance f0 at normal incidence and the cosine of the angle of
+// incidence.
+func Schlick(f0 float64, cos_i float64) float64 {
+	return f0 + (1-f0)*math.Pow(1-cos_i, 5)
+}
+
+// Camera returns the scene camera with the given name.
+func (s *Scene) Camera(name string) (*Camera, error) {
+	if camera, ok := s.cameras[name]; ok {
+		return camera, nil
+	}
+	var names []string
+	for n := range s.cameras {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return nil, fmt.Errorf("no camera %q in the scene (have %s)", name, strings.Join(names, ", "))
+}
+
+// OriginCacher is implemented by objects that can precompute parts of their
+// intersection for rays from a fixed origin.
+type OriginCacher interface {
+	CacheOrigin(origin Vector)
+}
+
+// CacheOrigin prepares the scene's objects, and those nested in groups,
+// instances and CSG, for rays from origin, such as the camera position
+// shared by every primary ray. The objects are shared by every worker and
+// keep only the last origin, so it must not be called during a render; rays
+// from any other origin, as from the other camera of a stereo pair or
+// through another instance of a shared group, work the terms out afresh.
+func (s *Scene) CacheOrigin(origin Vector) {
+	for _, object := range s.objects {
+		cacheOrigin(object, origin)
+	}
+}
+
+func cacheOrigin(object Object, origin Vector) {
+	if cacher, ok := object.(OriginCacher); ok {
+		cacher.CacheOrigin(origin)
+	}
+}
+
+// BuildAccelerator builds the acceleration structure over the scene's
+// objects. It must be called again after objects are added or moved; the
+// structures inside instances are kept, so only the top level is rebuilt.
+func (s *Scene) BuildAccelerator() {
+	objects := cullObjects(s.objects)
+	switch s.accelerator {
+	case "kdtree":
+		kd := MakeKDTree(objects)
+		s.accel = &kd
+	case "grid":
+		grid := MakeGrid(objects)
+		s.accel = &grid
+	default:
+		bvh := MakeBVH(objects)
+		s.accel = &bvh
+	}
+}
+
+// ClosestIntersectionPacket finds the closest hit for a packet of coherent
+// rays, in one traversal when the accelerator supports it.
+func ClosestIntersectionPacket(scene *Scene, origins [packetSize]Vector, directions [packetSize]Vector, t_min float64, t_max float64) ([packetSize]Primitive, [packetSize]float64) {
+	if packets, ok := scene.accel.(PacketTracer); ok {
+		*scene.rays += packetSize
+		return packets.HitPacket(origins, directions, t_min, t_max)
+	}
+	var objects [packetSize]Primitive
+	var ts [packetSize]float64
+	for i, direction := range directions {
+		objects[i], ts[i] = ClosestIntersection(scene, origins[i], direction, t_min, t_max)
+	}
+	return objects, ts
+}
+
+func ClosestIntersection(scene *Scene, origin Vector, direction Vector, t_min float64, t_max float64) (Primitive, float64) {
+	*scene.rays++
+	if scene.accel != nil {
+		return scene.accel.Hit(origin, direction, t_min, t_max)
+	}
+
 	best_t := t_max
-	var best_sphere *Sphere
-	best_sphere = nil
+	var best_object Primitive
 
-	for _, sphere := range spheres {
-		t1, t2 := IntersectRaySphere(origin, direction, *sphere)
-		if t1 < best_t && t_min <= t1 && t1 <= t_max {
-			best_sphere = sphere
-			best_t = t1
-		}
-		if t2 < best_t && t_min <= t2 && t2 <= t_max {
-			best_sphere = sphere
-			best_t = t2
+	for _, object := range scene.objects {
+		if compound, ok := object.(Compound); ok {
+			if p, t := compound.Hit(origin, direction, t_min, best_t); p != nil {
+				best_object = p
+				best_t = t
+			}
+		} else if t, ok := object.Intersect(origin, direction, t_min, best_t); ok {
+			best_object = object.(Primitive)
+			best_t = t
 		}
 	}
-	return best_sphere, best_t
+	return best_object, best_t
 }
 
-func IntersectRaySphere(origin Vector, direction Vector, sphere Sphere) (float64, float64) {
-	// NOTE: This math only works for spheres...
-	// TODO: make this adaptable for any arbitrary object
-	r := sphere.radius
+func IntersectRaySphere(origin Vector, direction Vector, sphere *Sphere) (float64, float64) {
 	CO := sub(origin, sphere.center)
 
-	// Solve quadratic
-	// TODO: Add caching
+	// Solve quadratic, in half-b form so the 2s and 4 cancel
 	a := dot(direction, direction)
-	b := 2 * dot(CO, direction)
-	c := dot(CO, CO) - r*r
-	discrim := b*b - 4*a*c
+	half_b := dot(CO, direction)
+	var c float64
+	if sphere.has_origin && origin == sphere.origin {
+		c = sphere.origin_c
+	} else {
+		c = dot(CO, CO) - sphere.radius_squared
+	}
+	discrim := half_b*half_b - a*c
 	if discrim < 0 {
 		return math.Inf(1), math.Inf(1)
 	}
-	t1 := (-b + math.Sqrt(discrim)) / (2 * a)
-	t2 := (-b - math.Sqrt(discrim)) / (2 * a)
+	root := math.Sqrt(discrim)
+	inv_a := 1 / a
+	t1 := (-half_b + root) * inv_a
+	t2 := (-half_b - root) * inv_a
 	return t1, t2
 }
 
+// CacheOrigin precomputes the terms of the sphere's intersection that only
+// depend on the ray origin, for the many rays that share it.
+func (s *Sphere) CacheOrigin(origin Vector) {
+	CO := sub(origin, s.center)
+	s.has_origin = true
+	s.origin = origin
+	s.origin_c = dot(CO, CO) - s.radius_squared
+}
+
+func IntersectRayPlane(origin Vector, direction Vector, plane Plane) float64 {
+	denom := dot(plane.normal, direction)
+	if math.Abs(denom) < 1e-9 {
+		return math.Inf(1) // ray is parallel to the plane
+	}
+	return dot(sub(plane.point, origin), plane.normal) / denom
+}
+
+func IntersectRayTriangle(origin Vector, direction Vector, triangle Triangle) float64 {
+	return intersectTriangle(origin, direction, triangle.v0, triangle.v1, triangle.v2)
+}
+
+func intersectTriangle(origin Vector, direction Vector, v0 Vector, v1 Vector, v2 Vector) float64 {
+	// Möller–Trumbore: solve origin + t*direction = v0 + u*e1 + v*e2 directly.
+	const epsilon = 1e-9
+	e1 := sub(v1, v0)
+	e2 := sub(v2, v0)
+	p := cross(direction, e2)
+	det := dot(e1, p)
+	if math.Abs(det) < epsilon {
+		return math.Inf(1) // ray is parallel to the triangle
+	}
+	inv_det := 1 / det
+	s := sub(origin, v0)
+	u := dot(s, p) * inv_det
+	if u < 0 || u > 1 {
+		return math.Inf(1)
+	}
+	q := cross(s, e1)
+	v := dot(direction, q) * inv_det
+	if v < 0 || u+v > 1 {
+		return math.Inf(1)
+	}
+	return dot(e2, q) * inv_det
+}
+
+// barycentric returns the weights of v1 and v2 for a point in the plane of
+// the triangle v0, v1, v2; the weight of v0 is one minus their sum.
+func barycentric(point Vector, v0 Vector, v1 Vector, v2 Vector) (float64, float64) {
+	e1, e2, p := sub(v1, v0), sub(v2, v0), sub(point, v0)
+	d11, d12, d22 := dot(e1, e1), dot(e1, e2), dot(e2, e2)
+	d1p, d2p := dot(e1, p), dot(e2, p)
+	det := d11*d22 - d12*d12
+	if det == 0 {
+		return 0, 0
+	}
+	return (d22*d1p - d12*d2p) / det, (d11*d2p - d12*d1p) / det
+}
+
+func (s *Sphere) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	t1, t2 := IntersectRaySphere(origin, direction, s)
+	return nearestIn(t_min, t_max, t1, t2)
+}
+
+func (s *Sphere) NormalAt(point Vector) Vector {
+	return normalize(sub(point, s.center))
+}
+
+func (s *Sphere) Material() *Material {
+	return s.material
+}
+
+// UVAt maps longitude to u and latitude to v, both in [0, 1], with v = 1
+// at the top of the sphere.
+func (s *Sphere) UVAt(point Vector) (float64, float64) {
+	n := s.NormalAt(point)
+	u := 0.5 + math.Atan2(n.x, n.z)/(2*math.Pi)
+	v := 0.5 + math.Asin(math.Max(-1, math.Min(1, n.y)))/math.Pi
+	return u, v
+}
+
+func (s *Sphere) Bounds() AABB {
+	r := MakeVector(s.radius, s.radius, s.radius)
+	return AABB{sub(s.center, r), add(s.center, r)}
+}
+
+func (s *Sphere) Spans(origin Vector, direction Vector) []Span {
+	t1, t2 := IntersectRaySphere(origin, direction, s)
+	if math.IsInf(t1, 1) {
+		return nil
+	}
+	return spansFromHits(s, []float64{t2, t1})
+}
+
+func (p *Plane) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	return nearestIn(t_min, t_max, IntersectRayPlane(origin, direction, *p))
+}
+
+func (p *Plane) NormalAt(point Vector) Vector {
+	return p.normal
+}
+
+func (p *Plane) Material() *Material {
+	return p.material
+}
+
+// UVAt returns the distances from the plane's point along its tangent and
+// bitangent, so textures repeat every unit across the plane.
+func (p *Plane) UVAt(point Vector) (float64, float64) {
+	offset := sub(point, p.point)
+	return dot(offset, p.tangent), dot(offset, p.bitangent)
+}
+
+func (tr *Triangle) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	return nearestIn(t_min, t_max, IntersectRayTriangle(origin, direction, *tr))
+}
+
+func (tr *Triangle) NormalAt(point Vector) Vector {
+	return tr.normal
+}
+
+func (tr *Triangle) Material() *Material {
+	return tr.material
+}
+
+// UVAt returns the barycentric weights of v1 and v2 at the point.
+func (tr *Triangle) UVAt(point Vector) (float64, float64) {
+	return barycentric(point, tr.v0, tr.v1, tr.v2)
+}
+
+func (tr *Triangle) Bounds() AABB {
+	return AABB{tr.v0, tr.v0}.Extend(tr.v1).Extend(tr.v2)
+}
+
 func ReflectRay(ray Vector, normal Vector) Vector {
 	k := 2 * dot(normal, ray)
 	return sub(mul(MakeVector(k, k, k), normal), ray)
 }
 
-func Lighting(spheres []*Sphere, lights []*Light, point Vector, normal Vector, reflection Vector, specular float64) float64 {
-	intensity := 0.
-	for _, light := range lights {
-		if light.kind == "ambient" {
-			intensity += light.intensity
+// RefractRay bends direction through a surface by Snell's law, where eta
+// is the ratio of the indices of refraction on either side and normal faces
+// against direction. It returns false on total internal reflection.
+func RefractRay(direction Vector, normal Vector, eta float64) (Vector, bool) {
+	D := normalize(direction)
+	cos_i := -dot(normal, D)
+	k := 1 - eta*eta*(1-cos_i*cos_i)
+	if k < 0 {
+		return Vector{}, false
+	}
+	return add(scale(D, eta), scale(normal, eta*cos_i-math.Sqrt(k))), true
+}
+
+// Lighting returns the light reaching a point, as diffuse and ambient light
+// and as specular highlight.
+func Lighting(scene *Scene, point Vector, normal Vector, reflection Vector, specular float64) (Color, Color) {
+	var intensity, highlight Color
+	add_light := func(sum *Color, light Color, k float64) {
+		sum.r += light.r * k
+		sum.g += light.g * k
+		sum.b += light.b * k
+	}
+	for _, light := range scene.lights {
+		if light.kind == AmbientLight {
+			add_light(&intensity, light.intensity, 1)
 		} else {
-			var L Vector
-			var t_max float64
-			if light.kind == "point" {
-				L = sub(light.position, point)
-				t_max = 1
-			} else { // directional
-				L = light.direction
-				t_max = math.Inf(1)
+			L, strength, ok := IncidentLight(scene, light, point)
+			if !ok {
+				continue
 			}
 
-			// Shadows
-			shadow_sphere, _ := ClosestIntersection(spheres, point, L, 0.001, t_max)
-			if shadow_sphere != nil {
+			// Volumes scatter light from every direction equally.
+			if normal == (Vector{}) {
+				add_light(&intensity, strength, 1)
 				continue
 			}
 
 			// Diffusion
 			N := normalize(normal)
-			L = normalize(L)
-			intensity += light.intensity * math.Max(0, dot(N, L))
+			add_light(&intensity, strength, math.Max(0, dot(N, L)))
 
 			// Specular
 			if specular != -1 {
 				R := normalize(ReflectRay(L, N))
 				V := normalize(reflection)
-				intensity += light.intensity * math.Pow(math.Max(0, dot(R, V)), specular)
+				add_light(&highlight, strength, math.Pow(math.Max(0, dot(R, V)), specular))
 			}
 		}
 	}
 
-	return intensity
+	return intensity, highlight
+}
+
+// IncidentLight returns the unit direction from a point towards a point,
+// spot or directional light and the intensity arriving from it, and false
+// if the light is shadowed there or the point lies outside its cone.
+func IncidentLight(scene *Scene, light *Light, point Vector) (Vector, Color, bool) {
+	if light.kind == RectLight || light.kind == DiskLight || (light.kind == DirectionalLight && light.angular_diameter > 0) {
+		return incidentAreaLight(scene, light, point)
+	}
+	var L Vector
+	var t_max float64
+	strength := light.intensity
+	if light.kind == PointLight || light.kind == SpotLight {
+		L = sub(light.position, point)
+		t_max = 1
+	} else { // directional
+		L = light.direction
+		t_max = math.Inf(1)
+	}
+	if light.kind == SpotLight {
+		factor := light.SpotFactor(normalize(neg(L)))
+		if factor <= 0 {
+			return Vector{}, Color{}, false
+		}
+		strength = WeightColor(strength, factor)
+	}
+
+	// Shadows
+	began := scene.timings.start(shadowRays)
+	shadow_object, _ := ClosestIntersection(scene, point, L, scene.Bias(point)/norm(L), t_max)
+	scene.timings.stop(shadowRays, began)
+	if shadow_object != nil {
+		return Vector{}, Color{}, false
+	}
+	return normalize(L), strength, true
+}
+
+// incidentAreaLight treats an area light as a point light at its center,
+// or a sun as a directional light along its axis, dimmed by the fraction of
+// jittered shadow rays that reach the light. Partly hidden lights give the
+// penumbrae of soft shadows, which widen with distance from the occluder.
+func incidentAreaLight(scene *Scene, light *Light, point Vector) (Vector, Color, bool) {
+	n := int(math.Ceil(math.Sqrt(float64(light.samples))))
+	cos_max := math.Cos(light.angular_diameter / 2)
+	rng := scene.rng
+	visible := 0
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			s := (float64(i) + rng.Float64()) / float64(n)
+			t := (float64(j) + rng.Float64()) / float64(n)
+			var L Vector
+			t_max := 1.0
+			if light.kind == DirectionalLight {
+				L = coneDirection(light.direction, cos_max, s, t)
+				t_max = math.Inf(1)
+			} else {
+				L = sub(light.AreaSample(s, t), point)
+			}
+			began := scene.timings.start(shadowRays)
+			shadow_object, _ := ClosestIntersection(scene, point, L, scene.Bias(point)/norm(L), t_max)
+			scene.timings.stop(shadowRays, began)
+			if shadow_object == nil {
+				visible++
+			}
+		}
+	}
+	if visible == 0 {
+		return Vector{}, Color{}, false
+	}
+	L := light.direction
+	if light.kind != DirectionalLight {
+		L = normalize(sub(light.position, point))
+	}
+	return L, WeightColor(light.intensity, float64(visible)/float64(n*n)), true
 }
 
-func CanvasToViewPort(x int, y int) Vector {
-	return MakeVector(float64(x)*Vw/Cw, float64(y)*Vh/Ch, d)
+func CanvasToViewPort(x float64, y float64) Vector {
+	return MakeVector(x*Vw/float64(Cw), y*Vh/float64(Ch), d)
 }
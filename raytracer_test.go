@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestShadowBias(t *testing.T) {
+	// A small sphere just above the ground shadows it from a light far
+	// overhead, however far the light and so however long the shadow ray.
+	ball := MakeSphere(MakeVector(0, 0.1, 0), 0.05, nil)
+	scene := &Scene{objects: []Object{&ball}, rays: new(int64)}
+	scene.epsilon, scene.relative_epsilon = 0.001, 1e-7
+	for _, height := range []float64{10, 1000, 1e5} {
+		light := MakePointLight(MakeColor(1, 1, 1), MakeVector(0, height, 0))
+		if _, _, lit := IncidentLight(scene, &light, MakeVector(0, 0, 0)); lit {
+			t.Errorf("light %v up: the point under the ball is lit", height)
+		}
+		if _, _, lit := IncidentLight(scene, &light, MakeVector(1, 0, 0)); !lit {
+			t.Errorf("light %v up: a point in the open is shadowed", height)
+		}
+	}
+}
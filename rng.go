@@ -0,0 +1,57 @@
+package main
+
+import "math/rand"
+
+// newRNG returns a random source for a scene's rng, which all its sampling
+// draws from. Render's painters reseed it from the scene's seed and
+// the coordinates of each pixel (or packet of pixels) before sampling it,
+// and TracePhotons before tracing, so the same seed gives the same image
+// whatever order the pixels are rendered in, and whichever worker renders
+// them.
+func newRNG() *rand.Rand {
+	return rand.New(&splitMix{})
+}
+
+// Streams seeding a scene's rng for work other than a pixel's primary
+// samples.
+const (
+	refineStream = iota + 1
+	photonStream
+	featureStream
+)
+
+// seedPixel reseeds the scene's rng for pixel (x, y) of the given stream.
+func (s *Scene) seedPixel(x int, y int, stream int) {
+	h := uint64(s.seed)
+	for _, v := range [3]int{x, y, stream} {
+		h = mix64(h ^ uint64(int64(v)))
+	}
+	s.rng.Seed(int64(h))
+}
+
+// splitMix is the SplitMix64 generator: one word of state, so reseeding it
+// for every pixel costs nothing, unlike the default source.
+type splitMix struct {
+	state uint64
+}
+
+func (s *splitMix) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+func (s *splitMix) Uint64() uint64 {
+	s.state += 0x9e3779b97f4a7c15
+	return mix64(s.state)
+}
+
+func (s *splitMix) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// mix64 is SplitMix64's finalizer, which scrambles every bit of its input
+// into every bit of its output.
+func mix64(z uint64) uint64 {
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Sampler dimensions: the 2D point in the pixel and on the lens, and the 1D
+// shutter time, choice of light and wavelength.
+const (
+	pixelDimension = iota
+	lensDimension
+	timeDimension
+	lightDimension
+	wavelengthDimension
+	samplerDimensions
+)
+
+// Sampler hands out the n samples of one pixel stratified in each
+// dimension, so they cover the pixel, lens and shutter interval evenly
+// instead of clumping as independent random draws do. 2D dimensions lay
+// the samples over a grid of cells as square as n allows, one to a cell,
+// and 1D dimensions over n equal intervals. Every dimension visits its
+// strata in its own random order, so the pixel position of a sample says
+// nothing about its lens position or time.
+type Sampler struct {
+	n      int
+	cols   int
+	rows   int
+	strata [samplerDimensions][]int
+	rng    *rand.Rand
+}
+
+func MakeSampler(rng *rand.Rand, n int) Sampler {
+	var s Sampler
+	s.n = n
+	s.rng = rng
+	s.cols = int(math.Ceil(math.Sqrt(float64(n))))
+	s.rows = (n + s.cols - 1) / s.cols
+	for d := range s.strata {
+		size := n
+		if d == pixelDimension || d == lensDimension {
+			size = s.cols * s.rows
+		}
+		s.strata[d] = make([]int, size)
+	}
+	s.StartPixel()
+	return s
+}
+
+// StartPixel shuffles the strata afresh for the next pixel, so the order
+// depends only on the rng's seed and not on the pixels before. When n does
+// not fill the grid the samples take a random subset of its cells, which
+// keeps each one uniform over the square.
+func (s *Sampler) StartPixel() {
+	for _, strata := range s.strata {
+		for i := range strata {
+			strata[i] = i
+		}
+		s.rng.Shuffle(len(strata), func(i, j int) { strata[i], strata[j] = strata[j], strata[i] })
+	}
+}
+
+// Get2D returns sample i in [0, 1)² of a 2D dimension.
+func (s *Sampler) Get2D(dimension int, i int) (float64, float64) {
+	cell := s.strata[dimension][i%s.n]
+	return (float64(cell%s.cols) + s.rng.Float64()) / float64(s.cols), (float64(cell/s.cols) + s.rng.Float64()) / float64(s.rows)
+}
+
+// Get1D returns sample i in [0, 1) of a 1D dimension.
+func (s *Sampler) Get1D(dimension int, i int) float64 {
+	return (float64(s.strata[dimension][i%s.n]) + s.rng.Float64()) / float64(s.n)
+}
+
+// CameraRay returns camera ray i through pixel (x, y). With jitter the ray
+// passes through the sample's point of the pixel rather than its center.
+func (s *Sampler) CameraRay(camera *Camera, x int, y int, i int, jitter bool) (Vector, Vector, bool) {
+	dx, dy := 0.0, 0.0
+	if jitter {
+		dx, dy = s.Get2D(pixelDimension, i)
+		dx, dy = dx-0.5, dy-0.5
+	}
+	lens_u, lens_v := s.Get2D(lensDimension, i)
+	return camera.SampledRay(float64(x)+dx, float64(y)+dy, lens_u, lens_v, s.Get1D(timeDimension, i))
+}
@@ -0,0 +1,138 @@
+package main
+
+import "math"
+
+// Polygonal objects expose their vertices so that spatial splits can clip
+// the polygon itself rather than its bounding box.
+type Polygonal interface {
+	Vertices() []Vector
+}
+
+func (tr *Triangle) Vertices() []Vector {
+	return []Vector{tr.v0, tr.v1, tr.v2}
+}
+
+func (f *MeshFace) Vertices() []Vector {
+	face := f.mesh.faces[f.index]
+	return []Vector{f.mesh.vertices[face[0]], f.mesh.vertices[face[1]], f.mesh.vertices[face[2]]}
+}
+
+// spatialSplit bins the node's box itself along each axis, clipping each
+// reference into every bin it straddles, and returns the cheapest split
+// plane. Unlike an object split, references crossing the plane end up on
+// both sides.
+func spatialSplit(refs []bvhRef, box AABB) (float64, int, float64) {
+	best_cost, best_axis, best_split := math.Inf(1), -1, 0.0
+	for axis := 0; axis < 3; axis++ {
+		lo, hi := component(box.min, axis), component(box.max, axis)
+		if hi <= lo {
+			continue
+		}
+		width := (hi - lo) / bvhBins
+		var bins [bvhBins]AABB
+		var entries, exits [bvhBins]int
+		for k := range bins {
+			bins[k] = EmptyAABB()
+		}
+		for _, ref := range refs {
+			first := bvhBinOf(component(ref.bounds.min, axis), lo, hi)
+			last := bvhBinOf(component(ref.bounds.max, axis), lo, hi)
+			for k := first; k <= last; k++ {
+				slab_lo, slab_hi := lo+float64(k)*width, lo+float64(k+1)*width
+				bins[k] = bins[k].Union(clipRef(ref, axis, slab_lo, slab_hi))
+			}
+			entries[first]++
+			exits[last]++
+		}
+
+		// right_box[k] and right_count[k] cover bins k and above.
+		var right_box [bvhBins]AABB
+		var right_count [bvhBins]int
+		right := EmptyAABB()
+		count := 0
+		for k := bvhBins - 1; k > 0; k-- {
+			right = right.Union(bins[k])
+			count += exits[k]
+			right_box[k], right_count[k] = right, count
+		}
+		left := EmptyAABB()
+		count = 0
+		for k := 1; k < bvhBins; k++ {
+			left = left.Union(bins[k-1])
+			count += entries[k-1]
+			if count == 0 || right_count[k] == 0 {
+				continue
+			}
+			cost := left.SurfaceArea()*float64(count) + right_box[k].SurfaceArea()*float64(right_count[k])
+			if cost < best_cost {
+				best_cost, best_axis, best_split = cost, axis, lo+float64(k)*width
+			}
+		}
+	}
+	return best_cost, best_axis, best_split
+}
+
+// partitionSpatial splits the references at the plane, clipping those that
+// cross it into both halves.
+func partitionSpatial(refs []bvhRef, axis int, split float64) ([]bvhRef, []bvhRef) {
+	var left, right []bvhRef
+	for _, ref := range refs {
+		lo, hi := component(ref.bounds.min, axis), component(ref.bounds.max, axis)
+		if hi <= split {
+			left = append(left, ref)
+			continue
+		}
+		if lo >= split {
+			right = append(right, ref)
+			continue
+		}
+		below := clipRef(ref, axis, math.Inf(-1), split)
+		above := clipRef(ref, axis, split, math.Inf(1))
+		if !below.Finite() && !above.Finite() {
+			left = append(left, ref) // lost to rounding; keep it whole
+			continue
+		}
+		if below.Finite() {
+			left = append(left, bvhRef{ref.object, below})
+		}
+		if above.Finite() {
+			right = append(right, bvhRef{ref.object, above})
+		}
+	}
+	return left, right
+}
+
+// clipRef returns the bounds of the part of a reference between a and b
+// along the axis.
+func clipRef(ref bvhRef, axis int, a float64, b float64) AABB {
+	slab := ref.bounds
+	setComponent(&slab.min, axis, math.Max(component(slab.min, axis), a))
+	setComponent(&slab.max, axis, math.Min(component(slab.max, axis), b))
+	if polygon, ok := ref.object.(Polygonal); ok {
+		return clipPolygon(polygon.Vertices(), axis, a, b).Intersection(slab)
+	}
+	return slab
+}
+
+// clipPolygon bounds the part of a convex polygon between the planes a and
+// b along the axis, from the vertices inside the slab and the points where
+// edges cross its planes.
+func clipPolygon(vertices []Vector, axis int, a float64, b float64) AABB {
+	box := EmptyAABB()
+	for i, p := range vertices {
+		q := vertices[(i+1)%len(vertices)]
+		cp, cq := component(p, axis), component(q, axis)
+		if cp >= a && cp <= b {
+			box = box.Extend(p)
+		}
+		for _, plane := range [2]float64{a, b} {
+			if math.IsInf(plane, 0) || (cp-plane)*(cq-plane) >= 0 {
+				continue
+			}
+			crossing := add(p, scale(sub(q, p), (plane-cp)/(cq-cp)))
+			setComponent(&crossing, axis, plane)
+			box = box.Extend(crossing)
+		}
+	}
+	return box
+}
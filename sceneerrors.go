@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Scene file errors point at the part of the file at fault by key: the
+// path of names and indices from the top of the file down to a value, as
+// in objects[2].radius or materials.red. jsonPositions and yamlPositions
+// map every key in a file to its line and column.
+
+// sceneFieldError is an error about one field of a camera, material,
+// object or light, which LoadSceneFile reports at that field's line.
+type sceneFieldError struct {
+	field string
+	err   string
+}
+
+func (e *sceneFieldError) Error() string {
+	return e.err
+}
+
+func sceneFieldf(field string, format string, args ...interface{}) error {
+	return &sceneFieldError{field, fmt.Sprintf(format, args...)}
+}
+
+// jsonPosition returns the line and column of a byte offset into data.
+func jsonPosition(data []byte, offset int64) string {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return fmt.Sprintf("%d:%d", line, column)
+}
+
+func joinKey(key string, name string) string {
+	if key == "" {
+		return name
+	}
+	return key + "." + name
+}
+
+// shiftKey renumbers a key within list, such as objects[2].radius within
+// objects, by offset, for a list appended to another.
+func shiftKey(key string, list string, offset int) string {
+	end := strings.IndexByte(key, ']')
+	if !strings.HasPrefix(key, list+"[") || end < 0 {
+		return key
+	}
+	i, err := strconv.Atoi(key[len(list)+1 : end])
+	if err != nil {
+		return key
+	}
+	return fmt.Sprintf("%s[%d]%s", list, i+offset, key[end+1:])
+}
+
+// forgetKeys removes the positions of the part of a file at key.
+func forgetKeys(positions map[string]string, key string) {
+	for k := range positions {
+		if k == key || strings.HasPrefix(k, key+".") {
+			delete(positions, k)
+		}
+	}
+}
+
+// jsonPositions returns the position of the start of every value in a JSON
+// document that decodes; keys within objects are positioned at the value.
+func jsonPositions(data []byte) map[string]string {
+	positions := map[string]string{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	var walk func(key string) error
+	walk = func(key string) error {
+		// The decoder's offset is just past the last token, before any
+		// separator.
+		start := decoder.InputOffset()
+		for start < int64(len(data)) && strings.IndexByte(" \t\r\n,:", data[start]) >= 0 {
+			start++
+		}
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		positions[key] = jsonPosition(data, start)
+		switch token {
+		case json.Delim('{'):
+			for decoder.More() {
+				name, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				if err := walk(joinKey(key, name.(string))); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+		case json.Delim('['):
+			for i := 0; decoder.More(); i++ {
+				if err := walk(fmt.Sprintf("%s[%d]", key, i)); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+		}
+		return err
+	}
+	walk("")
+	return positions
+}
+
+// yamlPositions returns the position of every value in a YAML document
+// that parses; keys within mappings are positioned at their names.
+func yamlPositions(data []byte) map[string]string {
+	positions := map[string]string{}
+	var walk func(key string, node *yaml.Node, at *yaml.Node)
+	walk = func(key string, node *yaml.Node, at *yaml.Node) {
+		positions[key] = fmt.Sprintf("%d:%d", at.Line, at.Column)
+		switch node.Kind {
+		case yaml.DocumentNode:
+			for _, child := range node.Content {
+				walk(key, child, child)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				walk(joinKey(key, node.Content[i].Value), node.Content[i+1], node.Content[i])
+			}
+		case yaml.SequenceNode:
+			for i, child := range node.Content {
+				walk(fmt.Sprintf("%s[%d]", key, i), child, child)
+			}
+		case yaml.AliasNode:
+			walk(key, node.Alias, at)
+		}
+	}
+	var root yaml.Node
+	if yaml.Unmarshal(data, &root) == nil {
+		walk("", &root, &root)
+	}
+	return positions
+}
+
+// nonFinite returns the key of the first NaN or infinite number in v, a
+// decoded scene file or part of one at key, or "" if there is none. YAML
+// can spell them .nan and .inf.
+func nonFinite(v reflect.Value, key string) string {
+	switch v.Kind() {
+	case reflect.Float64:
+		if math.IsNaN(v.Float()) || math.IsInf(v.Float(), 0) {
+			return key
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return nonFinite(v.Elem(), key)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if found := nonFinite(v.Index(i), fmt.Sprintf("%s[%d]", key, i)); found != "" {
+				return found
+			}
+		}
+	case reflect.Map:
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, name := range keys {
+			if found := nonFinite(v.MapIndex(name), joinKey(key, name.String())); found != "" {
+				return found
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+			if found := nonFinite(v.Field(i), joinKey(key, name)); found != "" {
+				return found
+			}
+		}
+	}
+	return ""
+}
+
+// sortedKeys returns the names in a map of a scene file's cameras or
+// materials in order, so the same file fails the same way every time.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	for _, name := range reflect.ValueOf(m).MapKeys() {
+		keys = append(keys, name.String())
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,1067 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A scene file describes a scene in JSON, in place of the one main builds
+// in, as in scenes/spheres.json:
+//
+//	{
+//	  "cameras": {"default": {"position": [0, 0, -3], "look_at": [0, 0, 0]}},
+//	  "materials": {"red": {"color": [1, 0, 0], "specular": 500, "reflective": 0.2}},
+//	  "objects": [{"type": "sphere", "center": [0, -1, 3], "radius": 1, "material": "red"}],
+//	  "lights": [{"type": "point", "intensity": [0.6, 0.6, 0.6], "position": [2, 1, 0]}]
+//	}
+//
+// Vectors and colors are arrays of three numbers, and angles are in degrees.
+// A camera looks from position at look_at, with up (by default [0, 1, 0])
+// towards the top of the image, through projection ("perspective" by
+// default, or "orthographic", "fisheye" with fov, or "panorama"), with an
+// optional aperture and focal_distance for depth of field. A material has a
+// color and optionally specular (its shininess, matte if left out),
+// reflective, transparency, ior, dispersion, emission, a metal ("gold",
+// "copper", "silver", "aluminum" or "iron"), the "pbr" model with metallic
+// and roughness, and a texture: "checker" with color2 and scale, "marble" or
+// "clouds" with color2 and scale, or "image" with a path. Objects, whose
+// fields follow their Make functions, are spheres (center, radius), planes
+// (point, normal), triangles (vertices), boxes (min, max), cylinders (base,
+// axis, radius, height), cones (apex, axis, angle, height), disks (center,
+// normal, inner_radius, radius), tori (center, axis, major_radius,
+// minor_radius), quadrics (the ten coefficients A to J of A x² + B y² + C z²
+// + D xy + E yz + F xz + G x + H y + I z + J = 0, clipped to the box from
+// min to max if given), models (path, to an OBJ, STL, PLY, glTF or .bpt
+// file), groups (path, to another scene file whose objects it places) and
+// csg (the union, intersection or difference, by op, of the solids left and
+// right, which take its material unless they name their own), media (fog or
+// smoke of density filling the solid boundary, scattering in the color of
+// their material) and sdf (a distance field sphere traced in up to max_steps
+// steps, by shape: sphere, box and torus as above, a mandelbulb of radius
+// with power and iterations, or a blend of the shapes left and right,
+// smoothed over blend). Models and groups are scaled by scale, rotated by
+// rotate, degrees about x, y and z, and moved by translate, and all those
+// naming one file share a single copy of its geometry. Lights are ambient,
+// point (position), directional (direction, and angular_diameter for a sun),
+// spot (position, direction, inner_angle, outer_angle, falloff), rect
+// (center, edge_u, edge_v) and disk (center, normal, radius), each with an
+// intensity. A file may choose the structure accelerating the scene with
+// accel: "bvh" (the default), "kdtree" or "grid", unless -accel is given.
+// A file may list other scene files to include, such as a library of
+// materials, whose cameras, materials, objects, lights and accel it takes
+// as its own unless it names its own the same. Paths are relative to the file they appear in.
+//
+// Files ending in .yaml or .yml hold the same in YAML, which is easier to
+// edit by hand and takes comments, as in scenes/spheres.yaml. A file may
+// also name a Lua script adding to it, or be one, ending in .lua; see
+// runSceneScript.
+
+// SceneFile is the JSON or YAML form of a scene file.
+type SceneFile struct {
+	Cameras   map[string]SceneCamera   `json:"cameras,omitempty" yaml:"cameras,omitempty"`
+	Materials map[string]SceneMaterial `json:"materials,omitempty" yaml:"materials,omitempty"`
+	Objects   []SceneObject            `json:"objects,omitempty" yaml:"objects,omitempty"`
+	Lights    []SceneLight             `json:"lights,omitempty" yaml:"lights,omitempty"`
+	Script    string                   `json:"script,omitempty" yaml:"script,omitempty"`
+	Include   []string                 `json:"include,omitempty" yaml:"include,omitempty"`
+	Accel     string                   `json:"accel,omitempty" yaml:"accel,omitempty"`
+}
+
+type SceneCamera struct {
+	Position      []float64 `json:"position,omitempty" yaml:"position,omitempty"`
+	LookAt        []float64 `json:"look_at,omitempty" yaml:"look_at,omitempty"`
+	Up            []float64 `json:"up,omitempty" yaml:"up,omitempty"`
+	Projection    string    `json:"projection,omitempty" yaml:"projection,omitempty"`
+	FOV           float64   `json:"fov,omitempty" yaml:"fov,omitempty"`
+	Aperture      float64   `json:"aperture,omitempty" yaml:"aperture,omitempty"`
+	FocalDistance float64   `json:"focal_distance,omitempty" yaml:"focal_distance,omitempty"`
+}
+
+type SceneMaterial struct {
+	Color        []float64 `json:"color,omitempty" yaml:"color,omitempty"`
+	Specular     *float64  `json:"specular,omitempty" yaml:"specular,omitempty"`
+	Reflective   float64   `json:"reflective,omitempty" yaml:"reflective,omitempty"`
+	Transparency float64   `json:"transparency,omitempty" yaml:"transparency,omitempty"`
+	IOR          float64   `json:"ior,omitempty" yaml:"ior,omitempty"`
+	Dispersion   float64   `json:"dispersion,omitempty" yaml:"dispersion,omitempty"`
+	Emission     []float64 `json:"emission,omitempty" yaml:"emission,omitempty"`
+	Metal        string    `json:"metal,omitempty" yaml:"metal,omitempty"`
+	Model        string    `json:"model,omitempty" yaml:"model,omitempty"`
+	Metallic     float64   `json:"metallic,omitempty" yaml:"metallic,omitempty"`
+	Roughness    float64   `json:"roughness,omitempty" yaml:"roughness,omitempty"`
+	Texture      string    `json:"texture,omitempty" yaml:"texture,omitempty"`
+	Color2       []float64 `json:"color2,omitempty" yaml:"color2,omitempty"`
+	Scale        float64   `json:"scale,omitempty" yaml:"scale,omitempty"`
+	Path         string    `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+type SceneObject struct {
+	Type         string       `json:"type,omitempty" yaml:"type,omitempty"`
+	Material     string       `json:"material,omitempty" yaml:"material,omitempty"`
+	Center       []float64    `json:"center,omitempty" yaml:"center,omitempty"`
+	Radius       float64      `json:"radius,omitempty" yaml:"radius,omitempty"`
+	Point        []float64    `json:"point,omitempty" yaml:"point,omitempty"`
+	Normal       []float64    `json:"normal,omitempty" yaml:"normal,omitempty"`
+	Vertices     [][]float64  `json:"vertices,omitempty" yaml:"vertices,omitempty"`
+	Min          []float64    `json:"min,omitempty" yaml:"min,omitempty"`
+	Max          []float64    `json:"max,omitempty" yaml:"max,omitempty"`
+	Base         []float64    `json:"base,omitempty" yaml:"base,omitempty"`
+	Apex         []float64    `json:"apex,omitempty" yaml:"apex,omitempty"`
+	Axis         []float64    `json:"axis,omitempty" yaml:"axis,omitempty"`
+	Height       float64      `json:"height,omitempty" yaml:"height,omitempty"`
+	Angle        float64      `json:"angle,omitempty" yaml:"angle,omitempty"`
+	InnerRadius  float64      `json:"inner_radius,omitempty" yaml:"inner_radius,omitempty"`
+	MajorRadius  float64      `json:"major_radius,omitempty" yaml:"major_radius,omitempty"`
+	MinorRadius  float64      `json:"minor_radius,omitempty" yaml:"minor_radius,omitempty"`
+	Path         string       `json:"path,omitempty" yaml:"path,omitempty"`
+	Translate    []float64    `json:"translate,omitempty" yaml:"translate,omitempty"`
+	Rotate       []float64    `json:"rotate,omitempty" yaml:"rotate,omitempty"`
+	Scale        float64      `json:"scale,omitempty" yaml:"scale,omitempty"`
+	Op           string       `json:"op,omitempty" yaml:"op,omitempty"`
+	Left         *SceneObject `json:"left,omitempty" yaml:"left,omitempty"`
+	Right        *SceneObject `json:"right,omitempty" yaml:"right,omitempty"`
+	Shape        string       `json:"shape,omitempty" yaml:"shape,omitempty"`
+	Power        float64      `json:"power,omitempty" yaml:"power,omitempty"`
+	Iterations   int          `json:"iterations,omitempty" yaml:"iterations,omitempty"`
+	Blend        float64      `json:"blend,omitempty" yaml:"blend,omitempty"`
+	MaxSteps     int          `json:"max_steps,omitempty" yaml:"max_steps,omitempty"`
+	Coefficients []float64    `json:"coefficients,omitempty" yaml:"coefficients,omitempty"`
+	Boundary     *SceneObject `json:"boundary,omitempty" yaml:"boundary,omitempty"`
+	Density      float64      `json:"density,omitempty" yaml:"density,omitempty"`
+}
+
+type SceneLight struct {
+	Type            string    `json:"type,omitempty" yaml:"type,omitempty"`
+	Intensity       []float64 `json:"intensity,omitempty" yaml:"intensity,omitempty"`
+	Position        []float64 `json:"position,omitempty" yaml:"position,omitempty"`
+	Direction       []float64 `json:"direction,omitempty" yaml:"direction,omitempty"`
+	Center          []float64 `json:"center,omitempty" yaml:"center,omitempty"`
+	Normal          []float64 `json:"normal,omitempty" yaml:"normal,omitempty"`
+	EdgeU           []float64 `json:"edge_u,omitempty" yaml:"edge_u,omitempty"`
+	EdgeV           []float64 `json:"edge_v,omitempty" yaml:"edge_v,omitempty"`
+	Radius          float64   `json:"radius,omitempty" yaml:"radius,omitempty"`
+	InnerAngle      float64   `json:"inner_angle,omitempty" yaml:"inner_angle,omitempty"`
+	OuterAngle      float64   `json:"outer_angle,omitempty" yaml:"outer_angle,omitempty"`
+	Falloff         float64   `json:"falloff,omitempty" yaml:"falloff,omitempty"`
+	AngularDiameter float64   `json:"angular_diameter,omitempty" yaml:"angular_diameter,omitempty"`
+}
+
+// LoadSceneFile reads a scene file, returning its objects, lights and
+// cameras, one of which must be the "default", and the accelerator it
+// chooses, if any. It checks the scene for values that would render
+// garbage, such as negative radii or zero-length directions, and errors
+// name the file, the line and column and the object, light, camera or
+// material at fault. files lists every file the scene was read from, or
+// would have been had it loaded, for -watch.
+func LoadSceneFile(path string) (objects []Object, lights []*Light, cameras map[string]*Camera, accel string, files []string, err error) {
+	path = filepath.Clean(path)
+	loader := sceneLoader{groups: map[string]*BVH{}, models: map[string]*BVH{}}
+	file, positions, err := loader.readSceneFile(path, "", nil)
+	if err != nil {
+		return nil, nil, nil, "", loader.files, err
+	}
+	if _, ok := file.Cameras["default"]; !ok {
+		return nil, nil, nil, "", loader.files, sceneError(positions, path, "cameras", "cameras", fmt.Errorf("no \"default\" camera to render"))
+	}
+	objects, lights, cameras, err = loader.buildScene(file, positions, path, []string{path})
+	return objects, lights, cameras, file.Accel, loader.files, err
+}
+
+// sceneLoader loads a scene file and the files it names. It builds each
+// group's file once, into groups, and lists in files every file it reads.
+type sceneLoader struct {
+	groups map[string]*BVH
+	models map[string]*BVH
+	files  []string
+}
+
+// readSceneFile reads the scene file at path, named at from in the file
+// including it if any, with its script run and the files it includes
+// merged in, and paths in it made relative to the working directory. It
+// returns too the location of each part of the file, by key. including
+// holds the files within which it was reached, to catch cycles.
+func (l *sceneLoader) readSceneFile(path string, from string, including []string) (SceneFile, map[string]string, error) {
+	var file SceneFile
+	for _, outer := range including {
+		if outer == path {
+			return file, nil, fmt.Errorf("%s: %s includes itself", from, path)
+		}
+	}
+	l.files = append(l.files, path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if from != "" {
+			err = fmt.Errorf("%s: %v", from, err)
+		}
+		return file, nil, err
+	}
+	var positions map[string]string
+	ext := strings.ToLower(filepath.Ext(path))
+	script := ext == ".lua"
+	switch ext {
+	case ".lua":
+		positions = map[string]string{}
+		err = runSceneScript(path, &file, positions)
+	case ".yaml", ".yml":
+		err = decodeYAMLScene(path, data, &file)
+		positions = yamlPositions(data)
+	default:
+		err = decodeJSONScene(path, data, &file)
+		positions = jsonPositions(data)
+	}
+	if err != nil {
+		return file, nil, err
+	}
+	dir := filepath.Dir(path)
+	if !script {
+		for key, position := range positions {
+			positions[key] = path + ":" + position
+		}
+	}
+	if file.Script != "" {
+		script := filepath.Join(dir, file.Script)
+		l.files = append(l.files, script)
+		if err := runSceneScript(script, &file, positions); err != nil {
+			return file, nil, err
+		}
+	}
+	for name, m := range file.Materials {
+		if m.Path != "" {
+			m.Path = filepath.Join(dir, m.Path)
+			file.Materials[name] = m
+		}
+	}
+	for i := range file.Objects {
+		if file.Objects[i].Path != "" {
+			file.Objects[i].Path = filepath.Join(dir, file.Objects[i].Path)
+		}
+	}
+	if len(file.Include) == 0 {
+		return file, positions, nil
+	}
+
+	// Included files come first, so the file's own cameras and materials
+	// replace theirs.
+	var merged SceneFile
+	merged_positions := map[string]string{}
+	for i, include := range file.Include {
+		key := fmt.Sprintf("include[%d]", i)
+		included, included_positions, err := l.readSceneFile(filepath.Join(dir, include), positions[key], append(including, path))
+		if err != nil {
+			return file, nil, err
+		}
+		mergeSceneFile(&merged, merged_positions, included, included_positions)
+	}
+	mergeSceneFile(&merged, merged_positions, file, positions)
+	return merged, merged_positions, nil
+}
+
+// mergeSceneFile adds the cameras, materials, objects and lights of from to
+// file, and their locations to positions, replacing any cameras and
+// materials of the same names and any accelerator file chose.
+func mergeSceneFile(file *SceneFile, positions map[string]string, from SceneFile, from_positions map[string]string) {
+	if file.Cameras == nil {
+		file.Cameras = map[string]SceneCamera{}
+	}
+	if file.Materials == nil {
+		file.Materials = map[string]SceneMaterial{}
+	}
+	for name, camera := range from.Cameras {
+		file.Cameras[name] = camera
+		forgetKeys(positions, "cameras."+name)
+	}
+	for name, material := range from.Materials {
+		file.Materials[name] = material
+		forgetKeys(positions, "materials."+name)
+	}
+	if from.Accel != "" {
+		file.Accel = from.Accel
+	}
+	objects, lights := len(file.Objects), len(file.Lights)
+	for key, position := range from_positions {
+		positions[shiftKey(shiftKey(key, "objects", objects), "lights", lights)] = position
+	}
+	file.Objects = append(file.Objects, from.Objects...)
+	file.Lights = append(file.Lights, from.Lights...)
+}
+
+// buildScene checks a scene file read by readSceneFile, named path, and
+// builds its objects, lights and cameras. including holds the files within
+// which it was reached.
+func (l *sceneLoader) buildScene(file SceneFile, positions map[string]string, path string, including []string) (objects []Object, lights []*Light, cameras map[string]*Camera, err error) {
+	// fail reports err about the part of the file at key.
+	fail := func(key string, label string, err error) ([]Object, []*Light, map[string]*Camera, error) {
+		return nil, nil, nil, sceneError(positions, path, key, label, err)
+	}
+	if key := nonFinite(reflect.ValueOf(file), ""); key != "" {
+		return fail(key, key, fmt.Errorf("should be a finite number"))
+	}
+	switch file.Accel {
+	case "", "bvh", "kdtree", "grid":
+	default:
+		return fail("accel", "accel", fmt.Errorf("unknown accelerator %q, want bvh, kdtree or grid", file.Accel))
+	}
+
+	cameras = map[string]*Camera{}
+	for _, name := range sortedKeys(file.Cameras) {
+		camera, err := file.Cameras[name].camera()
+		if err != nil {
+			return fail("cameras."+name, fmt.Sprintf("camera %q", name), err)
+		}
+		cameras[name] = camera
+	}
+	materials := map[string]*Material{}
+	for _, name := range sortedKeys(file.Materials) {
+		if path := file.Materials[name].Path; path != "" {
+			l.files = append(l.files, path)
+		}
+		material, err := file.Materials[name].material()
+		if err != nil {
+			return fail("materials."+name, fmt.Sprintf("material %q", name), err)
+		}
+		materials[name] = material
+	}
+	for i, o := range file.Objects {
+		key, label := fmt.Sprintf("objects[%d]", i), fmt.Sprintf("objects[%d] (%s)", i, o.Type)
+		if o.Type == "group" {
+			group, err := l.group(o, positions[key], including)
+			if err != nil {
+				var field *sceneFieldError
+				if !errors.As(err, &field) {
+					return nil, nil, nil, err // in the group's file
+				}
+				return fail(key, label, err)
+			}
+			objects = append(objects, group)
+			continue
+		}
+		if o.Type == "csg" || o.Type == "medium" {
+			var object Object
+			if o.Type == "csg" {
+				object, err = o.solid(materials, nil)
+			} else {
+				object, err = o.medium(materials)
+			}
+			if err != nil {
+				return fail(key, label, err)
+			}
+			objects = append(objects, object)
+			continue
+		}
+		material, ok := materials[o.Material]
+		if !ok {
+			return fail(key, label, sceneFieldf("material", "unknown material %q", o.Material))
+		}
+		if o.Path != "" {
+			l.files = append(l.files, o.Path)
+		}
+		if o.Type == "model" {
+			model, err := l.model(o, material)
+			if err != nil {
+				return fail(key, label, err)
+			}
+			objects = append(objects, model)
+			continue
+		}
+		object, err := o.objects(material)
+		if err != nil {
+			return fail(key, label, err)
+		}
+		objects = append(objects, object...)
+	}
+	for i, l := range file.Lights {
+		light, err := l.light()
+		if err != nil {
+			return fail(fmt.Sprintf("lights[%d]", i), fmt.Sprintf("lights[%d] (%s)", i, l.Type), err)
+		}
+		lights = append(lights, light)
+	}
+	return objects, lights, cameras, nil
+}
+
+// group places the objects of the scene file a group names, built once
+// into a BVH shared by every group naming the file, as an instance of it.
+func (l *sceneLoader) group(o SceneObject, from string, including []string) (Object, error) {
+	if o.Path == "" {
+		return nil, sceneFieldf("path", "path should name a scene file")
+	}
+	to_world, err := o.placement()
+	if err != nil {
+		return nil, err
+	}
+
+	bvh, ok := l.groups[o.Path]
+	if !ok {
+		file, positions, err := l.readSceneFile(o.Path, from, including)
+		if err != nil {
+			return nil, err
+		}
+		objects, _, _, err := l.buildScene(file, positions, o.Path, append(including, o.Path))
+		if err != nil {
+			return nil, err
+		}
+		built := MakeBVH(objects)
+		bvh = &built
+		l.groups[o.Path] = bvh
+	}
+	group := MakeInstance(bvh, to_world, nil)
+	return &group, nil
+}
+
+// model places the faces of the model file a model names, loaded once into
+// a BVH shared by every model naming the file, as an instance of it in
+// material, which its faces take unless the file gives them their own.
+func (l *sceneLoader) model(o SceneObject, material *Material) (Object, error) {
+	if o.Path == "" {
+		return nil, sceneFieldf("path", "path should name a model file")
+	}
+	to_world, err := o.placement()
+	if err != nil {
+		return nil, err
+	}
+
+	bvh, ok := l.models[o.Path]
+	if !ok {
+		meshes, err := LoadModel(o.Path, nil)
+		if err != nil {
+			return nil, sceneFieldf("path", "%v", err)
+		}
+		if len(meshes) == 1 {
+			bvh = meshes[0].BVH()
+		} else {
+			var faces []Object
+			for i := range meshes {
+				faces = append(faces, meshes[i].Faces()...)
+			}
+			built := MakeBVH(faces)
+			bvh = &built
+		}
+		l.models[o.Path] = bvh
+	}
+	model := MakeInstance(bvh, to_world, material)
+	return &model, nil
+}
+
+// placement returns the transform placing a group or model in the scene:
+// scaled by scale, then rotated about x, y and z in turn and then moved.
+func (o SceneObject) placement() (Matrix4, error) {
+	scale := o.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	if err := scenePositive("scale", scale); err != nil {
+		return Matrix4{}, err
+	}
+	to_world := Scale(MakeVector(scale, scale, scale))
+	if o.Rotate != nil {
+		angles, err := sceneVector("rotate", o.Rotate)
+		if err != nil {
+			return Matrix4{}, err
+		}
+		to_world = to_world.Then(RotateX(angles.x * math.Pi / 180)).Then(RotateY(angles.y * math.Pi / 180)).Then(RotateZ(angles.z * math.Pi / 180))
+	}
+	if o.Translate != nil {
+		offset, err := sceneVector("translate", o.Translate)
+		if err != nil {
+			return Matrix4{}, err
+		}
+		to_world = to_world.Then(Translate(offset))
+	}
+	return to_world, nil
+}
+
+// sceneError reports err about the part of the scene file at path found at
+// key, or at the field it names within that part if the file has it.
+func sceneError(positions map[string]string, path string, key string, label string, err error) error {
+	location, ok := positions[key]
+	var field *sceneFieldError
+	if errors.As(err, &field) {
+		if p, found := positions[key+"."+field.field]; found {
+			location, ok = p, true
+		}
+	}
+	if !ok {
+		location = path
+	}
+	return fmt.Errorf("%s: %s: %v", location, label, err)
+}
+
+// decodeJSONScene decodes a JSON scene file, naming path and the line and
+// column in any error.
+func decodeJSONScene(path string, data []byte, file *SceneFile) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(file); err != nil {
+		var syntax *json.SyntaxError
+		var kind *json.UnmarshalTypeError
+		if errors.As(err, &syntax) {
+			// The offset is just past the character at fault.
+			return fmt.Errorf("%s:%s: %v", path, jsonPosition(data, syntax.Offset-1), err)
+		} else if errors.As(err, &kind) {
+			return fmt.Errorf("%s:%s: %s should be %s, not %s", path, jsonPosition(data, kind.Offset), kind.Field, jsonKind(kind.Type), kind.Value)
+		} else if field := strings.TrimPrefix(err.Error(), "json: unknown field "); field != err.Error() {
+			// The decoder does not say where; the first use of the name
+			// is likely the one.
+			at := bytes.Index(data, []byte(field))
+			return fmt.Errorf("%s:%s: unknown field %s", path, jsonPosition(data, int64(at)), field)
+		}
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// yamlLine and yamlUnknownField match the parts of yaml's errors that
+// decodeYAMLScene rewrites to read like decodeJSONScene's.
+var (
+	yamlLine         = regexp.MustCompile(`^line (\d+): `)
+	yamlUnknownField = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+)
+
+// decodeYAMLScene decodes a YAML scene file, naming path and the line in
+// any error; yaml's errors give no column.
+func decodeYAMLScene(path string, data []byte, file *SceneFile) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(file); err != nil && err != io.EOF {
+		message := strings.TrimPrefix(err.Error(), "yaml: ")
+		var kind *yaml.TypeError
+		if errors.As(err, &kind) && len(kind.Errors) > 0 {
+			message = kind.Errors[0] // the first of the fields at fault
+		}
+		location := path
+		if line := yamlLine.FindStringSubmatch(message); line != nil {
+			location, message = path+":"+line[1], message[len(line[0]):]
+		}
+		message = yamlUnknownField.ReplaceAllString(message, `unknown field "$1"`)
+		return fmt.Errorf("%s: %s", location, message)
+	}
+	return nil
+}
+
+// jsonKind describes the JSON values that decode to a Go type.
+func jsonKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Float64:
+		return "a number"
+	case reflect.Slice:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return t.String()
+}
+
+// sceneVector reads a vector or color named name, which must have three
+// components, or be left out if fallback is given.
+func sceneVector(name string, v []float64, fallback ...Vector) (Vector, error) {
+	if v == nil && len(fallback) > 0 {
+		return fallback[0], nil
+	}
+	if len(v) != 3 {
+		return Vector{}, sceneFieldf(name, "%s should be three numbers, as in [0, 1, 0]", name)
+	}
+	return MakeVector(v[0], v[1], v[2]), nil
+}
+
+// sceneDirection reads a direction named name, which must not be zero.
+func sceneDirection(name string, v []float64) (Vector, error) {
+	u, err := sceneVector(name, v)
+	if err == nil && u == (Vector{}) {
+		err = sceneFieldf(name, "%s should not be zero length", name)
+	}
+	return u, err
+}
+
+// sceneColor reads a color named name, which must not be negative.
+func sceneColor(name string, c []float64, fallback ...Vector) (Color, error) {
+	v, err := sceneVector(name, c, fallback...)
+	if err == nil && (v.x < 0 || v.y < 0 || v.z < 0) {
+		err = sceneFieldf(name, "%s should not be negative", name)
+	}
+	return Color{v.x, v.y, v.z}, err
+}
+
+// scenePositive checks that a length named name is above zero.
+func scenePositive(name string, v float64) error {
+	if v <= 0 {
+		return sceneFieldf(name, "%s should be above 0, not %v", name, v)
+	}
+	return nil
+}
+
+// sceneFraction checks that a value named name is between 0 and 1.
+func sceneFraction(name string, v float64) error {
+	if v < 0 || v > 1 {
+		return sceneFieldf(name, "%s should be from 0 to 1, not %v", name, v)
+	}
+	return nil
+}
+
+func (c SceneCamera) camera() (*Camera, error) {
+	position, err := sceneVector("position", c.Position)
+	if err != nil {
+		return nil, err
+	}
+	target, err := sceneVector("look_at", c.LookAt)
+	if err != nil {
+		return nil, err
+	}
+	up, err := sceneDirection("up", c.Up)
+	if c.Up == nil {
+		up, err = MakeVector(0, 1, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if position == target {
+		return nil, sceneFieldf("look_at", "look_at should differ from position")
+	}
+	if cross(up, sub(target, position)) == (Vector{}) {
+		return nil, sceneFieldf("up", "up should not point along the view")
+	}
+	camera := MakeCamera(position, target, up)
+	switch c.Projection {
+	case "":
+	case "perspective", "orthographic", "fisheye", "panorama":
+		camera.projection = c.Projection
+	default:
+		return nil, sceneFieldf("projection", "unknown projection %q, want perspective, orthographic, fisheye or panorama", c.Projection)
+	}
+	if c.FOV < 0 || c.FOV > 360 {
+		return nil, sceneFieldf("fov", "fov should be from 0 to 360 degrees, not %v", c.FOV)
+	}
+	if c.FOV > 0 {
+		camera.fov = c.FOV * math.Pi / 180
+	}
+	if c.Aperture < 0 {
+		return nil, sceneFieldf("aperture", "aperture should not be negative")
+	}
+	camera.aperture = c.Aperture
+	if c.FocalDistance < 0 {
+		return nil, sceneFieldf("focal_distance", "focal_distance should not be negative")
+	}
+	if c.FocalDistance > 0 {
+		camera.focal_distance = c.FocalDistance
+	}
+	return &camera, nil
+}
+
+func (m SceneMaterial) material() (*Material, error) {
+	color, err := sceneColor("color", m.Color, MakeVector(1, 1, 1))
+	if err != nil {
+		return nil, err
+	}
+	specular := -1.0
+	if m.Specular != nil {
+		specular = *m.Specular
+	}
+	if specular < 0 && specular != -1 {
+		return nil, sceneFieldf("specular", "specular should be a shininess of 0 or more, or -1 for a matte surface, not %v", specular)
+	}
+	for _, check := range []error{
+		sceneFraction("reflective", m.Reflective),
+		sceneFraction("transparency", m.Transparency),
+		sceneFraction("metallic", m.Metallic),
+		sceneFraction("roughness", m.Roughness),
+	} {
+		if check != nil {
+			return nil, check
+		}
+	}
+	if m.IOR < 0 {
+		return nil, sceneFieldf("ior", "ior should be above 0, not %v", m.IOR)
+	}
+	material := MakeMaterial(color, specular, m.Reflective)
+	if m.Metal != "" {
+		tint, ok := metalTints[m.Metal]
+		if !ok {
+			return nil, sceneFieldf("metal", "unknown metal %q, want gold, copper, silver, aluminum or iron", m.Metal)
+		}
+		material = MakeMetal(tint)
+		color = tint
+	}
+	switch m.Model {
+	case "", "phong":
+	case "pbr":
+		metallic := m.Metallic
+		if m.Metal != "" {
+			metallic = 1
+		}
+		material = MakePBRMaterial(color, metallic, m.Roughness)
+	default:
+		return nil, sceneFieldf("model", "unknown model %q, want phong or pbr", m.Model)
+	}
+	material.roughness = m.Roughness
+	material.transparency = m.Transparency
+	if m.IOR != 0 {
+		material.ior = m.IOR
+	}
+	material.dispersion = m.Dispersion
+	if material.emission, err = sceneColor("emission", m.Emission, Vector{}); err != nil {
+		return nil, err
+	}
+
+	if m.Texture == "" {
+		return &material, nil
+	}
+	color2, err := sceneColor("color2", m.Color2, MakeVector(1, 1, 1))
+	if err != nil {
+		return nil, err
+	}
+	scale := m.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	if err := scenePositive("scale", scale); err != nil {
+		return nil, err
+	}
+	switch m.Texture {
+	case "checker":
+		checks := MakeCheckerTexture(material.color, color2, scale)
+		material.texture = &checks
+	case "marble":
+		marble := MakeMarbleTexture(material.color, color2, scale, 4, 6)
+		material.texture = &marble
+	case "clouds":
+		clouds := MakeCloudTexture(material.color, color2, scale, 6)
+		material.texture = &clouds
+	case "image":
+		if m.Path == "" {
+			return nil, sceneFieldf("path", "path should name the texture's image file")
+		}
+		image, err := LoadImageTexture(m.Path)
+		if err != nil {
+			return nil, sceneFieldf("path", "%v", err)
+		}
+		material.texture = &image
+	default:
+		return nil, sceneFieldf("texture", "unknown texture %q, want checker, marble, clouds or image", m.Texture)
+	}
+	return &material, nil
+}
+
+// objects returns the object, or for models their faces.
+func (o SceneObject) objects(material *Material) ([]Object, error) {
+	var errs []error
+	vector := func(name string, v []float64) Vector {
+		u, err := sceneVector(name, v)
+		errs = append(errs, err)
+		return u
+	}
+	direction := func(name string, v []float64) Vector {
+		u, err := sceneDirection(name, v)
+		errs = append(errs, err)
+		return u
+	}
+	positive := func(name string, v float64) float64 {
+		errs = append(errs, scenePositive(name, v))
+		return v
+	}
+	var object Object
+	switch o.Type {
+	case "sphere":
+		sphere := MakeSphere(vector("center", o.Center), positive("radius", o.Radius), material)
+		object = &sphere
+	case "plane":
+		plane := MakePlane(vector("point", o.Point), direction("normal", o.Normal), material)
+		object = &plane
+	case "triangle":
+		if len(o.Vertices) != 3 {
+			return nil, sceneFieldf("vertices", "vertices should be three points")
+		}
+		v0, v1, v2 := vector("vertices[0]", o.Vertices[0]), vector("vertices[1]", o.Vertices[1]), vector("vertices[2]", o.Vertices[2])
+		if cross(sub(v1, v0), sub(v2, v0)) == (Vector{}) {
+			errs = append(errs, sceneFieldf("vertices", "vertices should not lie on a line"))
+		}
+		triangle := MakeTriangle(v0, v1, v2, material)
+		object = &triangle
+	case "box":
+		min, max := vector("min", o.Min), vector("max", o.Max)
+		if min.x >= max.x || min.y >= max.y || min.z >= max.z {
+			errs = append(errs, sceneFieldf("max", "max should be above min on every axis"))
+		}
+		box := MakeBox(min, max, material)
+		object = &box
+	case "cylinder":
+		cylinder := MakeCylinder(vector("base", o.Base), direction("axis", o.Axis), positive("radius", o.Radius), positive("height", o.Height), material)
+		object = &cylinder
+	case "cone":
+		angle := positive("angle", o.Angle)
+		if angle >= 90 {
+			errs = append(errs, sceneFieldf("angle", "angle should be below 90 degrees, not %v", angle))
+		}
+		cone := MakeCone(vector("apex", o.Apex), direction("axis", o.Axis), angle*math.Pi/180, positive("height", o.Height), material)
+		object = &cone
+	case "disk":
+		radius := positive("radius", o.Radius)
+		if o.InnerRadius < 0 || o.InnerRadius >= radius {
+			errs = append(errs, sceneFieldf("inner_radius", "inner_radius should be from 0 to below radius, not %v", o.InnerRadius))
+		}
+		disk := MakeDisk(vector("center", o.Center), direction("normal", o.Normal), o.InnerRadius, radius, material)
+		object = &disk
+	case "torus":
+		torus := MakeTorus(vector("center", o.Center), direction("axis", o.Axis), positive("major_radius", o.MajorRadius), positive("minor_radius", o.MinorRadius), material)
+		object = &torus
+	case "quadric":
+		if len(o.Coefficients) != 10 {
+			return nil, sceneFieldf("coefficients", "coefficients should be the ten numbers A to J")
+		}
+		var coefficients [10]float64
+		copy(coefficients[:], o.Coefficients)
+		if coefficients == ([10]float64{9: coefficients[9]}) {
+			errs = append(errs, sceneFieldf("coefficients", "coefficients A to I should not all be 0"))
+		}
+		min, max := MakeVector(math.Inf(-1), math.Inf(-1), math.Inf(-1)), MakeVector(math.Inf(1), math.Inf(1), math.Inf(1))
+		if o.Min != nil {
+			min = vector("min", o.Min)
+		}
+		if o.Max != nil {
+			max = vector("max", o.Max)
+		}
+		if min.x > max.x || min.y > max.y || min.z > max.z {
+			errs = append(errs, sceneFieldf("max", "max should not be below min on any axis"))
+		}
+		quadric := MakeQuadric(coefficients, min, max, material)
+		object = &quadric
+	case "sdf":
+		sdf, err := o.sdf()
+		if err != nil {
+			return nil, err
+		}
+		max_steps := o.MaxSteps
+		if max_steps == 0 {
+			max_steps = defaultMaxSteps
+		}
+		errs = append(errs, scenePositive("max_steps", float64(max_steps)))
+		raymarched := MakeRaymarched(sdf, max_steps, material)
+		object = &raymarched
+	default:
+		return nil, sceneFieldf("type", "unknown type %q, want sphere, plane, triangle, box, cylinder, cone, disk, torus, quadric, sdf, model, group, csg or medium", o.Type)
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return []Object{object}, nil
+}
+
+// csgOps names the operations of csg objects.
+var csgOps = map[string]CSGOp{"union": Union, "intersection": Intersection, "difference": Difference}
+
+// solid builds a csg object or an operand of one, of its material, or of
+// inherited, the material of the csg it is in, if it names none. Errors
+// about an operand's fields name them by the path to the operand, as in
+// left.right.radius.
+func (o SceneObject) solid(materials map[string]*Material, inherited *Material) (Solid, error) {
+	material, ok := materials[o.Material]
+	if o.Material == "" && (inherited != nil || o.Type == "csg") {
+		material, ok = inherited, true
+	}
+	if !ok {
+		return nil, sceneFieldf("material", "unknown material %q", o.Material)
+	}
+	if o.Type != "csg" {
+		notSolid := sceneFieldf("type", "type %q is not a solid, want sphere, box, cylinder, cone, torus or csg", o.Type)
+		if o.Type == "group" || o.Type == "model" {
+			return nil, notSolid
+		}
+		objects, err := o.objects(material)
+		if err != nil {
+			return nil, err
+		}
+		solid, ok := objects[0].(Solid)
+		if !ok {
+			return nil, notSolid
+		}
+		return solid, nil
+	}
+
+	op, ok := csgOps[o.Op]
+	if !ok {
+		return nil, sceneFieldf("op", "unknown op %q, want union, intersection or difference", o.Op)
+	}
+	var operands [2]Solid
+	for i, operand := range []*SceneObject{o.Left, o.Right} {
+		name := [2]string{"left", "right"}[i]
+		if operand == nil {
+			return nil, sceneFieldf(name, "%s should be a solid object", name)
+		}
+		solid, err := operand.solid(materials, material)
+		if err != nil {
+			return nil, operandError(name, err)
+		}
+		operands[i] = solid
+	}
+	csg := MakeCSG(op, operands[0], operands[1])
+	return &csg, nil
+}
+
+// medium builds a medium object, filling its boundary.
+func (o SceneObject) medium(materials map[string]*Material) (Object, error) {
+	material, ok := materials[o.Material]
+	if !ok {
+		return nil, sceneFieldf("material", "unknown material %q", o.Material)
+	}
+	if o.Boundary == nil {
+		return nil, sceneFieldf("boundary", "boundary should be a solid object")
+	}
+	boundary, err := o.Boundary.solid(materials, material)
+	if err != nil {
+		return nil, operandError("boundary", err)
+	}
+	if err := scenePositive("density", o.Density); err != nil {
+		return nil, err
+	}
+	medium := MakeMedium(boundary, o.Density, material.color)
+	return &medium, nil
+}
+
+// operandError has an error about a field of the operand name of a csg,
+// medium or sdf blend name the field within the operand.
+func operandError(name string, err error) error {
+	var field *sceneFieldError
+	if errors.As(err, &field) {
+		return &sceneFieldError{name + "." + field.field, name + ": " + field.err}
+	}
+	return err
+}
+
+// defaultMaxSteps is the number of steps sdf objects march rays by unless
+// they set max_steps.
+const defaultMaxSteps = 200
+
+// sdf returns the distance field of an sdf object or a blend operand.
+func (o SceneObject) sdf() (SDF, error) {
+	var errs []error
+	vector := func(name string, v []float64) Vector {
+		u, err := sceneVector(name, v)
+		errs = append(errs, err)
+		return u
+	}
+	positive := func(name string, v float64) float64 {
+		errs = append(errs, scenePositive(name, v))
+		return v
+	}
+	var sdf SDF
+	switch o.Shape {
+	case "sphere":
+		sdf = SphereSDF(vector("center", o.Center), positive("radius", o.Radius))
+	case "box":
+		min, max := vector("min", o.Min), vector("max", o.Max)
+		if min.x >= max.x || min.y >= max.y || min.z >= max.z {
+			errs = append(errs, sceneFieldf("max", "max should be above min on every axis"))
+		}
+		sdf = BoxSDF(scale(add(min, max), 0.5), scale(sub(max, min), 0.5))
+	case "torus":
+		sdf = TorusSDF(vector("center", o.Center), positive("major_radius", o.MajorRadius), positive("minor_radius", o.MinorRadius))
+	case "mandelbulb":
+		power, iterations := o.Power, o.Iterations
+		if power == 0 {
+			power = 8
+		}
+		if iterations == 0 {
+			iterations = 10
+		}
+		positive("iterations", float64(iterations))
+		sdf = MandelbulbSDF(vector("center", o.Center), positive("radius", o.Radius), positive("power", power), iterations)
+	case "blend":
+		var operands [2]SDF
+		for i, operand := range []*SceneObject{o.Left, o.Right} {
+			name := [2]string{"left", "right"}[i]
+			if operand == nil {
+				return nil, sceneFieldf(name, "%s should be a shape to blend", name)
+			}
+			field, err := operand.sdf()
+			if err != nil {
+				return nil, operandError(name, err)
+			}
+			operands[i] = field
+		}
+		sdf = SmoothUnion(operands[0], operands[1], positive("blend", o.Blend))
+	default:
+		return nil, sceneFieldf("shape", "unknown shape %q, want sphere, box, torus, mandelbulb or blend", o.Shape)
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sdf, nil
+}
+
+func (l SceneLight) light() (*Light, error) {
+	var errs []error
+	vector := func(name string, v []float64) Vector {
+		u, err := sceneVector(name, v)
+		errs = append(errs, err)
+		return u
+	}
+	direction := func(name string, v []float64) Vector {
+		u, err := sceneDirection(name, v)
+		errs = append(errs, err)
+		return u
+	}
+	intensity, err := sceneColor("intensity", l.Intensity)
+	if err != nil {
+		return nil, err
+	}
+	var light Light
+	switch l.Type {
+	case "ambient":
+		light = MakeAmbientLight(intensity)
+	case "point":
+		light = MakePointLight(intensity, vector("position", l.Position))
+	case "directional":
+		light = MakeDirectionalLight(intensity, direction("direction", l.Direction))
+		if l.AngularDiameter < 0 || l.AngularDiameter >= 180 {
+			errs = append(errs, sceneFieldf("angular_diameter", "angular_diameter should be from 0 to below 180 degrees, not %v", l.AngularDiameter))
+		}
+		if l.AngularDiameter > 0 {
+			light = MakeSunLight(intensity, light.direction, l.AngularDiameter*math.Pi/180)
+		}
+	case "spot":
+		if l.InnerAngle < 0 {
+			errs = append(errs, sceneFieldf("inner_angle", "inner_angle should not be negative"))
+		}
+		if l.OuterAngle <= l.InnerAngle || l.OuterAngle > 180 {
+			errs = append(errs, sceneFieldf("outer_angle", "outer_angle should be above inner_angle and at most 180 degrees, not %v", l.OuterAngle))
+		}
+		falloff := l.Falloff
+		if falloff == 0 {
+			falloff = 1
+		}
+		errs = append(errs, scenePositive("falloff", falloff))
+		light = MakeSpotLight(intensity, vector("position", l.Position), direction("direction", l.Direction), l.InnerAngle*math.Pi/180, l.OuterAngle*math.Pi/180, falloff)
+	case "rect":
+		edge_u, edge_v := direction("edge_u", l.EdgeU), direction("edge_v", l.EdgeV)
+		if cross(edge_u, edge_v) == (Vector{}) {
+			errs = append(errs, sceneFieldf("edge_v", "edge_v should not be parallel to edge_u"))
+		}
+		light = MakeRectLight(intensity, vector("center", l.Center), edge_u, edge_v)
+	case "disk":
+		errs = append(errs, scenePositive("radius", l.Radius))
+		light = MakeDiskLight(intensity, vector("center", l.Center), direction("normal", l.Normal), l.Radius)
+	default:
+		return nil, sceneFieldf("type", "unknown type %q, want ambient, point, directional, spot, rect or disk", l.Type)
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &light, nil
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeScene writes the named files into a new directory, returning it.
+func writeScene(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, text := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+const jsonScene = `{
+  "cameras": {"default": {"position": [0, 0, -3], "look_at": [0, 0, 0]}},
+  "materials": {"red": {"color": [1, 0, 0], "specular": 500}},
+  "objects": [
+    {"type": "sphere", "center": [0, 0, 3], "radius": 1, "material": "red"},
+    {"type": "plane", "point": [0, -1, 0], "normal": [0, 1, 0], "material": "red"}
+  ],
+  "lights": [{"type": "point", "intensity": [1, 1, 1], "position": [2, 1, 0]}]
+}
+`
+
+const yamlScene = `cameras:
+  default: {position: [0, 0, -3], look_at: [0, 0, 0]}
+materials:
+  red: {color: [1, 0, 0], specular: 500}
+objects:
+  - {type: sphere, center: [0, 0, 3], radius: 1, material: red}
+  - {type: plane, point: [0, -1, 0], normal: [0, 1, 0], material: red}
+lights:
+  - {type: point, intensity: [1, 1, 1], position: [2, 1, 0]}
+`
+
+const luaScene = `camera("default", {position = {0, 0, -3}, look_at = {0, 0, 0}})
+material("red", {color = {1, 0, 0}, specular = 500})
+object{type = "sphere", center = {0, 0, 3}, radius = 1, material = "red"}
+object{type = "plane", point = {0, -1, 0}, normal = {0, 1, 0}, material = "red"}
+light{type = "point", intensity = {1, 1, 1}, position = {2, 1, 0}}
+`
+
+func TestLoadSceneFile(t *testing.T) {
+	dir := writeScene(t, map[string]string{"scene.json": jsonScene, "scene.yaml": yamlScene, "scene.lua": luaScene})
+	for _, name := range []string{"scene.json", "scene.yaml", "scene.lua"} {
+		objects, lights, cameras, _, files, err := LoadSceneFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if len(objects) != 2 || len(lights) != 1 || cameras["default"] == nil {
+			t.Errorf("%s: got %d objects, %d lights and cameras %v, want 2, 1 and a default", name, len(objects), len(lights), cameras)
+		}
+		sphere, ok := objects[0].(*Sphere)
+		if !ok || sphere.radius != 1 || sphere.center != MakeVector(0, 0, 3) || sphere.material.color != MakeColor(1, 0, 0) {
+			t.Errorf("%s: first object is %#v, want the red sphere", name, objects[0])
+		}
+		if len(files) != 1 || files[0] != filepath.Join(dir, name) {
+			t.Errorf("%s: read files %v, want just the scene", name, files)
+		}
+	}
+}
+
+func TestSceneFileErrors(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{
+		{"radius.json", strings.Replace(jsonScene, `"radius": 1`, `"radius": -1`, 1),
+			"radius.json:5:55: objects[0] (sphere): radius should be above 0, not -1"},
+		{"field.json", strings.Replace(jsonScene, `"radius": 1`, `"radius": 1, "radus": 2`, 1),
+			`field.json:5:58: unknown field "radus"`},
+		{"material.json", strings.Replace(jsonScene, `"material": "red"}`, `"material": "blue"}`, 1),
+			`material.json:5:70: objects[0] (sphere): unknown material "blue"`},
+		{"syntax.json", strings.Replace(jsonScene, `"radius": 1,`, `"radius": 1`, 1),
+			"syntax.json:5:57: invalid character '\"' after object key:value pair"},
+		{"radius.yaml", strings.Replace(yamlScene, "radius: 1", "radius: -1", 1),
+			"radius.yaml:6:39: objects[0] (sphere): radius should be above 0, not -1"},
+		{"field.yaml", strings.Replace(yamlScene, "radius: 1", "radus: 1", 1),
+			`field.yaml:6: unknown field "radus"`},
+		{"camera.yaml", strings.Replace(yamlScene, "default:", "main:", 1),
+			`camera.yaml:1:1: cameras: no "default" camera to render`},
+		{"color.yaml", strings.Replace(yamlScene, "color: [1, 0, 0]", "color: [1, 0, -1]", 1),
+			`color.yaml:4:9: material "red": color should not be negative`},
+		{"radius.lua", strings.Replace(luaScene, "radius = 1", "radius = -1", 1),
+			"radius.lua:3: objects[0] (sphere): radius should be above 0, not -1"},
+		{"field.lua", strings.Replace(luaScene, "radius = 1", "radus = 1", 1),
+			`field.lua:3: unknown field "radus"`},
+	}
+	for _, test := range tests {
+		dir := writeScene(t, map[string]string{test.name: test.text})
+		_, _, _, _, _, err := LoadSceneFile(filepath.Join(dir, test.name))
+		want := filepath.Join(dir, test.want)
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %s", test.name, err, want)
+		}
+	}
+}
+
+func TestSceneFileIncludes(t *testing.T) {
+	dir := writeScene(t, map[string]string{
+		"lib/materials.yaml": "materials:\n  red: {color: [1, 0, 0]}\n  blue: {color: [0, 0, 1]}\n",
+		"lib/ball.yaml":      "include: [materials.yaml]\nobjects:\n  - {type: sphere, center: [0, 0, 0], radius: 1, material: blue}\n",
+		"scene.yaml": `include: [lib/materials.yaml]
+cameras:
+  default: {position: [0, 0, -3], look_at: [0, 0, 0]}
+materials:
+  red: {color: [0, 1, 0]}
+objects:
+  - {type: sphere, center: [0, 0, 3], radius: 1, material: red}
+  - {type: group, path: lib/ball.yaml, translate: [2, 0, 0], scale: 0.5}
+  - {type: group, path: lib/ball.yaml, translate: [-2, 0, 0]}
+`,
+		"loop.yaml":    "include: [loop2.yaml]\n",
+		"loop2.yaml":   "include: [loop.yaml]\n",
+		"group.yaml":   "cameras:\n  default: {position: [0, 0, -3], look_at: [0, 0, 0]}\nobjects:\n  - {type: group, path: lib/bad.yaml}\n",
+		"lib/bad.yaml": "materials:\n  m: {color: [1, 1, 1]}\nobjects:\n  - {type: sphere, center: [0, 0, 0], radius: 0, material: m}\n",
+	})
+
+	objects, _, _, _, files, err := LoadSceneFile(filepath.Join(dir, "scene.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 3 {
+		t.Fatalf("got %d objects, want a sphere and two groups", len(objects))
+	}
+	if color := objects[0].(*Sphere).material.color; color != MakeColor(0, 1, 0) {
+		t.Errorf("sphere is %v, want the scene's own red, not the library's", color)
+	}
+	left, right := objects[1].(*Instance), objects[2].(*Instance)
+	if left.object != right.object {
+		t.Error("groups of the same file do not share its objects")
+	}
+	if box := left.Bounds(); box.min != MakeVector(1.5, -0.5, -0.5) || box.max != MakeVector(2.5, 0.5, 0.5) {
+		t.Errorf("scaled and moved group bounds are %v, want from (1.5, -0.5, -0.5) to (2.5, 0.5, 0.5)", box)
+	}
+	if len(files) != 4 {
+		t.Errorf("read files %v, want the scene, the library twice and the group", files)
+	}
+
+	_, _, _, _, _, err = LoadSceneFile(filepath.Join(dir, "loop.yaml"))
+	want := filepath.Join(dir, "loop2.yaml") + ":1:11: " + filepath.Join(dir, "loop.yaml") + " includes itself"
+	if err == nil || err.Error() != want {
+		t.Errorf("include loop: error %v, want %s", err, want)
+	}
+	_, _, _, _, _, err = LoadSceneFile(filepath.Join(dir, "group.yaml"))
+	want = filepath.Join(dir, "lib/bad.yaml") + ":4:39: objects[0] (sphere): radius should be above 0, not 0"
+	if err == nil || err.Error() != want {
+		t.Errorf("bad group: error %v, want %s", err, want)
+	}
+}
+
+func TestSceneFileModels(t *testing.T) {
+	dir := writeScene(t, map[string]string{
+		"tri.obj": "v 0 0 0\nv 1 0 0\nv 0 1 0\nf 1 2 3\n",
+		"scene.yaml": `cameras:
+  default: {position: [0, 0, -3], look_at: [0, 0, 0]}
+materials:
+  red: {color: [1, 0, 0]}
+  blue: {color: [0, 0, 1]}
+objects:
+  - {type: model, path: tri.obj, material: red}
+  - {type: model, path: tri.obj, material: blue, translate: [0, 0, 2], scale: 2}
+`,
+	})
+	objects, _, _, _, _, err := LoadSceneFile(filepath.Join(dir, "scene.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("got %d objects, want two models", len(objects))
+	}
+	red, blue := objects[0].(*Instance), objects[1].(*Instance)
+	if red.object != blue.object {
+		t.Fatal("models of the same file do not share its faces")
+	}
+	if len(red.object.(*BVH).objects) != 1 {
+		t.Errorf("shared BVH holds %d faces, want the one triangle", len(red.object.(*BVH).objects))
+	}
+	if box := blue.Bounds(); box.min != MakeVector(0, 0, 2) || box.max != MakeVector(2, 2, 2) {
+		t.Errorf("scaled and moved model bounds are %v, want from (0, 0, 2) to (2, 2, 2)", box)
+	}
+	for _, test := range []struct {
+		model *Instance
+		z     float64
+		color Color
+	}{{red, 0, MakeColor(1, 0, 0)}, {blue, 2, MakeColor(0, 0, 1)}} {
+		p, _ := test.model.Hit(MakeVector(0.25, 0.25, -1), MakeVector(0, 0, 1), 0, math.Inf(1))
+		if p == nil {
+			t.Errorf("ray misses the model at z = %v", test.z)
+		} else if color := p.Material().color; color != test.color {
+			t.Errorf("model at z = %v is %v, want %v", test.z, color, test.color)
+		}
+	}
+}
+
+func TestSceneFileCSG(t *testing.T) {
+	const scene = `cameras:
+  default: {position: [0, 0, -3], look_at: [0, 0, 0]}
+materials:
+  red: {color: [1, 0, 0]}
+  blue: {color: [0, 0, 1]}
+objects:
+  - type: csg
+    op: difference
+    material: red
+    left: {type: box, min: [-1, -1, -1], max: [1, 1, 1]}
+    right: {type: sphere, center: [0, 0, -1], radius: 0.5, material: blue}
+`
+	dir := writeScene(t, map[string]string{
+		"scene.yaml":  scene,
+		"op.yaml":     strings.Replace(scene, "op: difference", "op: xor", 1),
+		"radius.yaml": strings.Replace(scene, "radius: 0.5", "radius: 0", 1),
+		"plane.yaml":  strings.Replace(scene, "{type: box, min: [-1, -1, -1], max: [1, 1, 1]}", "{type: plane, point: [0, 0, 0], normal: [0, 1, 0]}", 1),
+	})
+	objects, _, _, _, _, err := LoadSceneFile(filepath.Join(dir, "scene.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	csg, ok := objects[0].(*CSG)
+	if len(objects) != 1 || !ok {
+		t.Fatalf("got objects %#v, want one CSG", objects)
+	}
+	if csg.op != Difference || csg.left.(*Box).material.color != MakeColor(1, 0, 0) || csg.right.(*Sphere).material.color != MakeColor(0, 0, 1) {
+		t.Errorf("got %#v, want a red box less a blue sphere", csg)
+	}
+	// The sphere bites into the front of the box, so a ray down the z axis
+	// first meets its far side, from within.
+	p, hit := csg.Hit(MakeVector(0, 0, -5), MakeVector(0, 0, 1), 0, math.Inf(1))
+	if _, inside := p.(*flipped); !inside || math.Abs(hit-4.5) > 1e-9 {
+		t.Errorf("ray hit %T at t = %v, want the inside of the sphere at 4.5", p, hit)
+	}
+
+	for name, want := range map[string]string{
+		"op.yaml":     `op.yaml:8:5: objects[0] (csg): unknown op "xor", want union, intersection or difference`,
+		"radius.yaml": "radius.yaml:11:47: objects[0] (csg): right: radius should be above 0, not 0",
+		"plane.yaml":  "plane.yaml:10:12: objects[0] (csg): left: type \"plane\" is not a solid, want sphere, box, cylinder, cone, torus or csg",
+	} {
+		_, _, _, _, _, err := LoadSceneFile(filepath.Join(dir, name))
+		want = filepath.Join(dir, want)
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %s", name, err, want)
+		}
+	}
+}
+
+func TestSceneFileSDF(t *testing.T) {
+	const scene = `cameras:
+  default: {position: [0, 0, -3], look_at: [0, 0, 0]}
+materials:
+  white: {color: [1, 1, 1]}
+objects:
+  - {type: sdf, shape: mandelbulb, center: [0, 0, 4], radius: 1, material: white}
+  - type: sdf
+    shape: blend
+    blend: 0.5
+    max_steps: 50
+    material: white
+    left: {shape: sphere, center: [-1, 0, 0], radius: 1}
+    right: {shape: box, min: [0, -1, -1], max: [2, 1, 1]}
+`
+	dir := writeScene(t, map[string]string{
+		"scene.yaml":  scene,
+		"shape.yaml":  strings.Replace(scene, "shape: mandelbulb", "shape: julia", 1),
+		"radius.yaml": strings.Replace(scene, "center: [-1, 0, 0], radius: 1", "center: [-1, 0, 0], radius: -1", 1),
+	})
+	objects, _, _, _, _, err := LoadSceneFile(filepath.Join(dir, "scene.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bulb, ok := objects[0].(*Raymarched)
+	if !ok || bulb.max_steps != defaultMaxSteps {
+		t.Fatalf("first object is %#v, want a mandelbulb of %d steps", objects[0], defaultMaxSteps)
+	}
+	if _, hit := bulb.Intersect(MakeVector(0, 0, 0), MakeVector(0, 0, 1), 0, math.Inf(1)); !hit {
+		t.Error("a ray at the mandelbulb misses it")
+	}
+	blend, ok := objects[1].(*Raymarched)
+	if !ok || blend.max_steps != 50 {
+		t.Fatalf("second object is %#v, want a blend of 50 steps", objects[1])
+	}
+	// Blending rounds the inner corner where the box meets the sphere,
+	// filling it out past both.
+	if d := blend.sdf(MakeVector(0, 1, 0)); d >= 0 {
+		t.Errorf("blend distance at the inner corner is %v, want below 0", d)
+	}
+
+	for name, want := range map[string]string{
+		"shape.yaml":  `shape.yaml:6:17: objects[0] (sdf): unknown shape "julia", want sphere, box, torus, mandelbulb or blend`,
+		"radius.yaml": "radius.yaml:12:47: objects[1] (sdf): left: radius should be above 0, not -1",
+	} {
+		_, _, _, _, _, err := LoadSceneFile(filepath.Join(dir, name))
+		want = filepath.Join(dir, want)
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %s", name, err, want)
+		}
+	}
+}
+
+func TestSceneFileQuadric(t *testing.T) {
+	const scene = `{
+  "cameras": {"default": {"position": [0, 0, -3], "look_at": [0, 0, 0]}},
+  "materials": {"white": {"color": [1, 1, 1]}},
+  "objects": [
+    {"type": "quadric", "coefficients": [1, 0, 1, 0, 0, 0, 0, -1, 0, 0], "max": [1, 1, 1], "material": "white"}
+  ]
+}
+`
+	dir := writeScene(t, map[string]string{
+		"scene.json": scene,
+		"count.json": strings.Replace(scene, "0, -1, 0, 0]", "0, -1, 0]", 1),
+		"zero.json":  strings.Replace(scene, "[1, 0, 1, 0, 0, 0, 0, -1, 0, 0]", "[0, 0, 0, 0, 0, 0, 0, 0, 0, 1]", 1),
+		"clip.json":  strings.Replace(scene, `"max": [1, 1, 1]`, `"min": [0, 2, 0], "max": [1, 1, 1]`, 1),
+	})
+	objects, _, _, _, _, err := LoadSceneFile(filepath.Join(dir, "scene.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	quadric, ok := objects[0].(*Quadric)
+	if !ok {
+		t.Fatalf("got %#v, want a quadric", objects[0])
+	}
+	if quadric.q[1][3] != -0.5 || quadric.max != MakeVector(1, 1, 1) || !math.IsInf(quadric.min.x, -1) {
+		t.Errorf("got %#v, want the paraboloid y = x² + z² clipped above at 1", quadric)
+	}
+
+	for name, want := range map[string]string{
+		"count.json": "count.json:5:41: objects[0] (quadric): coefficients should be the ten numbers A to J",
+		"zero.json":  "zero.json:5:41: objects[0] (quadric): coefficients A to I should not all be 0",
+		"clip.json":  "clip.json:5:99: objects[0] (quadric): max should not be below min on any axis",
+	} {
+		_, _, _, _, _, err := LoadSceneFile(filepath.Join(dir, name))
+		want = filepath.Join(dir, want)
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %s", name, err, want)
+		}
+	}
+}
+
+func TestSceneFileMedium(t *testing.T) {
+	const scene = `cameras:
+  default: {position: [0, 0, -3], look_at: [0, 0, 0]}
+materials:
+  fog: {color: [0.8, 0.8, 0.9]}
+objects:
+  - type: medium
+    density: 0.5
+    material: fog
+    boundary: {type: box, min: [-1, -1, -1], max: [1, 1, 1]}
+`
+	dir := writeScene(t, map[string]string{
+		"scene.yaml":    scene,
+		"density.yaml":  strings.Replace(scene, "density: 0.5", "density: 0", 1),
+		"boundary.yaml": strings.Replace(scene, "{type: box, min: [-1, -1, -1], max: [1, 1, 1]}", "{type: plane, point: [0, 0, 0], normal: [0, 1, 0]}", 1),
+	})
+	objects, _, _, _, _, err := LoadSceneFile(filepath.Join(dir, "scene.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	medium, ok := objects[0].(*Medium)
+	if !ok {
+		t.Fatalf("got %#v, want a medium", objects[0])
+	}
+	if _, box := medium.boundary.(*Box); !box || medium.density != 0.5 || medium.material.color != MakeColor(0.8, 0.8, 0.9) {
+		t.Errorf("got %#v, want fog of density 0.5 filling a box", medium)
+	}
+
+	for name, want := range map[string]string{
+		"density.yaml":  "density.yaml:7:5: objects[0] (medium): density should be above 0, not 0",
+		"boundary.yaml": `boundary.yaml:9:16: objects[0] (medium): boundary: type "plane" is not a solid, want sphere, box, cylinder, cone, torus or csg`,
+	} {
+		_, _, _, _, _, err := LoadSceneFile(filepath.Join(dir, name))
+		want = filepath.Join(dir, want)
+		if err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %s", name, err, want)
+		}
+	}
+}
+
+func TestSceneFileAccel(t *testing.T) {
+	dir := writeScene(t, map[string]string{
+		"plain.yaml":  yamlScene,
+		"grid.yaml":   "accel: grid\n" + yamlScene,
+		"lib.yaml":    "accel: grid\n",
+		"from.yaml":   "include: [lib.yaml]\n" + yamlScene,
+		"own.yaml":    "include: [lib.yaml]\naccel: kdtree\n" + yamlScene,
+		"octree.yaml": "accel: octree\n" + yamlScene,
+	})
+	for name, want := range map[string]string{"plain.yaml": "", "grid.yaml": "grid", "from.yaml": "grid", "own.yaml": "kdtree"} {
+		_, _, _, accel, _, err := LoadSceneFile(filepath.Join(dir, name))
+		if err != nil || accel != want {
+			t.Errorf("%s: accel %q (%v), want %q", name, accel, err, want)
+		}
+	}
+	_, _, _, _, _, err := LoadSceneFile(filepath.Join(dir, "octree.yaml"))
+	want := filepath.Join(dir, "octree.yaml") + `:1:1: accel: unknown accelerator "octree", want bvh, kdtree or grid`
+	if err == nil || err.Error() != want {
+		t.Errorf("octree.yaml: error %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,30 @@
+-- Five hundred random spheres on a grey ground, a scene too big to write
+-- out by hand. Change the seed for another arrangement.
+math.randomseed(7)
+
+camera("default", {position = {0, 2, -6}, look_at = {0, -0.5, 4}})
+camera("overview", {position = {0, 14, -4}, look_at = {0, -1, 6}})
+
+material("ground", {color = {0.5, 0.5, 0.5}, specular = 100, reflective = 0.2})
+for i = 1, 8 do
+  material("paint" .. i, {
+    color = {math.random(), math.random(), math.random()},
+    specular = 300,
+    reflective = 0.3 * math.random(),
+  })
+end
+
+object{type = "plane", point = {0, -1, 0}, normal = {0, 1, 0}, material = "ground"}
+for i = 1, 500 do
+  local radius = 0.1 + 0.2 * math.random()
+  object{
+    type = "sphere",
+    center = {24 * math.random() - 12, -1 + radius, 24 * math.random() - 2},
+    radius = radius,
+    material = "paint" .. math.random(8),
+  }
+end
+
+light{type = "ambient", intensity = {0.2, 0.2, 0.2}}
+light{type = "point", intensity = {0.6, 0.6, 0.6}, position = {2, 4, -2}}
+light{type = "directional", intensity = {0.2, 0.2, 0.2}, direction = {1, 4, 4}}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// A Lua scene script builds a scene file's contents in code, for scenes
+// too big or too regular to write out, by calling
+//
+//	camera(name, {...})
+//	material(name, {...})
+//	object{...}
+//	light{...}
+//
+// with tables holding the fields a scene file gives each, as in
+// scenes/random.lua. It may be a scene file of its own, or add to a JSON or
+// YAML one that names it as its "script". Scripts get Lua's base, string,
+// table and math libraries but no access to files beyond reading other
+// scripts, and math.random draws from a generator seeded the same on every
+// run, or by math.randomseed, so a script builds the same scene each time.
+
+// runSceneScript runs the script at path, adding what it builds to file and
+// the script's line for each addition to positions.
+func runSceneScript(path string, file *SceneFile, positions map[string]string) error {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	defer L.Close()
+	for name, open := range map[string]lua.LGFunction{lua.BaseLibName: lua.OpenBase, lua.TabLibName: lua.OpenTable, lua.StringLibName: lua.OpenString, lua.MathLibName: lua.OpenMath} {
+		L.Push(L.NewFunction(open))
+		L.Push(lua.LString(name))
+		L.Call(1, 0)
+	}
+	rng := newRNG()
+	rng.Seed(1)
+	math := L.GetGlobal("math").(*lua.LTable)
+	math.RawSetString("random", L.NewFunction(func(L *lua.LState) int {
+		return luaRandom(L, rng)
+	}))
+	math.RawSetString("randomseed", L.NewFunction(func(L *lua.LState) int {
+		rng.Seed(L.CheckInt64(1))
+		return 0
+	}))
+
+	// where returns the script's line calling the function running.
+	where := func() string {
+		return strings.TrimSuffix(L.Where(1), ":")
+	}
+	if file.Cameras == nil {
+		file.Cameras = map[string]SceneCamera{}
+	}
+	if file.Materials == nil {
+		file.Materials = map[string]SceneMaterial{}
+	}
+	L.SetGlobal("camera", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		var camera SceneCamera
+		luaDecode(L, L.CheckTable(2), &camera)
+		file.Cameras[name] = camera
+		positions["cameras."+name] = where()
+		return 0
+	}))
+	L.SetGlobal("material", L.NewFunction(func(L *lua.LState) int {
+		name := L.CheckString(1)
+		var material SceneMaterial
+		luaDecode(L, L.CheckTable(2), &material)
+		file.Materials[name] = material
+		positions["materials."+name] = where()
+		return 0
+	}))
+	L.SetGlobal("object", L.NewFunction(func(L *lua.LState) int {
+		var object SceneObject
+		luaDecode(L, L.CheckTable(1), &object)
+		positions[fmt.Sprintf("objects[%d]", len(file.Objects))] = where()
+		file.Objects = append(file.Objects, object)
+		return 0
+	}))
+	L.SetGlobal("light", L.NewFunction(func(L *lua.LState) int {
+		var light SceneLight
+		luaDecode(L, L.CheckTable(1), &light)
+		positions[fmt.Sprintf("lights[%d]", len(file.Lights))] = where()
+		file.Lights = append(file.Lights, light)
+		return 0
+	}))
+
+	if err := L.DoFile(path); err != nil {
+		var api *lua.ApiError
+		if errors.As(err, &api) {
+			return errors.New(api.Object.String()) // without the Go stack trace
+		}
+		return err
+	}
+	return nil
+}
+
+// luaRandom is Lua's math.random: a number in [0, 1), or an integer from 1
+// or m to n.
+func luaRandom(L *lua.LState, rng *rand.Rand) int {
+	switch L.GetTop() {
+	case 0:
+		L.Push(lua.LNumber(rng.Float64()))
+	case 1:
+		n := L.CheckInt(1)
+		if n < 1 {
+			L.ArgError(1, "interval is empty")
+		}
+		L.Push(lua.LNumber(1 + rng.Intn(n)))
+	default:
+		m, n := L.CheckInt(1), L.CheckInt(2)
+		if m > n {
+			L.ArgError(2, "interval is empty")
+		}
+		L.Push(lua.LNumber(m + rng.Intn(n-m+1)))
+	}
+	return 1
+}
+
+// luaDecode decodes a table into a part of a scene file as if it were the
+// same JSON, raising a Lua error at the calling line if it does not fit.
+func luaDecode(L *lua.LState, table *lua.LTable, v interface{}) {
+	data, err := json.Marshal(luaValue(table))
+	if err != nil {
+		L.RaiseError("numbers should be finite")
+	}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		var kind *json.UnmarshalTypeError
+		if errors.As(err, &kind) {
+			L.RaiseError("%s should be %s, not %s", kind.Field, jsonKind(kind.Type), kind.Value)
+		}
+		L.RaiseError("%s", strings.TrimPrefix(err.Error(), "json: "))
+	}
+}
+
+// luaValue converts a Lua value to the Go value encoding/json would decode
+// the same JSON to: tables with elements 1 to n become arrays, and other
+// tables objects.
+func luaValue(v lua.LValue) interface{} {
+	switch v := v.(type) {
+	case lua.LNumber:
+		return float64(v)
+	case lua.LString:
+		return string(v)
+	case lua.LBool:
+		return bool(v)
+	case *lua.LTable:
+		if n := v.Len(); n > 0 {
+			list := make([]interface{}, n)
+			for i := range list {
+				list[i] = luaValue(v.RawGetInt(i + 1))
+			}
+			return list
+		}
+		object := map[string]interface{}{}
+		v.ForEach(func(key lua.LValue, value lua.LValue) {
+			object[key.String()] = luaValue(value)
+		})
+		return object
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import "math"
+
+// SDF returns the signed distance from p to a surface: negative inside,
+// positive outside, and never more than the true distance.
+type SDF func(p Vector) float64
+
+// Raymarched is an object drawn by sphere tracing an SDF rather than by
+// solving for an intersection analytically.
+type Raymarched struct {
+	sdf          SDF
+	max_steps    int
+	epsilon      float64 // distance at which the march counts as a hit
+	max_distance float64 // distance at which the march gives up
+	material     *Material
+}
+
+func MakeRaymarched(sdf SDF, max_steps int, material *Material) Raymarched {
+	var r Raymarched
+	r.sdf = sdf
+	r.max_steps = max_steps
+	r.epsilon = 1e-4
+	r.max_distance = 100
+	r.material = material
+	return r
+}
+
+func (r *Raymarched) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	// The SDF speaks in world distances, so step by distance/|direction| to
+	// stay in the caller's t units.
+	length := norm(direction)
+	t_max = math.Min(t_max, r.max_distance/length)
+	t := t_min
+	// Rays leaving the surface (shadows, reflections) start inside the hit
+	// band; step them clear of it before accepting a hit. Rays that are
+	// then inside, as refracted rays are, march to where they leave.
+	escaping, inside := true, false
+	for i := 0; i < r.max_steps && t <= t_max; i++ {
+		dist := r.sdf(add(origin, scale(direction, t)))
+		if escaping {
+			if math.Abs(dist) < r.epsilon {
+				t += r.epsilon / length
+				continue
+			}
+			escaping, inside = false, dist < 0
+		}
+		if inside {
+			dist = -dist
+		}
+		if dist < r.epsilon {
+			return t, true
+		}
+		t += dist / length
+	}
+	return math.Inf(1), false
+}
+
+func (r *Raymarched) NormalAt(point Vector) Vector {
+	// Central differences of the distance field.
+	const h = 1e-5
+	dx := r.sdf(add(point, MakeVector(h, 0, 0))) - r.sdf(sub(point, MakeVector(h, 0, 0)))
+	dy := r.sdf(add(point, MakeVector(0, h, 0))) - r.sdf(sub(point, MakeVector(0, h, 0)))
+	dz := r.sdf(add(point, MakeVector(0, 0, h))) - r.sdf(sub(point, MakeVector(0, 0, h)))
+	return normalize(MakeVector(dx, dy, dz))
+}
+
+func (r *Raymarched) Material() *Material {
+	return r.material
+}
+
+func SphereSDF(center Vector, radius float64) SDF {
+	return func(p Vector) float64 {
+		return norm(sub(p, center)) - radius
+	}
+}
+
+func BoxSDF(center Vector, half_size Vector) SDF {
+	return func(p Vector) float64 {
+		q := sub(abs(sub(p, center)), half_size)
+		outside := norm(MakeVector(math.Max(q.x, 0), math.Max(q.y, 0), math.Max(q.z, 0)))
+		inside := math.Min(math.Max(q.x, math.Max(q.y, q.z)), 0)
+		return outside + inside
+	}
+}
+
+// TorusSDF is a torus lying in the xz-plane around center.
+func TorusSDF(center Vector, major_radius float64, minor_radius float64) SDF {
+	return func(p Vector) float64 {
+		q := sub(p, center)
+		ring := math.Hypot(q.x, q.z) - major_radius
+		return math.Hypot(ring, q.y) - minor_radius
+	}
+}
+
+// SmoothUnion blends two fields together over a region of width k.
+func SmoothUnion(a SDF, b SDF, k float64) SDF {
+	return func(p Vector) float64 {
+		da, db := a(p), b(p)
+		h := math.Max(k-math.Abs(da-db), 0) / k
+		return math.Min(da, db) - h*h*k/4
+	}
+}
+
+// MandelbulbSDF is the distance estimate of the power-n Mandelbulb fractal,
+// which has a radius of roughly size around center.
+func MandelbulbSDF(center Vector, size float64, power float64, iterations int) SDF {
+	return func(p Vector) float64 {
+		c := scale(sub(p, center), 1/size)
+		z := c
+		dr := 1.
+		r := 0.
+		for i := 0; i < iterations; i++ {
+			r = norm(z)
+			if r > 2 {
+				break
+			}
+			if r == 0 {
+				return 0 // the center is inside, and has no angles
+			}
+			theta := math.Acos(z.z/r) * power
+			phi := math.Atan2(z.y, z.x) * power
+			dr = math.Pow(r, power-1)*power*dr + 1
+			zr := math.Pow(r, power)
+			z = add(scale(MakeVector(math.Sin(theta)*math.Cos(phi), math.Sin(phi)*math.Sin(theta), math.Cos(theta)), zr), c)
+		}
+		if r == 0 {
+			return 0 // no iterations
+		}
+		return 0.5 * math.Log(r) * r / dr * size
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRaymarchedSphere(t *testing.T) {
+	center, radius := MakeVector(0.5, -0.25, 4), 1.5
+	sphere := MakeSphere(center, radius, nil)
+	marched := MakeRaymarched(SphereSDF(center, radius), 200, nil)
+	tests := []struct {
+		name              string
+		origin, direction Vector
+	}{
+		{"head on", MakeVector(0, 0, 0), MakeVector(0.5, -0.25, 4)},
+		{"unnormalized", MakeVector(0, 0, 0), MakeVector(0.1, 0, 1)},
+		{"glancing", MakeVector(0, 0, 0), MakeVector(0.5, -0.25+1.4, 4)},
+		{"from inside", center, MakeVector(1, 2, -0.5)},
+	}
+	for _, test := range tests {
+		want, ok := sphere.Intersect(test.origin, test.direction, 1e-3, math.Inf(1))
+		if !ok {
+			t.Fatalf("%s: the analytic sphere is missed", test.name)
+		}
+		got, hit := marched.Intersect(test.origin, test.direction, 1e-3, math.Inf(1))
+		if !hit || math.Abs(got-want)*norm(test.direction) > 1e-3 {
+			t.Errorf("%s: marched to t = %v (hit %v), want %v", test.name, got, hit, want)
+			continue
+		}
+		point := add(test.origin, scale(test.direction, got))
+		if n, want_n := marched.NormalAt(point), sphere.NormalAt(point); norm(sub(n, want_n)) > 1e-3 {
+			t.Errorf("%s: normal %v, want %v", test.name, n, want_n)
+		}
+	}
+
+	// Rays that miss, and rays leaving the surface, as shadow rays do, find
+	// nothing.
+	if _, hit := marched.Intersect(MakeVector(0, 0, 0), MakeVector(0, 1, 0), 1e-3, math.Inf(1)); hit {
+		t.Error("a ray away from the sphere hits it")
+	}
+	surface := add(center, MakeVector(0, radius, 0))
+	if _, hit := marched.Intersect(surface, MakeVector(0, 1, 0), 1e-3, math.Inf(1)); hit {
+		t.Error("a ray leaving the surface hits it again")
+	}
+}
+
+func TestMandelbulbCenter(t *testing.T) {
+	sdf := MandelbulbSDF(MakeVector(1, 2, 3), 1, 8, 10)
+	if d := sdf(MakeVector(1, 2, 3)); d != 0 {
+		t.Errorf("distance at the center is %v, want 0", d)
+	}
+	if d := sdf(MakeVector(4, 2, 3)); d <= 0 || math.IsNaN(d) {
+		t.Errorf("distance well outside is %v, want above 0", d)
+	}
+}
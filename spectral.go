@@ -0,0 +1,124 @@
+package main
+
+import "math"
+
+// The visible wavelengths, in nanometers, that spectral rendering samples.
+const (
+	minWavelength = 380
+	maxWavelength = 720
+)
+
+// channelWavelengths are wavelengths, in nanometers, near the dominant
+// wavelengths of the sRGB red, green and blue primaries, at which RGB
+// rendering refracts each channel through dispersive materials.
+var channelWavelengths = [3]float64{610, 550, 465}
+
+// onlyChannel returns a color with channel i of c and the others zero.
+func onlyChannel(c Color, i int) Color {
+	var only Color
+	switch i {
+	case 0:
+		only.r = c.r
+	case 1:
+		only.g = c.g
+	default:
+		only.b = c.b
+	}
+	return only
+}
+
+// spectralBasis returns the weights at a wavelength of the smooth blue,
+// green and red reflectance spectra that an RGB color is a mix of. They
+// sum to 1 everywhere, so white becomes a flat spectrum.
+func spectralBasis(lambda float64) (float64, float64, float64) {
+	blue := 1 / (1 + math.Exp((lambda-490)/10))
+	red := 1 / (1 + math.Exp((590-lambda)/10))
+	return red, 1 - red - blue, blue
+}
+
+// SpectralValue returns the spectrum an RGB color stands for at a
+// wavelength, as a gray color so it can be carried through the RGB code.
+func SpectralValue(c Color, lambda float64) Color {
+	r, g, b := spectralBasis(lambda)
+	v := c.r*r + c.g*g + c.b*b
+	return Color{v, v, v}
+}
+
+// cieXYZ is Wyman, Sloan and Shirley's multi-lobe fit of the CIE 1931
+// standard observer's color matching functions.
+func cieXYZ(lambda float64) (float64, float64, float64) {
+	lobe := func(mu float64, sigma1 float64, sigma2 float64) float64 {
+		t := (lambda - mu) / sigma1
+		if lambda >= mu {
+			t = (lambda - mu) / sigma2
+		}
+		return math.Exp(-t * t / 2)
+	}
+	x := 1.056*lobe(599.8, 37.9, 31.0) + 0.362*lobe(442.0, 16.0, 26.7) - 0.065*lobe(501.1, 20.4, 26.2)
+	y := 0.821*lobe(568.8, 46.9, 40.5) + 0.286*lobe(530.9, 16.3, 31.1)
+	z := 1.217*lobe(437.0, 11.8, 36.0) + 0.681*lobe(459.0, 26.0, 13.8)
+	return x, y, z
+}
+
+// wavelengthRGB is the linear sRGB response to light of one wavelength.
+func wavelengthRGB(lambda float64) Color {
+	x, y, z := cieXYZ(lambda)
+	return Color{
+		3.2406*x - 1.5372*y - 0.4986*z,
+		-0.9689*x + 1.8758*y + 0.0415*z,
+		0.0557*x - 0.2040*y + 1.0570*z,
+	}
+}
+
+// spectralMatrix inverts the RGB response to each basis spectrum, so that
+// light with the spectrum of an RGB color is seen as that color again.
+var spectralMatrix = func() [3][3]float64 {
+	var m [3][3]float64 // columns are the responses to red, green and blue
+	for lambda := float64(minWavelength); lambda < maxWavelength; lambda++ {
+		rgb := wavelengthRGB(lambda + 0.5)
+		r, g, b := spectralBasis(lambda + 0.5)
+		for i, w := range [3]float64{r, g, b} {
+			m[0][i] += rgb.r * w
+			m[1][i] += rgb.g * w
+			m[2][i] += rgb.b * w
+		}
+	}
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	var inv [3][3]float64
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			// The cofactor of m[j][i], over the determinant
+			a, b := (j+1)%3, (j+2)%3
+			c, d := (i+1)%3, (i+2)%3
+			inv[i][j] = (m[a][c]*m[b][d] - m[a][d]*m[b][c]) / det
+		}
+	}
+	return inv
+}()
+
+// SpectralRGB returns the RGB color of radiance v at a wavelength sampled
+// uniformly over the visible range, so that the mean over many samples is
+// the color of the spectrum.
+func SpectralRGB(v float64, lambda float64) Color {
+	rgb := WeightColor(wavelengthRGB(lambda), v)
+	m := &spectralMatrix
+	return WeightColor(Color{
+		m[0][0]*rgb.r + m[0][1]*rgb.g + m[0][2]*rgb.b,
+		m[1][0]*rgb.r + m[1][1]*rgb.g + m[1][2]*rgb.b,
+		m[2][0]*rgb.r + m[2][1]*rgb.g + m[2][2]*rgb.b,
+	}, maxWavelength-minWavelength)
+}
+
+// IOR returns the material's index of refraction at a wavelength in
+// nanometers, by Cauchy's equation with dispersion as its B coefficient in
+// square micrometers, so that ior is the index for yellow sodium light. A
+// wavelength of 0 stands for no particular wavelength and returns ior.
+func (m *Material) IOR(lambda float64) float64 {
+	if lambda == 0 || m.dispersion == 0 {
+		return m.ior
+	}
+	um := lambda / 1000
+	return m.ior + m.dispersion*(1/(um*um)-1/(0.5893*0.5893))
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoadSTL reads an ASCII or binary STL file. STL stores every triangle
+// separately, so coincident vertices are merged to build a shared mesh.
+func LoadSTL(path string, material *Material) (Mesh, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Mesh{}, err
+	}
+
+	var triangles [][3]Vector
+	// Binary files may also start with "solid", so trust the size check.
+	if len(data) >= 84 && len(data) == 84+50*int(binary.LittleEndian.Uint32(data[80:84])) {
+		triangles = parseBinarySTL(data)
+	} else if bytes.HasPrefix(bytes.TrimSpace(data), []byte("solid")) {
+		triangles, err = parseASCIISTL(path, data)
+		if err != nil {
+			return Mesh{}, err
+		}
+	} else {
+		return Mesh{}, fmt.Errorf("%s: not an STL file", path)
+	}
+	if len(triangles) == 0 {
+		return Mesh{}, fmt.Errorf("%s: no facets", path)
+	}
+
+	var vertices []Vector
+	faces := make([][3]int, 0, len(triangles))
+	index := make(map[Vector]int)
+	for _, tri := range triangles {
+		var face [3]int
+		for i, v := range tri {
+			n, ok := index[v]
+			if !ok {
+				n = len(vertices)
+				index[v] = n
+				vertices = append(vertices, v)
+			}
+			face[i] = n
+		}
+		faces = append(faces, face)
+	}
+	return MakeMesh(vertices, faces, material), nil
+}
+
+func parseBinarySTL(data []byte) [][3]Vector {
+	count := int(binary.LittleEndian.Uint32(data[80:84]))
+	triangles := make([][3]Vector, count)
+	f := func(off int) float64 {
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(data[off : off+4])))
+	}
+	for i := range triangles {
+		// Each record: normal (3 floats), 3 vertices (9 floats), 2 spare bytes.
+		off := 84 + 50*i + 12
+		for v := 0; v < 3; v++ {
+			o := off + 12*v
+			triangles[i][v] = MakeVector(f(o), f(o+4), f(o+8))
+		}
+	}
+	return triangles
+}
+
+func parseASCIISTL(path string, data []byte) ([][3]Vector, error) {
+	var triangles [][3]Vector
+	var current [3]Vector
+	n := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line_no := 0
+	for scanner.Scan() {
+		line_no++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "facet":
+			n = 0
+		case "vertex":
+			if len(fields) != 4 || n >= 3 {
+				return nil, fmt.Errorf("%s:%d: malformed vertex", path, line_no)
+			}
+			var xyz [3]float64
+			for i := range xyz {
+				v, err := strconv.ParseFloat(fields[i+1], 64)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: bad vertex coordinate %q", path, line_no, fields[i+1])
+				}
+				xyz[i] = v
+			}
+			current[n] = MakeVector(xyz[0], xyz[1], xyz[2])
+			n++
+		case "endfacet":
+			if n != 3 {
+				return nil, fmt.Errorf("%s:%d: facet has %d vertices, want 3", path, line_no, n)
+			}
+			triangles = append(triangles, current)
+		}
+	}
+	return triangles, scanner.Err()
+}
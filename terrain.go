@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+)
+
+// LoadHeightmap builds a terrain mesh from a grayscale image, where black
+// is the lowest and white the highest ground.
+func LoadHeightmap(path string, corner Vector, size Vector, material *Material) (Mesh, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Mesh{}, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return Mesh{}, fmt.Errorf("%s: %v", path, err)
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() < 2 || bounds.Dy() < 2 {
+		return Mesh{}, fmt.Errorf("%s: heightmap must be at least 2x2 pixels", path)
+	}
+	heights := make([][]float64, bounds.Dy())
+	for j := range heights {
+		heights[j] = make([]float64, bounds.Dx())
+		for i := range heights[j] {
+			gray := grayLevel(img.At(bounds.Min.X+i, bounds.Min.Y+j))
+			heights[j][i] = float64(gray) / 0xffff
+		}
+	}
+	return MakeTerrain(heights, corner, size, material), nil
+}
+
+// grayLevel converts a pixel of any color model to a 16-bit gray level.
+func grayLevel(c color.Color) uint16 {
+	return color.Gray16Model.Convert(c).(color.Gray16).Y
+}
+
+// MakeTerrain tessellates a grid of heights in [0, 1] into two triangles
+// per cell. The grid covers size.x by size.z starting at corner, rising up
+// to size.y; row 0 is the far (+z) edge so the grid reads like a map, and
+// texture coordinates stretch over the whole grid.
+func MakeTerrain(heights [][]float64, corner Vector, size Vector, material *Material) Mesh {
+	rows, cols := len(heights), len(heights[0])
+	vertices := make([]Vector, 0, rows*cols)
+	uvs := make([][2]float64, 0, rows*cols)
+	for j := 0; j < rows; j++ {
+		for i := 0; i < cols; i++ {
+			vertices = append(vertices, MakeVector(
+				corner.x+size.x*float64(i)/float64(cols-1),
+				corner.y+size.y*heights[j][i],
+				corner.z+size.z*(1-float64(j)/float64(rows-1)),
+			))
+			uvs = append(uvs, [2]float64{float64(i) / float64(cols-1), 1 - float64(j)/float64(rows-1)})
+		}
+	}
+	faces := make([][3]int, 0, 2*(rows-1)*(cols-1))
+	for j := 0; j+1 < rows; j++ {
+		for i := 0; i+1 < cols; i++ {
+			a := j*cols + i
+			b, c, d := a+1, a+cols, a+cols+1
+			faces = append(faces, [3]int{a, b, c}, [3]int{b, d, c}) // wound to face +y
+		}
+	}
+	m := MakeMesh(vertices, faces, material)
+	m.uvs = uvs
+	return m
+}
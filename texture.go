@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+)
+
+// Texture varies a surface's color across it. ColorAt is given both the
+// texture coordinates of the point, for textures painted onto the surface,
+// and the point itself, for solid textures carved out of space.
+type Texture interface {
+	ColorAt(u float64, v float64, point Vector) Color
+}
+
+// uvAt returns the texture coordinates of a point on the primitive, or
+// (0, 0) if it has no mapping.
+func uvAt(p Primitive, point Vector) (float64, float64) {
+	if mapped, ok := p.(UVMapped); ok {
+		return mapped.UVAt(point)
+	}
+	return 0, 0
+}
+
+// ImageTexture wraps an image around a surface, repeating it outside
+// [0, 1] and filtering between pixels bilinearly. v = 1 is the top row.
+type ImageTexture struct {
+	img image.Image
+}
+
+func LoadImageTexture(path string) (ImageTexture, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ImageTexture{}, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return ImageTexture{}, fmt.Errorf("%s: %v", path, err)
+	}
+	return ImageTexture{img}, nil
+}
+
+func (t *ImageTexture) ColorAt(u float64, v float64, point Vector) Color {
+	bounds := t.img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	x := (u-math.Floor(u))*float64(w) - 0.5
+	y := (1-v+math.Floor(v))*float64(h) - 0.5
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	c00 := t.texel(int(x0), int(y0))
+	c10 := t.texel(int(x0)+1, int(y0))
+	c01 := t.texel(int(x0), int(y0)+1)
+	c11 := t.texel(int(x0)+1, int(y0)+1)
+	top := AddColors(WeightColor(c00, 1-fx), WeightColor(c10, fx))
+	bottom := AddColors(WeightColor(c01, 1-fx), WeightColor(c11, fx))
+	return AddColors(WeightColor(top, 1-fy), WeightColor(bottom, fy))
+}
+
+// texel returns the pixel at (x, y), wrapping around the image edges.
+func (t *ImageTexture) texel(x int, y int) Color {
+	bounds := t.img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	x, y = ((x%w)+w)%w, ((y%h)+h)%h
+	r, g, b, _ := t.img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+	return MakeColor(float64(r)/0xffff, float64(g)/0xffff, float64(b)/0xffff)
+}
+
+// CheckerTexture alternates between two colors in squares of side 1/scale
+// in texture space. On a plane, whose coordinates are distances, that
+// gives scale squares per unit.
+type CheckerTexture struct {
+	a     Color
+	b     Color
+	scale float64
+}
+
+func MakeCheckerTexture(a Color, b Color, scale float64) CheckerTexture {
+	var t CheckerTexture
+	t.a = a
+	t.b = b
+	t.scale = scale
+	return t
+}
+
+func (t *CheckerTexture) ColorAt(u float64, v float64, point Vector) Color {
+	if int(math.Floor(u*t.scale)+math.Floor(v*t.scale))%2 == 0 {
+		return t.a
+	}
+	return t.b
+}
@@ -0,0 +1,41 @@
+package main
+
+// ToneMap compresses a linear color of any brightness towards [0, 1] with
+// the operator named by scene.tonemap, so highlights brighter than white
+// roll off instead of clipping. "none", the default, leaves colors as they
+// are for Encode to clamp.
+func (s *Scene) ToneMap(c Color) Color {
+	switch s.tonemap {
+	case "reinhard":
+		// Scaling by luminance keeps hues that per-channel curves would
+		// wash towards white.
+		return WeightColor(c, 1/(1+luminance(c)))
+	case "aces":
+		return Color{acesFilmic(c.r), acesFilmic(c.g), acesFilmic(c.b)}
+	case "filmic":
+		// Hable's exposure bias of 2 brings the curve's midtones to
+		// those of the other operators.
+		white := hableFilmic(hableWhite)
+		return Color{hableFilmic(2*c.r) / white, hableFilmic(2*c.g) / white, hableFilmic(2*c.b) / white}
+	}
+	return c
+}
+
+// acesFilmic is Krzysztof Narkowicz's fit of the ACES reference rendering
+// transform and output transform for an sRGB display.
+func acesFilmic(v float64) float64 {
+	const a, b, c, d, e = 2.51, 0.03, 2.43, 0.59, 0.14
+	// The fit is made for colors scaled by 0.6, the typical exposure.
+	v *= 0.6
+	return (v * (a*v + b)) / (v*(c*v+d) + e)
+}
+
+// hableWhite is the linear value the filmic curve maps to white.
+const hableWhite = 11.2
+
+// hableFilmic is John Hable's filmic curve from Uncharted 2, with a toe
+// that deepens shadows and a long shoulder, before scaling for white.
+func hableFilmic(v float64) float64 {
+	const A, B, C, D, E, F = 0.15, 0.50, 0.10, 0.20, 0.02, 0.30
+	return (v*(A*v+C*B)+D*E)/(v*(A*v+B)+D*F) - E/F
+}
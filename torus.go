@@ -0,0 +1,187 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// Torus is a ring of tube radius minor_radius swept around axis at
+// major_radius from center.
+type Torus struct {
+	center       Vector
+	axis         Vector
+	major_radius float64
+	minor_radius float64
+	material     *Material
+	u            Vector // u, v and axis form an orthonormal basis
+	v            Vector
+}
+
+func MakeTorus(center Vector, axis Vector, major_radius float64, minor_radius float64, material *Material) Torus {
+	var t Torus
+	t.center = center
+	t.axis = normalize(axis)
+	t.major_radius = major_radius
+	t.minor_radius = minor_radius
+	t.material = material
+	t.u, t.v = perpendicularBasis(t.axis)
+	return t
+}
+
+func (t *Torus) toLocal(a Vector) Vector {
+	return MakeVector(dot(a, t.u), dot(a, t.v), dot(a, t.axis))
+}
+
+func (t *Torus) toWorld(a Vector) Vector {
+	return add(add(scale(t.u, a.x), scale(t.v, a.y)), scale(t.axis, a.z))
+}
+
+// IntersectRayTorus returns the nearest hit beyond t_min.
+func IntersectRayTorus(origin Vector, direction Vector, torus Torus, t_min float64) float64 {
+	best_t, _ := nearestIn(t_min, math.Inf(1), torusHits(origin, direction, torus)...)
+	return best_t
+}
+
+// torusHits returns every t at which the ray crosses the torus surface.
+func torusHits(origin Vector, direction Vector, torus Torus) []float64 {
+	// Work in the torus frame (axis along z) with a unit direction so the
+	// quartic is monic; distances are scaled back to the caller's direction.
+	length := norm(direction)
+	o := torus.toLocal(sub(origin, torus.center))
+	d := torus.toLocal(scale(direction, 1/length))
+
+	R2 := torus.major_radius * torus.major_radius
+	r2 := torus.minor_radius * torus.minor_radius
+	e := dot(o, o) - R2 - r2
+	f := dot(o, d)
+	roots := SolveQuartic(
+		4*f,
+		2*e+4*f*f+4*R2*d.z*d.z,
+		4*f*e+8*R2*o.z*d.z,
+		e*e-4*R2*(r2-o.z*o.z),
+	)
+	for i := range roots {
+		roots[i] /= length
+	}
+	return roots
+}
+
+func (t *Torus) Intersect(origin Vector, direction Vector, t_min float64, t_max float64) (float64, bool) {
+	return nearestIn(t_min, t_max, IntersectRayTorus(origin, direction, *t, t_min))
+}
+
+func (t *Torus) NormalAt(point Vector) Vector {
+	// Point minus the nearest point on the ring through the tube centers.
+	p := t.toLocal(sub(point, t.center))
+	ring := normalize(MakeVector(p.x, p.y, 0))
+	return t.toWorld(normalize(sub(p, scale(ring, t.major_radius))))
+}
+
+func (t *Torus) Material() *Material {
+	return t.material
+}
+
+func (t *Torus) Bounds() AABB {
+	e := add(discExtent(t.axis, t.major_radius), MakeVector(t.minor_radius, t.minor_radius, t.minor_radius))
+	return AABB{sub(t.center, e), add(t.center, e)}
+}
+
+func (t *Torus) Spans(origin Vector, direction Vector) []Span {
+	return spansFromHits(t, torusHits(origin, direction, *t))
+}
+
+// SolveQuartic returns the real roots of x^4 + a*x^3 + b*x^2 + c*x + d in
+// increasing order, using Ferrari's method, polished with a few Newton
+// steps.
+func SolveQuartic(a float64, b float64, c float64, d float64) []float64 {
+	// Depress with x = y - a/4: y^4 + p*y^2 + q*y + r.
+	a2 := a * a
+	p := b - 3*a2/8
+	q := c - a*b/2 + a2*a/8
+	r := d - a*c/4 + a2*b/16 - 3*a2*a2/256
+
+	var ys []float64
+	if math.Abs(q) < 1e-12 {
+		// Biquadratic: solve for y^2.
+		for _, z := range SolveQuadratic(1, p, r) {
+			if z >= 0 {
+				ys = append(ys, math.Sqrt(z), -math.Sqrt(z))
+			}
+		}
+	} else {
+		// Resolvent cubic 8m^3 + 8p*m^2 + (2p^2 - 8r)*m - q^2 always has a
+		// positive root, which splits the quartic into two quadratics.
+		m := 0.
+		for _, root := range SolveCubic(p, p*p/4-r, -q*q/8) {
+			m = math.Max(m, root)
+		}
+		if m <= 0 {
+			return nil
+		}
+		s := math.Sqrt(2 * m)
+		k := s * q / (4 * m)
+		ys = append(ys, SolveQuadratic(1, -s, p/2+m+k)...)
+		ys = append(ys, SolveQuadratic(1, s, p/2+m-k)...)
+	}
+
+	roots := make([]float64, len(ys))
+	for i, y := range ys {
+		x := y - a/4
+		for n := 0; n < 3; n++ {
+			fx := (((x+a)*x+b)*x+c)*x + d
+			dfx := ((4*x+3*a)*x+2*b)*x + c
+			if dfx == 0 {
+				break
+			}
+			x -= fx / dfx
+		}
+		roots[i] = x
+	}
+	sort.Float64s(roots)
+	return roots
+}
+
+// SolveCubic returns the real roots of x^3 + a*x^2 + b*x + c in increasing
+// order.
+func SolveCubic(a float64, b float64, c float64) []float64 {
+	// Depress with x = y - a/3: y^3 + p*y + q.
+	p := b - a*a/3
+	q := 2*a*a*a/27 - a*b/3 + c
+	shift := -a / 3
+	discrim := q*q/4 + p*p*p/27
+	if discrim > 0 {
+		sq := math.Sqrt(discrim)
+		return []float64{math.Cbrt(-q/2+sq) + math.Cbrt(-q/2-sq) + shift}
+	}
+	if p == 0 {
+		return []float64{shift}
+	}
+	// Three real roots: trigonometric form.
+	rho := 2 * math.Sqrt(-p/3)
+	theta := math.Acos(math.Max(-1, math.Min(1, 3*q/(p*rho))))
+	roots := []float64{
+		rho*math.Cos(theta/3) + shift,
+		rho*math.Cos((theta+2*math.Pi)/3) + shift,
+		rho*math.Cos((theta+4*math.Pi)/3) + shift,
+	}
+	sort.Float64s(roots)
+	return roots
+}
+
+// SolveQuadratic returns the real roots of a*x^2 + b*x + c.
+func SolveQuadratic(a float64, b float64, c float64) []float64 {
+	discrim := b*b - 4*a*c
+	if discrim < 0 {
+		return nil
+	}
+	// Avoid cancellation by computing the larger-magnitude root first.
+	sq := math.Sqrt(discrim)
+	if b < 0 {
+		sq = -sq
+	}
+	q := -(b + sq) / 2
+	if q == 0 {
+		return []float64{0, 0}
+	}
+	return []float64{q / a, c / q}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"testing"
+)
+
+// checkRoots fails the test unless got holds want, with repeated roots
+// repeated, in increasing order, each within tolerance.
+func checkRoots(t *testing.T, name string, got []float64, want []float64, tolerance float64) {
+	t.Helper()
+	if !sort.Float64sAreSorted(got) {
+		t.Errorf("%s = %v, not in increasing order", name, got)
+	}
+	if len(got) != len(want) {
+		t.Errorf("%s = %v, want %v", name, got, want)
+		return
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > tolerance {
+			t.Errorf("%s = %v, want %v", name, got, want)
+			return
+		}
+	}
+}
+
+func TestSolveCubic(t *testing.T) {
+	tests := []struct {
+		a, b, c float64
+		want    []float64
+	}{
+		{-6, 11, -6, []float64{1, 2, 3}},      // (x-1)(x-2)(x-3)
+		{0, -3, 2, []float64{-2, 1, 1}},       // (x-1)^2 (x+2)
+		{-3, 3, -1, []float64{1}},             // (x-1)^3
+		{0, 1, 0, []float64{0}},               // x (x^2+1)
+		{-1, 1, -1, []float64{1}},             // (x-1)(x^2+1)
+		{2, -5, -6, []float64{-3, -1, 2}},     // (x+1)(x-2)(x+3)
+		{0, 0, -8, []float64{2}},              // x^3 - 8
+		{-4.5, 5, -1.5, []float64{0.5, 1, 3}}, // (x-0.5)(x-1)(x-3)
+	}
+	for _, test := range tests {
+		got := SolveCubic(test.a, test.b, test.c)
+		checkRoots(t, "SolveCubic", got, test.want, 1e-6)
+	}
+}
+
+func TestSolveQuartic(t *testing.T) {
+	tests := []struct {
+		a, b, c, d float64
+		want       []float64
+		tolerance  float64
+	}{
+		{-10, 35, -50, 24, []float64{1, 2, 3, 4}, 1e-9},  // (x-1)(x-2)(x-3)(x-4)
+		{0, -5, 0, 4, []float64{-2, -1, 1, 2}, 1e-9},     // biquadratic (x^2-1)(x^2-4)
+		{-6, 13, -12, 4, []float64{1, 1, 2, 2}, 1e-4},    // (x-1)^2 (x-2)^2
+		{0, -2, 0, 1, []float64{-1, -1, 1, 1}, 1e-4},     // (x^2-1)^2
+		{-3, 2, 0, 0, []float64{0, 0, 1, 2}, 1e-4},       // x^2 (x-1)(x-2)
+		{-3, 3, -3, 2, []float64{1, 2}, 1e-9},            // (x-1)(x-2)(x^2+1)
+		{0, 0, 0, 1, nil, 0},                             // x^4 + 1
+		{0, 5, 0, 4, nil, 0},                             // (x^2+1)(x^2+4)
+		{-2, 3, -2, 2, nil, 0},                           // (x^2+1)(x^2-2x+2)
+		{2, -13, -14, 24, []float64{-4, -2, 1, 3}, 1e-9}, // (x-1)(x+2)(x-3)(x+4)
+	}
+	for _, test := range tests {
+		got := SolveQuartic(test.a, test.b, test.c, test.d)
+		checkRoots(t, "SolveQuartic", got, test.want, test.tolerance)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// watchInterval is how often Watch looks at the files it watches.
+const watchInterval = 250 * time.Millisecond
+
+// Watch calls rebuild each time one of files changes, appears or goes away,
+// with the files to watch from then on returned by rebuild. It waits for a
+// change to settle first, so as not to read a file an editor is still
+// writing. Watch runs until the program is interrupted.
+func Watch(files []string, rebuild func() []string) {
+	stamps := fileStamps(files)
+	for {
+		time.Sleep(watchInterval)
+		now := fileStamps(files)
+		if sameStamps(now, stamps) {
+			continue
+		}
+		for {
+			time.Sleep(watchInterval)
+			settled := fileStamps(files)
+			if sameStamps(settled, now) {
+				break
+			}
+			now = settled
+		}
+		files = rebuild()
+		stamps = fileStamps(files)
+	}
+}
+
+// fileStamp is what Watch compares of a file to tell it has changed.
+type fileStamp struct {
+	exists   bool
+	size     int64
+	modified time.Time
+}
+
+func fileStamps(files []string) map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	for _, path := range files {
+		if info, err := os.Stat(path); err == nil {
+			stamps[path] = fileStamp{true, info.Size(), info.ModTime()}
+		} else {
+			stamps[path] = fileStamp{}
+		}
+	}
+	return stamps
+}
+
+func sameStamps(a map[string]fileStamp, b map[string]fileStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, stamp := range a {
+		if other, ok := b[path]; !ok || !stamp.modified.Equal(other.modified) || stamp.size != other.size || stamp.exists != other.exists {
+			return false
+		}
+	}
+	return true
+}
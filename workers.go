@@ -0,0 +1,154 @@
+package main
+
+import (
+	"log"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// inWorkers runs jobs 0 to n-1 on a pool of runtime.GOMAXPROCS(0)
+// goroutines, which take them from a channel as they finish the last, so
+// slow parts of the image do not hold up the rest. Each worker calls start
+// once with its own copy of the scene, with its own rng and ray count, for
+// the function it runs its jobs with; per-worker buffers such as samplers
+// belong there. The workers' ray timings are merged into the scene's.
+func inWorkers(scene *Scene, n int, start func(worker *Scene) func(job int)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		worker := *scene
+		worker.rng, worker.rays = newRNG(), new(int64)
+		if scene.timings != nil {
+			worker.timings = new(Timings)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			run := start(&worker)
+			for job := range jobs {
+				run(job)
+			}
+			if worker.timings != nil {
+				scene.timings.merge(worker.timings)
+			}
+		}()
+	}
+	for job := 0; job < n; job++ {
+		jobs <- job
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// defaultTileSize is the width and height of the square tiles Render hands
+// to workers, in pixels, when the scene sets none.
+const defaultTileSize = 32
+
+// Tile is the region of the canvas from (x0, y0) up to but not including
+// (x1, y1), in the canvas coordinates PutPixel takes.
+type Tile struct {
+	x0 int
+	y0 int
+	x1 int
+	y1 int
+}
+
+// MakeTiles splits the canvas into size x size tiles, row by row from the
+// bottom, with smaller tiles along the edges where size does not divide
+// the canvas. size is rounded up to an even number, which keeps Render's
+// 2x2 packets within a tile.
+func MakeTiles(size int) []Tile {
+	if size <= 0 {
+		size = defaultTileSize
+	}
+	size += size % 2
+	var tiles []Tile
+	for y := -Ch / 2; y < Ch/2; y += size {
+		for x := -Cw / 2; x < Cw/2; x += size {
+			tile := Tile{x, y, x + size, y + size}
+			if tile.x1 > Cw/2 {
+				tile.x1 = Cw / 2
+			}
+			if tile.y1 > Ch/2 {
+				tile.y1 = Ch / 2
+			}
+			tiles = append(tiles, tile)
+		}
+	}
+	return tiles
+}
+
+// renderTiles renders the canvas tile by tile, on the workers as inWorkers
+// runs jobs or on the scene's farm if it has one, finishing here any tiles
+// the farm fails to. The scene's progress
+// hears of every tile done. With a checkpoint set on the scene it saves
+// the tiles done there as it goes, and resumed it first takes them back
+// and renders only the rest.
+func renderTiles(scene *Scene, camera *Camera, canvas *Canvas, max_recursion_depth int) {
+	tiles := MakeTiles(scene.tile_size)
+	done := make([]bool, len(tiles))
+	key := checkpointKey(scene, camera)
+	if scene.checkpoint != "" && scene.resume {
+		if err := loadCheckpoint(scene.checkpoint, key, canvas, done); err != nil {
+			log.Printf("not resuming: %v", err)
+		}
+	}
+	var todo []int
+	for i := range tiles {
+		if !done[i] {
+			todo = append(todo, i)
+		}
+	}
+
+	var lock sync.Mutex // over done, finished, rays and last_checkpoint
+	finished, total, rays := 0, len(todo), int64(0)
+	began, last_checkpoint := time.Now(), time.Now()
+	finish := func(tile int, tile_rays int64) {
+		lock.Lock()
+		defer lock.Unlock()
+		done[tile] = true
+		finished, rays = finished+1, rays+tile_rays
+		if scene.progress != nil {
+			scene.progress(float64(finished)/float64(total), rays, time.Since(began))
+		}
+		if scene.checkpoint == "" || time.Since(last_checkpoint) < scene.checkpoint_interval {
+			return
+		}
+		if err := saveCheckpoint(scene.checkpoint, key, canvas, done); err != nil {
+			log.Print(err)
+		}
+		last_checkpoint = time.Now()
+	}
+
+	if scene.farm != nil {
+		err := scene.farm.Render(scene, camera, canvas, max_recursion_depth, tiles, todo, finish)
+		todo = todo[:0]
+		if err != nil {
+			log.Printf("%v; rendering the rest here", err)
+			for i := range tiles {
+				if !done[i] {
+					todo = append(todo, i)
+				}
+			}
+		}
+	}
+	if len(todo) > 0 {
+		start := painter(scene, camera, canvas, max_recursion_depth)
+		inWorkers(scene, len(todo), func(worker *Scene) func(job int) {
+			paint := start(worker)
+			return func(job int) {
+				canvas.Paint(func() { paint(tiles[todo[job]]) })
+				tile_rays := *worker.rays
+				*worker.rays = 0
+				finish(todo[job], tile_rays)
+			}
+		})
+	}
+	if scene.checkpoint != "" {
+		if err := os.Remove(scene.checkpoint); err != nil && !os.IsNotExist(err) {
+			log.Print(err)
+		}
+	}
+}